@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: organization_controller.go
+// Source: github.com/vshn/appuio-keycloak-adapter/idp (interfaces: Client)
 
 // Package controllers_test is a generated GoMock package.
 package controllers_test
@@ -9,10 +9,10 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
-	keycloak "github.com/vshn/appuio-keycloak-adapter/keycloak"
+	idp "github.com/vshn/appuio-keycloak-adapter/idp"
 )
 
-// MockKeycloakClient is a mock of KeycloakClient interface.
+// MockKeycloakClient is a mock of Client interface.
 type MockKeycloakClient struct {
 	ctrl     *gomock.Controller
 	recorder *MockKeycloakClientMockRecorder
@@ -36,65 +36,117 @@ func (m *MockKeycloakClient) EXPECT() *MockKeycloakClientMockRecorder {
 }
 
 // DeleteGroup mocks base method.
-func (m *MockKeycloakClient) DeleteGroup(ctx context.Context, path ...string) error {
+func (m *MockKeycloakClient) DeleteGroup(arg0 context.Context, arg1 idp.Group) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{ctx}
-	for _, a := range path {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "DeleteGroup", varargs...)
+	ret := m.ctrl.Call(m, "DeleteGroup", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteGroup indicates an expected call of DeleteGroup.
-func (mr *MockKeycloakClientMockRecorder) DeleteGroup(ctx interface{}, path ...interface{}) *gomock.Call {
+func (mr *MockKeycloakClientMockRecorder) DeleteGroup(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{ctx}, path...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGroup", reflect.TypeOf((*MockKeycloakClient)(nil).DeleteGroup), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGroup", reflect.TypeOf((*MockKeycloakClient)(nil).DeleteGroup), arg0, arg1)
+}
+
+// DeleteUser mocks base method.
+func (m *MockKeycloakClient) DeleteUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockKeycloakClientMockRecorder) DeleteUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockKeycloakClient)(nil).DeleteUser), arg0, arg1)
+}
+
+// DisableUser mocks base method.
+func (m *MockKeycloakClient) DisableUser(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableUser", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableUser indicates an expected call of DisableUser.
+func (mr *MockKeycloakClientMockRecorder) DisableUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableUser", reflect.TypeOf((*MockKeycloakClient)(nil).DisableUser), arg0, arg1)
 }
 
 // ListGroups mocks base method.
-func (m *MockKeycloakClient) ListGroups(ctx context.Context) ([]keycloak.Group, error) {
+func (m *MockKeycloakClient) ListGroups(arg0 context.Context) ([]idp.Group, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListGroups", ctx)
-	ret0, _ := ret[0].([]keycloak.Group)
+	ret := m.ctrl.Call(m, "ListGroups", arg0)
+	ret0, _ := ret[0].([]idp.Group)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListGroups indicates an expected call of ListGroups.
-func (mr *MockKeycloakClientMockRecorder) ListGroups(ctx interface{}) *gomock.Call {
+func (mr *MockKeycloakClientMockRecorder) ListGroups(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListGroups", reflect.TypeOf((*MockKeycloakClient)(nil).ListGroups), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListGroups", reflect.TypeOf((*MockKeycloakClient)(nil).ListGroups), arg0)
+}
+
+// ListUsers mocks base method.
+func (m *MockKeycloakClient) ListUsers(arg0 context.Context) ([]idp.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsers", arg0)
+	ret0, _ := ret[0].([]idp.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUsers indicates an expected call of ListUsers.
+func (mr *MockKeycloakClientMockRecorder) ListUsers(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockKeycloakClient)(nil).ListUsers), arg0)
 }
 
 // PutGroup mocks base method.
-func (m *MockKeycloakClient) PutGroup(ctx context.Context, group keycloak.Group) (keycloak.Group, error) {
+func (m *MockKeycloakClient) PutGroup(arg0 context.Context, arg1 idp.Group) (idp.Group, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PutGroup", ctx, group)
-	ret0, _ := ret[0].(keycloak.Group)
+	ret := m.ctrl.Call(m, "PutGroup", arg0, arg1)
+	ret0, _ := ret[0].(idp.Group)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // PutGroup indicates an expected call of PutGroup.
-func (mr *MockKeycloakClientMockRecorder) PutGroup(ctx, group interface{}) *gomock.Call {
+func (mr *MockKeycloakClientMockRecorder) PutGroup(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGroup", reflect.TypeOf((*MockKeycloakClient)(nil).PutGroup), ctx, group)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGroup", reflect.TypeOf((*MockKeycloakClient)(nil).PutGroup), arg0, arg1)
 }
 
 // PutUser mocks base method.
-func (m *MockKeycloakClient) PutUser(ctx context.Context, user keycloak.User) (keycloak.User, error) {
+func (m *MockKeycloakClient) PutUser(arg0 context.Context, arg1 idp.User) (idp.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PutUser", ctx, user)
-	ret0, _ := ret[0].(keycloak.User)
+	ret := m.ctrl.Call(m, "PutUser", arg0, arg1)
+	ret0, _ := ret[0].(idp.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // PutUser indicates an expected call of PutUser.
-func (mr *MockKeycloakClientMockRecorder) PutUser(ctx, user interface{}) *gomock.Call {
+func (mr *MockKeycloakClientMockRecorder) PutUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutUser", reflect.TypeOf((*MockKeycloakClient)(nil).PutUser), arg0, arg1)
+}
+
+// RemoveUserFromGroups mocks base method.
+func (m *MockKeycloakClient) RemoveUserFromGroups(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveUserFromGroups", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveUserFromGroups indicates an expected call of RemoveUserFromGroups.
+func (mr *MockKeycloakClientMockRecorder) RemoveUserFromGroups(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutUser", reflect.TypeOf((*MockKeycloakClient)(nil).PutUser), ctx, user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveUserFromGroups", reflect.TypeOf((*MockKeycloakClient)(nil).RemoveUserFromGroups), arg0, arg1)
 }
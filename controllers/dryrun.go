@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// dryRunReason is the reason of the events recorded for skipped changes in dry-run mode.
+const dryRunReason = "DryRun"
+
+type involvedObjectKey struct{}
+
+// withInvolvedObject returns a context carrying the object being reconciled.
+// Changes to Keycloak skipped in dry-run mode are evented on this object.
+func withInvolvedObject(ctx context.Context, obj runtime.Object) context.Context {
+	return context.WithValue(ctx, involvedObjectKey{}, obj)
+}
+
+// DryRunKeycloakClient wraps a KeycloakClient and skips all changes to Keycloak.
+// Skipped changes are logged and evented on the object being reconciled, if any.
+// Reads are passed to the wrapped client.
+type DryRunKeycloakClient struct {
+	KeycloakClient
+	Recorder record.EventRecorder
+}
+
+// GroupPlanner plans the changes to a Keycloak group without applying them, see keycloak.Client.PlanGroup.
+type GroupPlanner interface {
+	PlanGroup(ctx context.Context, group idp.Group) (keycloak.GroupPlan, error)
+}
+
+// ErrPlanningNotSupported is returned by a GroupPlanner wrapping clients which can't plan changes.
+var ErrPlanningNotSupported = errors.New("planning changes is not supported")
+
+// PutGroup logs the group and returns it unchanged.
+// If the wrapped client is a GroupPlanner, the planned changes are logged instead.
+func (c DryRunKeycloakClient) PutGroup(ctx context.Context, group idp.Group) (idp.Group, error) {
+	planner, ok := c.KeycloakClient.(GroupPlanner)
+	if !ok {
+		c.skipPut(ctx, group)
+		return group, nil
+	}
+
+	plan, err := planner.PlanGroup(ctx, group)
+	if errors.Is(err, ErrPlanningNotSupported) {
+		c.skipPut(ctx, group)
+		return group, nil
+	}
+	if err != nil {
+		return group, err
+	}
+	if plan.ID() != "" {
+		group = group.WithID(plan.ID())
+	}
+	if plan.Empty() {
+		log.FromContext(ctx).V(1).Info("dry run: no changes to Keycloak group " + group.Path())
+		return group, nil
+	}
+	c.skip(ctx, "%s", describePlan(plan))
+	return group, nil
+}
+
+func (c DryRunKeycloakClient) skipPut(ctx context.Context, group idp.Group) {
+	c.skip(ctx, "would create or update Keycloak group %s with %d members", group.Path(), len(group.Members))
+}
+
+// DeleteGroup logs the group.
+func (c DryRunKeycloakClient) DeleteGroup(ctx context.Context, group idp.Group) error {
+	c.skip(ctx, "would delete Keycloak group %s", group.Path())
+	return nil
+}
+
+// PutUser logs the user and returns it unchanged.
+func (c DryRunKeycloakClient) PutUser(ctx context.Context, user idp.User) (idp.User, error) {
+	c.skip(ctx, "would update Keycloak user %s", user.Username)
+	return user, nil
+}
+
+// RemoveUserFromGroups logs the user.
+func (c DryRunKeycloakClient) RemoveUserFromGroups(ctx context.Context, username string) error {
+	c.skip(ctx, "would remove Keycloak user %s from all groups", username)
+	return nil
+}
+
+// DisableUser logs the user.
+func (c DryRunKeycloakClient) DisableUser(ctx context.Context, username string) error {
+	c.skip(ctx, "would disable Keycloak user %s", username)
+	return nil
+}
+
+// DeleteUser logs the user.
+func (c DryRunKeycloakClient) DeleteUser(ctx context.Context, username string) error {
+	c.skip(ctx, "would delete Keycloak user %s", username)
+	return nil
+}
+
+// describePlan returns a short description of the changes of the given plan.
+func describePlan(plan keycloak.GroupPlan) string {
+	var changes []string
+	if plan.DisplayNameChanged {
+		changes = append(changes, fmt.Sprintf("change display name from %q", plan.PreviousDisplayName))
+	}
+	if plan.AttributesChanged {
+		changes = append(changes, "change attributes")
+	}
+	if len(plan.AddMembers) > 0 {
+		changes = append(changes, "add "+usernames(plan.AddMembers))
+	}
+	if len(plan.RemoveMembers) > 0 {
+		changes = append(changes, "remove "+usernames(plan.RemoveMembers))
+	}
+	action := "update"
+	if plan.Create {
+		action = "create"
+	}
+	msg := fmt.Sprintf("would %s Keycloak group %s", action, plan.Group.Path())
+	if len(changes) > 0 {
+		msg += ": " + strings.Join(changes, ", ")
+	}
+	return msg
+}
+
+func usernames(users []idp.User) string {
+	names := make([]string, len(users))
+	for i := range users {
+		names[i] = users[i].Username
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c DryRunKeycloakClient) skip(ctx context.Context, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.FromContext(ctx).Info("dry run: " + msg)
+	if obj, ok := ctx.Value(involvedObjectKey{}).(runtime.Object); ok && c.Recorder != nil {
+		c.Recorder.Event(obj, "Normal", dryRunReason, msg)
+	}
+}
+
+// DryRunClient wraps a Kubernetes client and skips all writes.
+// Skipped writes are logged and evented on the written object.
+// Reads are passed to the wrapped client, so objects "created" in dry-run mode can't be read back.
+type DryRunClient struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// Create logs the object.
+func (c DryRunClient) Create(ctx context.Context, obj client.Object, _ ...client.CreateOption) error {
+	c.skip(ctx, obj, "would create")
+	return nil
+}
+
+// Update logs the object.
+func (c DryRunClient) Update(ctx context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.skip(ctx, obj, "would update")
+	return nil
+}
+
+// Patch logs the object.
+func (c DryRunClient) Patch(ctx context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	c.skip(ctx, obj, "would patch")
+	return nil
+}
+
+// Delete logs the object.
+func (c DryRunClient) Delete(ctx context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.skip(ctx, obj, "would delete")
+	return nil
+}
+
+// DeleteAllOf logs the kind of the objects.
+func (c DryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	log.FromContext(ctx).Info(fmt.Sprintf("dry run: would delete all %s", c.kind(obj)))
+	return nil
+}
+
+// Status returns a writer for the status subresource, which skips all writes.
+func (c DryRunClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+// SubResource returns a client for the given subresource, which skips all writes.
+func (c DryRunClient) SubResource(subResource string) client.SubResourceClient {
+	return dryRunSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		client:            c,
+		subResource:       subResource,
+	}
+}
+
+func (c DryRunClient) skip(ctx context.Context, obj client.Object, action string) {
+	key := client.ObjectKeyFromObject(obj).String()
+	msg := fmt.Sprintf("%s %s %s", action, c.kind(obj), strings.TrimPrefix(key, "/"))
+	log.FromContext(ctx).Info("dry run: " + msg)
+	if c.Recorder != nil {
+		c.Recorder.Event(obj, "Normal", dryRunReason, msg)
+	}
+}
+
+func (c DryRunClient) kind(obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return fmt.Sprintf("%T", obj)
+	}
+	return gvk.Kind
+}
+
+type dryRunSubResourceClient struct {
+	client.SubResourceClient
+	client      DryRunClient
+	subResource string
+}
+
+func (c dryRunSubResourceClient) Create(ctx context.Context, obj client.Object, _ client.Object, _ ...client.SubResourceCreateOption) error {
+	c.client.skip(ctx, obj, "would create "+c.subResource+" of")
+	return nil
+}
+
+func (c dryRunSubResourceClient) Update(ctx context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	c.client.skip(ctx, obj, "would update "+c.subResource+" of")
+	return nil
+}
+
+func (c dryRunSubResourceClient) Patch(ctx context.Context, obj client.Object, _ client.Patch, _ ...client.SubResourcePatchOption) error {
+	c.client.skip(ctx, obj, "would patch "+c.subResource+" of")
+	return nil
+}
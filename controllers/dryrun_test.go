@@ -0,0 +1,238 @@
+package controllers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak/keycloaktest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	orgv1 "github.com/appuio/control-api/apis/organization/v1"
+	controlv1 "github.com/appuio/control-api/apis/v1"
+	. "github.com/vshn/appuio-keycloak-adapter/controllers"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_DryRun_OrganizationController_Reconcile(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would create or update Keycloak group /foo with 2 members").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", gomock.Any()).
+		AnyTimes()
+
+	_, err := (&OrganizationReconciler{
+		Client:   DryRunClient{Client: c, Recorder: erMock},
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: DryRunKeycloakClient{KeycloakClient: keyMock, Recorder: erMock},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
+	assert.Empty(t, newOrg.Finalizers, "finalizer not persisted")
+}
+
+func Test_DryRun_OrganizationController_Reconcile_SyncRequested(t *testing.T) {
+	ctx := context.Background()
+
+	org := *fooOrg
+	org.Annotations = map[string]string{
+		"keycloak-adapter.vshn.net/sync-requested": "",
+	}
+
+	c, keyMock, erMock := prepareTest(t, &org, fooMemb)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would trigger the organization import").
+		Times(2)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", gomock.Any()).
+		AnyTimes()
+
+	var triggered []string
+	r := &OrganizationReconciler{
+		Client:   DryRunClient{Client: c, Recorder: erMock},
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: DryRunKeycloakClient{KeycloakClient: keyMock, Recorder: erMock},
+		SyncTrigger: triggerFunc(func(name string) error {
+			triggered = append(triggered, name)
+			return nil
+		}),
+		DryRun: true,
+	}
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(ctx, ctrl.Request{
+			NamespacedName: types.NamespacedName{
+				Name: "foo",
+			},
+		})
+		require.NoError(t, err)
+	}
+	assert.Empty(t, triggered, "import not triggered on every reconcile")
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
+	assert.Contains(t, newOrg.Annotations, "keycloak-adapter.vshn.net/sync-requested", "annotation not removed")
+}
+
+func Test_DryRun_Sync(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
+	barOrg := idp.NewGroup("Bar Inc.", "bar").WithMemberNames("bar")
+	keyMock.EXPECT().
+		ListGroups(gomock.Any()).
+		Return([]idp.Group{barOrg}, nil).
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would create Organization bar").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would create User bar").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would update OrganizationMembers bar/members").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", gomock.Any()).
+		AnyTimes()
+
+	err := (&PeriodicSyncer{
+		Client:   DryRunClient{Client: c, Recorder: erMock},
+		Recorder: erMock,
+		Keycloak: DryRunKeycloakClient{KeycloakClient: keyMock, Recorder: erMock},
+		DryRun:   true,
+	}).Sync(ctx)
+	require.NoError(t, err)
+
+	err = c.Get(ctx, types.NamespacedName{Name: "bar"}, &orgv1.Organization{})
+	assert.True(t, apierrors.IsNotFound(err), "organization not created")
+	err = c.Get(ctx, types.NamespacedName{Name: "bar"}, &controlv1.User{})
+	assert.True(t, apierrors.IsNotFound(err), "user not created")
+}
+
+func Test_DryRun_UserController_Delete(t *testing.T) {
+	ctx := context.Background()
+
+	user := &controlv1.User{}
+	user.Name = "alice"
+	user.Finalizers = []string{"keycloak-adapter.vshn.net/finalizer"}
+	c, keyMock, erMock := prepareTest(t, user)
+	require.NoError(t, c.Delete(ctx, user))
+
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would delete Keycloak user alice").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would update User alice").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", gomock.Any(), gomock.Any()).
+		AnyTimes()
+
+	_, err := (&UserReconciler{
+		Client:         DryRunClient{Client: c, Recorder: erMock},
+		Scheme:         &runtime.Scheme{},
+		Recorder:       erMock,
+		Keycloak:       DryRunKeycloakClient{KeycloakClient: keyMock, Recorder: erMock},
+		DeletionPolicy: UserDeletionPolicyDelete,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "alice",
+		},
+	})
+	require.NoError(t, err)
+
+	newUser := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "alice"}, &newUser))
+	assert.NotEmpty(t, newUser.Finalizers, "finalizer not removed")
+}
+
+func Test_DryRun_OrganizationController_Reconcile_Plan(t *testing.T) {
+	ctx := context.Background()
+
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("bar")})
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("baz")})
+	srv.AddGroup("Foo", "foo")
+	srv.AddMember("baz", "foo")
+
+	c, _, erMock := prepareTest(t, fooOrg, fooMemb)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would update Keycloak group /foo: change display name from \"Foo\", add bar, remove baz").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", gomock.Any()).
+		AnyTimes()
+
+	_, err := (&OrganizationReconciler{
+		Client:   DryRunClient{Client: c, Recorder: erMock},
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: DryRunKeycloakClient{KeycloakClient: srv.NewClient("admin", "secret"), Recorder: erMock},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz"}, srv.Members("foo"), "members unchanged")
+	foo, _ := srv.Group("foo")
+	assert.Equal(t, []string{"Foo"}, (*foo.Attributes)["displayName"], "display name unchanged")
+}
+
+func Test_DryRun_OrganizationController_Reconcile_PlanMultiRealm(t *testing.T) {
+	ctx := context.Background()
+
+	srv := keycloaktest.NewServer("appuio", "26.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("bar")})
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("baz")})
+	srv.AddOrganization("Foo", "foo")
+	srv.SetOrganizationAttribute("foo", "managedBy", "appuio-keycloak-adapter")
+	srv.AddOrganizationMember("baz", "foo")
+
+	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", "would update Keycloak group /foo: change display name from \"Foo\", add bar, remove baz").
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Normal", "DryRun", gomock.Any()).
+		AnyTimes()
+
+	kc := MultiRealmKeycloakClient{Targets: []RealmTarget{
+		{Name: "primary", Client: keycloak.OrganizationsClient{Client: srv.NewClient("admin", "secret")}},
+		{Name: "secondary", Client: keyMock},
+	}}
+	_, err := (&OrganizationReconciler{
+		Client:   DryRunClient{Client: c, Recorder: erMock},
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: DryRunKeycloakClient{KeycloakClient: kc, Recorder: erMock},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz"}, srv.OrganizationMembers("foo"), "members unchanged")
+}
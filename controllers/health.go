@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
+
+// ConnectivityCheck is a readiness check verifying that the identity provider is reachable and accepts the credentials of the adapter.
+// A successful ping is trusted for MaxAge, so not every probe reaches the identity provider.
+type ConnectivityCheck struct {
+	Pinger idp.Pinger
+	// MaxAge is how long a successful ping is trusted before pinging again.
+	MaxAge time.Duration
+	// Timeout is the timeout of a single ping.
+	// Only limited by the probe request if 0.
+	Timeout time.Duration
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+}
+
+// Check implements healthz.Checker.
+func (c *ConnectivityCheck) Check(req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.lastSuccess.IsZero() && time.Since(c.lastSuccess) < c.MaxAge {
+		return nil
+	}
+
+	ctx := req.Context()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	if err := c.Pinger.Ping(ctx); err != nil {
+		if c.lastSuccess.IsZero() {
+			return fmt.Errorf("identity provider not reachable: %w", err)
+		}
+		return fmt.Errorf("identity provider not reachable since %s: %w", c.lastSuccess.Format(time.RFC3339), err)
+	}
+	c.lastSuccess = time.Now()
+	return nil
+}
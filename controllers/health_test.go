@@ -0,0 +1,51 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/controllers"
+)
+
+// pingFunc is an idp.Pinger calling the function.
+type pingFunc func(ctx context.Context) error
+
+func (f pingFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+func Test_ConnectivityCheck(t *testing.T) {
+	var pings int
+	var pingErr error
+	check := &ConnectivityCheck{
+		MaxAge:  50 * time.Millisecond,
+		Timeout: time.Second,
+		Pinger: pingFunc(func(ctx context.Context) error {
+			pings++
+			_, ok := ctx.Deadline()
+			assert.True(t, ok, "pings have a timeout")
+			return pingErr
+		}),
+	}
+	req := httptest.NewRequest("GET", "/readyz", nil)
+
+	pingErr = errors.New("connection refused")
+	require.Error(t, check.Check(req))
+	assert.Equal(t, 1, pings)
+
+	pingErr = nil
+	require.NoError(t, check.Check(req))
+	require.NoError(t, check.Check(req))
+	assert.Equal(t, 2, pings, "successful pings are cached")
+
+	time.Sleep(60 * time.Millisecond)
+	pingErr = errors.New("unauthorized")
+	assert.ErrorContains(t, check.Check(req), "unauthorized")
+	assert.Equal(t, 3, pings, "stale pings are repeated")
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "appuio_keycloak_adapter"
+
+// Reasons of sync failures.
+const (
+	syncFailureListGroups        = "list_groups"
+	syncFailureListOrganizations = "list_organizations"
+	syncFailureImportGroup       = "import_group"
+	syncFailureListUsers         = "list_users"
+	syncFailureCreateUser        = "create_user"
+)
+
+var (
+	syncDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sync",
+		Name:      "duration_seconds",
+		Help:      "Duration of the last sync of Keycloak groups.",
+	})
+	syncLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sync",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last sync of Keycloak groups without errors.",
+	})
+	syncGroups = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sync",
+		Name:      "groups",
+		Help:      "Number of Keycloak groups seen during the last sync.",
+	})
+	syncCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sync",
+		Name:      "created_total",
+		Help:      "Total number of objects created by the sync of Keycloak groups by kind.",
+	}, []string{"kind"})
+	syncFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "sync",
+		Name:      "failures_total",
+		Help:      "Total number of failures during the sync of Keycloak groups by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(syncDuration, syncLastSuccess, syncGroups, syncCreatedTotal, syncFailuresTotal)
+}
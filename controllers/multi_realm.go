@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+// RealmTarget is a Keycloak realm organizations, teams, and users are synced to.
+type RealmTarget struct {
+	// Name identifies the target in events and logs.
+	Name   string
+	Client KeycloakClient
+}
+
+// MultiRealmKeycloakClient fans out all changes to several Keycloak realms.
+// The first target is the primary realm: reads are served by the primary realm only, and the results of changes are the results of the primary realm.
+//
+// Failures are returned as RealmErrors, one per failed target.
+// Keycloak group IDs are only passed to the primary realm, as they are not shared between realms and only the IDs of the primary realm are stored.
+// Groups in secondary realms are always looked up by their path.
+type MultiRealmKeycloakClient struct {
+	Targets []RealmTarget
+}
+
+// RealmError is the error of a single target of a MultiRealmKeycloakClient.
+type RealmError struct {
+	Realm string
+	Err   error
+}
+
+func (err RealmError) Error() string {
+	if err.Realm == "" {
+		return err.Err.Error()
+	}
+	return fmt.Sprintf("realm %s: %s", err.Realm, err.Err)
+}
+
+func (err RealmError) Unwrap() error {
+	return err.Err
+}
+
+// RealmErrors are the errors of the failed targets of a MultiRealmKeycloakClient.
+type RealmErrors []RealmError
+
+func (errs RealmErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (errs RealmErrors) Unwrap() []error {
+	res := make([]error, len(errs))
+	for i := range errs {
+		res[i] = errs[i]
+	}
+	return res
+}
+
+// forEachRealmError calls fn for the error of every failed target if the given error is a RealmErrors, or once with an empty realm for any other non-nil error.
+func forEachRealmError(err error, fn func(realm string, err error)) {
+	var realmErrs RealmErrors
+	if errors.As(err, &realmErrs) {
+		for _, e := range realmErrs {
+			fn(e.Realm, e.Err)
+		}
+	} else if err != nil {
+		fn("", err)
+	}
+}
+
+// inRealm returns a suffix naming the given realm for event messages, or an empty string if the realm is empty.
+func inRealm(realm string) string {
+	if realm == "" {
+		return ""
+	}
+	return " in realm " + realm
+}
+
+// PutGroup puts the group into all realms and returns the group of the primary realm.
+// The ID of the group is dropped for secondary realms, so their groups are looked up by path.
+func (c MultiRealmKeycloakClient) PutGroup(ctx context.Context, group idp.Group) (idp.Group, error) {
+	res := make([]idp.Group, len(c.Targets))
+	err := c.fanOut(ctx, func(ctx context.Context, i int, kc KeycloakClient) error {
+		g := group
+		if i > 0 {
+			g = g.WithID("")
+		}
+		var err error
+		res[i], err = kc.PutGroup(ctx, g)
+		return err
+	})
+	return res[0], err
+}
+
+// PlanGroup plans the changes to the group in the primary realm, see GroupPlanner.
+// It fails with ErrPlanningNotSupported if the client of the primary realm is not a GroupPlanner.
+func (c MultiRealmKeycloakClient) PlanGroup(ctx context.Context, group idp.Group) (keycloak.GroupPlan, error) {
+	planner, ok := c.Targets[0].Client.(GroupPlanner)
+	if !ok {
+		return keycloak.GroupPlan{Group: group}, ErrPlanningNotSupported
+	}
+	return planner.PlanGroup(ctx, group)
+}
+
+// DeleteGroup deletes the group from all realms.
+// The ID of the group is dropped for secondary realms, so their groups are looked up by path.
+func (c MultiRealmKeycloakClient) DeleteGroup(ctx context.Context, group idp.Group) error {
+	return c.fanOut(ctx, func(ctx context.Context, i int, kc KeycloakClient) error {
+		g := group
+		if i > 0 {
+			g = g.WithID("")
+		}
+		return kc.DeleteGroup(ctx, g)
+	})
+}
+
+// ListGroups lists the groups of the primary realm.
+func (c MultiRealmKeycloakClient) ListGroups(ctx context.Context) ([]idp.Group, error) {
+	return c.Targets[0].Client.ListGroups(ctx)
+}
+
+// PutUser updates the user in all realms and returns the user of the primary realm.
+func (c MultiRealmKeycloakClient) PutUser(ctx context.Context, user idp.User) (idp.User, error) {
+	res := make([]idp.User, len(c.Targets))
+	err := c.fanOut(ctx, func(ctx context.Context, i int, kc KeycloakClient) error {
+		var err error
+		res[i], err = kc.PutUser(ctx, user)
+		return err
+	})
+	return res[0], err
+}
+
+// ListUsers lists the users of the primary realm.
+func (c MultiRealmKeycloakClient) ListUsers(ctx context.Context) ([]idp.User, error) {
+	return c.Targets[0].Client.ListUsers(ctx)
+}
+
+// RemoveUserFromGroups removes the user from all groups in all realms.
+func (c MultiRealmKeycloakClient) RemoveUserFromGroups(ctx context.Context, username string) error {
+	return c.fanOut(ctx, func(ctx context.Context, _ int, kc KeycloakClient) error {
+		return kc.RemoveUserFromGroups(ctx, username)
+	})
+}
+
+// DisableUser disables the user in all realms.
+func (c MultiRealmKeycloakClient) DisableUser(ctx context.Context, username string) error {
+	return c.fanOut(ctx, func(ctx context.Context, _ int, kc KeycloakClient) error {
+		return kc.DisableUser(ctx, username)
+	})
+}
+
+// DeleteUser deletes the user from all realms.
+func (c MultiRealmKeycloakClient) DeleteUser(ctx context.Context, username string) error {
+	return c.fanOut(ctx, func(ctx context.Context, _ int, kc KeycloakClient) error {
+		return kc.DeleteUser(ctx, username)
+	})
+}
+
+// fanOut calls fn for all targets in parallel and collects the errors as RealmErrors.
+// The order of the errors follows the order of the targets.
+func (c MultiRealmKeycloakClient) fanOut(ctx context.Context, fn func(ctx context.Context, i int, kc KeycloakClient) error) error {
+	errs := make([]error, len(c.Targets))
+	var wg sync.WaitGroup
+	for i, t := range c.Targets {
+		wg.Add(1)
+		go func(i int, kc KeycloakClient) {
+			defer wg.Done()
+			errs[i] = fn(ctx, i, kc)
+		}(i, t.Client)
+	}
+	wg.Wait()
+
+	var realmErrs RealmErrors
+	for i, err := range errs {
+		if err != nil {
+			realmErrs = append(realmErrs, RealmError{Realm: c.Targets[i].Name, Err: err})
+		}
+	}
+	if len(realmErrs) > 0 {
+		return realmErrs
+	}
+	return nil
+}
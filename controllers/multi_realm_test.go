@@ -0,0 +1,141 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak/keycloaktest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	. "github.com/vshn/appuio-keycloak-adapter/controllers"
+)
+
+func Test_MultiRealm_PutGroup(t *testing.T) {
+	ctx := context.Background()
+
+	primary := keycloaktest.NewServer("primary", "23.0.0")
+	defer primary.Close()
+	secondary := keycloaktest.NewServer("secondary", "22.0.0")
+	defer secondary.Close()
+	for _, srv := range []*keycloaktest.Server{primary, secondary} {
+		srv.AddUser(gocloak.User{Username: gocloak.StringP("bar")})
+	}
+	id := primary.AddGroup("Foo Inc.", "foo")
+
+	kc := MultiRealmKeycloakClient{Targets: []RealmTarget{
+		{Name: "primary", Client: primary.NewClient("admin", "secret")},
+		{Name: "secondary", Client: secondary.NewClient("admin", "secret")},
+	}}
+	g, err := kc.PutGroup(ctx, idp.NewGroup("Foo Inc.", "foo").WithID(id).WithMemberNames("bar"))
+	require.NoError(t, err)
+	assert.Equal(t, id, g.ID(), "returns the group of the primary realm")
+
+	assert.Equal(t, []string{"bar"}, primary.Members("foo"))
+	assert.Equal(t, []string{"bar"}, secondary.Members("foo"))
+
+	require.NoError(t, kc.DeleteGroup(ctx, idp.NewGroup("Foo Inc.", "foo").WithID(id)))
+	assert.Empty(t, primary.GroupPaths())
+	assert.Empty(t, secondary.GroupPaths())
+}
+
+func Test_MultiRealm_ListFromPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	_, primary, _ := prepareTest(t)
+	_, secondary, _ := prepareTest(t)
+	groups := []idp.Group{idp.NewGroup("Foo Inc.", "foo")}
+	primary.EXPECT().
+		ListGroups(gomock.Any()).
+		Return(groups, nil).
+		Times(1)
+
+	kc := MultiRealmKeycloakClient{Targets: []RealmTarget{
+		{Name: "primary", Client: primary},
+		{Name: "secondary", Client: secondary},
+	}}
+	res, err := kc.ListGroups(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, groups, res)
+}
+
+func Test_OrganizationController_Reconcile_MultiRealmFailure(t *testing.T) {
+	ctx := context.Background()
+
+	c, primary, erMock := prepareTest(t, fooOrg, fooMemb)
+	_, secondary, _ := prepareTest(t)
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	primary.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group.WithID("foo-id"), nil).
+		Times(1)
+	secondary.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(idp.Group{}, &idp.Error{Kind: idp.ErrNotFound, Err: errors.New("parent not found")}).
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Warning", "UpdateFailed", "Failed to update Keycloak Group in realm secondary").
+		Times(1)
+
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: MultiRealmKeycloakClient{Targets: []RealmTarget{
+			{Name: "primary", Client: primary},
+			{Name: "secondary", Client: secondary},
+		}},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err, "permanent errors in all failed realms are not requeued")
+}
+
+func Test_OrganizationController_Reconcile_MultiRealmMembershipFailure(t *testing.T) {
+	ctx := context.Background()
+
+	c, primary, erMock := prepareTest(t, fooOrg, fooMemb)
+	_, secondary, _ := prepareTest(t)
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	primary.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(idp.Group{}, errors.New("unavailable")).
+		Times(1)
+	secondary.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group, &idp.MembershipSyncErrors{{Err: errors.New("nope"), Username: "bar3", Event: idp.UserAddError}}).
+		Times(1)
+	erMock.EXPECT().
+		Event(gomock.Any(), "Warning", "UpdateFailed", "Failed to update Keycloak Group in realm primary").
+		Times(1)
+	erMock.EXPECT().
+		Eventf(gomock.Any(), "Warning", string(idp.UserAddError), "Failed to update membership of user %s in realm secondary", "bar3").
+		Times(2)
+
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: MultiRealmKeycloakClient{Targets: []RealmTarget{
+			{Name: "primary", Client: primary},
+			{Name: "secondary", Client: secondary},
+		}},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	var realmErrs RealmErrors
+	require.True(t, errors.As(err, &realmErrs))
+	require.Len(t, realmErrs, 1)
+	assert.Equal(t, "primary", realmErrs[0].Realm)
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	orgv1 "github.com/appuio/control-api/apis/organization/v1"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
+
+// adapterAnnotPrefix is the prefix of the annotations managed by the adapter itself.
+// They are never synced to Keycloak.
+const adapterAnnotPrefix = "keycloak-adapter.vshn.net/"
+
+// OrganizationAttributes configures which fields of an Organization are synced to attributes of its Keycloak group.
+// Fields with an empty attribute name are not synced.
+type OrganizationAttributes struct {
+	// BillingEntityRef is the attribute storing the billing entity reference.
+	BillingEntityRef string
+	// CreationTimestamp is the attribute storing the creation timestamp in RFC 3339 format.
+	// It is not read back on import.
+	CreationTimestamp string
+	// Labels are the keys of the labels stored in attributes of the same name.
+	Labels []string
+	// AnnotationPrefix selects the annotations stored in attributes of the same name.
+	// Group attributes with this prefix are considered to be managed by the adapter.
+	AnnotationPrefix string
+}
+
+// groupAttributes returns the attributes of the Keycloak group of the given organization and the prefixes of the attributes owned by the adapter.
+func (a OrganizationAttributes) groupAttributes(org *orgv1.Organization) (map[string][]string, []string) {
+	attrs := map[string][]string{}
+	var owned []string
+
+	if a.BillingEntityRef != "" {
+		attrs[a.BillingEntityRef] = optionalValue(org.Spec.BillingEntityRef)
+	}
+	if a.CreationTimestamp != "" && !org.CreationTimestamp.IsZero() {
+		attrs[a.CreationTimestamp] = []string{org.CreationTimestamp.UTC().Format(time.RFC3339)}
+	}
+	for _, l := range a.Labels {
+		attrs[l] = optionalValue(org.Labels[l])
+	}
+	if a.AnnotationPrefix != "" {
+		owned = append(owned, a.AnnotationPrefix)
+		for k, v := range org.Annotations {
+			if a.syncsAnnotation(k) {
+				attrs[k] = []string{v}
+			}
+		}
+	}
+
+	if len(attrs) == 0 {
+		return nil, owned
+	}
+	return attrs, owned
+}
+
+// applyToOrganization sets the fields of the organization from the attributes of the given group.
+func (a OrganizationAttributes) applyToOrganization(group idp.Group, org *orgv1.Organization) {
+	attrs := group.Attributes()
+
+	if v := firstValue(attrs, a.BillingEntityRef); a.BillingEntityRef != "" && v != "" {
+		org.Spec.BillingEntityRef = v
+	}
+	for _, l := range a.Labels {
+		if v, ok := attrs[l]; ok && len(v) > 0 {
+			if org.Labels == nil {
+				org.Labels = map[string]string{}
+			}
+			org.Labels[l] = v[0]
+		}
+	}
+	if a.AnnotationPrefix != "" {
+		for k, v := range attrs {
+			if a.syncsAnnotation(k) && len(v) > 0 {
+				if org.Annotations == nil {
+					org.Annotations = map[string]string{}
+				}
+				org.Annotations[k] = v[0]
+			}
+		}
+	}
+}
+
+func (a OrganizationAttributes) syncsAnnotation(key string) bool {
+	return strings.HasPrefix(key, a.AnnotationPrefix) && !strings.HasPrefix(key, adapterAnnotPrefix)
+}
+
+func optionalValue(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+func firstValue(attrs map[string][]string, key string) string {
+	if v := attrs[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -6,7 +6,7 @@ import (
 
 	orgv1 "github.com/appuio/control-api/apis/organization/v1"
 	controlv1 "github.com/appuio/control-api/apis/v1"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -14,6 +14,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // OrganizationReconciler reconciles a Organization object
@@ -23,23 +26,36 @@ type OrganizationReconciler struct {
 	Scheme   *runtime.Scheme
 
 	Keycloak KeycloakClient
+
+	// Attributes configures the fields of the Organization synced to attributes of the Keycloak group.
+	Attributes OrganizationAttributes
+
+	// SyncTrigger runs the organization import when an Organization is annotated with `keycloak-adapter.vshn.net/sync-requested`.
+	// The annotation is ignored if nil.
+	SyncTrigger SyncTrigger
+
+	// DryRun must be set if the client skips all writes, see DryRunClient.
+	// The annotation requesting an import can't be removed in dry-run mode, so the import is not triggered, as it would be triggered again on every reconcile.
+	DryRun bool
 }
 
 //go:generate go run github.com/golang/mock/mockgen -destination=./ZZ_mock_eventrecorder_test.go -package controllers_test k8s.io/client-go/tools/record EventRecorder
 
-//go:generate go run github.com/golang/mock/mockgen -source=$GOFILE -destination=./ZZ_mock_keycloak_test.go -package controllers_test
+//go:generate go run github.com/golang/mock/mockgen -destination=./ZZ_mock_keycloak_test.go -package controllers_test -mock_names Client=MockKeycloakClient github.com/vshn/appuio-keycloak-adapter/idp Client
 
-// KeycloakClient is an abstraction to interact with the Keycloak API
-type KeycloakClient interface {
-	PutGroup(ctx context.Context, group keycloak.Group) (keycloak.Group, error)
-	DeleteGroup(ctx context.Context, path ...string) error
-	ListGroups(ctx context.Context) ([]keycloak.Group, error)
-
-	PutUser(ctx context.Context, user keycloak.User) (keycloak.User, error)
-}
+// KeycloakClient is an abstraction to interact with the identity provider.
+// Despite its name, any idp.Client can be used, such as a Keycloak or a SCIM client.
+type KeycloakClient = idp.Client
 
 var orgFinalizer = "keycloak-adapter.vshn.net/finalizer"
 
+// groupIDAnnot stores the ID of the Keycloak group an Organization or Team is mirrored to.
+const groupIDAnnot = "keycloak-adapter.vshn.net/group-id"
+
+// syncRequestAnnot requests an organization import when set on any Organization.
+// It is removed once the import is triggered.
+const syncRequestAnnot = "keycloak-adapter.vshn.net/sync-requested"
+
 //+kubebuilder:rbac:groups=organization.appuio.io;rbac.appuio.io,resources=organizations,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=organization.appuio.io;rbac.appuio.io,resources=organizations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=organization.appuio.io;rbac.appuio.io,resources=organizations/finalizers,verbs=update
@@ -50,7 +66,10 @@ var orgFinalizer = "keycloak-adapter.vshn.net/finalizer"
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile reacts on changes of Organizations and OrganizationMembers and mirrors these changes to groups in Keycloak
-func (r *OrganizationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *OrganizationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracer.Start(ctx, "OrganizationReconciler.Reconcile", trace.WithAttributes(attribute.String("organization", req.Name)))
+	defer func() { endSpan(span, err) }()
+
 	log := log.FromContext(ctx)
 	log.V(4).WithValues("request", req).Info("Reconciling")
 
@@ -59,6 +78,11 @@ func (r *OrganizationReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	if err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = withInvolvedObject(ctx, org)
+
+	if err := r.triggerRequestedSync(ctx, org); err != nil {
+		return ctrl.Result{}, err
+	}
 
 	if org.Annotations[orgImportAnnot] == "true" {
 		// This organization is being imported.
@@ -68,10 +92,12 @@ func (r *OrganizationReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 	if !org.ObjectMeta.DeletionTimestamp.IsZero() {
 		log.V(4).Info("Deleting Keycloak group..")
-		err = r.Keycloak.DeleteGroup(ctx, org.Name)
+		err = r.Keycloak.DeleteGroup(ctx, idp.NewGroup(org.Spec.DisplayName, org.Name).WithID(org.Annotations[groupIDAnnot]))
 		if err != nil {
-			r.Recorder.Event(org, "Warning", "DeletionFailed", "Failed to delete Keycloak Group")
-			return ctrl.Result{}, err
+			forEachRealmError(err, func(realm string, _ error) {
+				r.Recorder.Event(org, "Warning", "DeletionFailed", "Failed to delete Keycloak Group"+inRealm(realm))
+			})
+			return requeueOnKeycloakError(ctx, err)
 		}
 
 		err = r.removeFinalizer(ctx, org, orgMemb)
@@ -82,23 +108,33 @@ func (r *OrganizationReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	group := buildKeycloakGroup(org, orgMemb)
+	group := buildKeycloakGroup(org, orgMemb, r.Attributes)
 
 	log.V(4).Info("Reconciling Keycloak group..")
 	group, err = r.Keycloak.PutGroup(ctx, group)
-	var membErrs *keycloak.MembershipSyncErrors
-	if errors.As(err, &membErrs) {
+	var failed RealmErrors
+	forEachRealmError(err, func(realm string, err error) {
+		var membErrs *idp.MembershipSyncErrors
+		if !errors.As(err, &membErrs) {
+			r.Recorder.Event(org, "Warning", "UpdateFailed", "Failed to update Keycloak Group"+inRealm(realm))
+			failed = append(failed, RealmError{Realm: realm, Err: err})
+			return
+		}
 		for _, membErr := range *membErrs {
-			r.Recorder.Eventf(org, "Warning", string(membErr.Event), "Failed to update membership of user %s", membErr.Username)
-			r.Recorder.Eventf(orgMemb, "Warning", string(membErr.Event), "Failed to update membership of user %s", membErr.Username)
-			log.Error(membErr, "Failed to update membership", "user", membErr.Username)
+			r.Recorder.Eventf(org, "Warning", string(membErr.Event), "Failed to update membership of user %s"+inRealm(realm), membErr.Username)
+			r.Recorder.Eventf(orgMemb, "Warning", string(membErr.Event), "Failed to update membership of user %s"+inRealm(realm), membErr.Username)
+			log.Error(membErr, "Failed to update membership", "user", membErr.Username, "realm", realm)
 		}
-	} else if err != nil {
-		r.Recorder.Event(org, "Warning", "UpdateFailed", "Failed to update Keycloak Group")
-		return ctrl.Result{}, err
+	})
+	if len(failed) > 0 {
+		return requeueOnKeycloakError(ctx, failed)
 	}
 
 	log.V(4).Info("Updating status..")
+	err = setGroupIDAnnotation(ctx, r.Client, org, group.ID())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 	err = r.updateOrganizationStatus(ctx, org, orgMemb, group)
 	return ctrl.Result{}, err
 }
@@ -149,7 +185,7 @@ func (r *OrganizationReconciler) removeFinalizer(ctx context.Context, org *orgv1
 	return nil
 }
 
-func (r *OrganizationReconciler) updateOrganizationStatus(ctx context.Context, org *orgv1.Organization, memb *controlv1.OrganizationMembers, group keycloak.Group) error {
+func (r *OrganizationReconciler) updateOrganizationStatus(ctx context.Context, org *orgv1.Organization, memb *controlv1.OrganizationMembers, group idp.Group) error {
 	userRefs := make([]controlv1.UserRef, 0, len(group.Members))
 	for _, u := range group.Members {
 		userRefs = append(userRefs, controlv1.UserRef{
@@ -160,14 +196,67 @@ func (r *OrganizationReconciler) updateOrganizationStatus(ctx context.Context, o
 	return r.Status().Update(ctx, memb)
 }
 
-func buildKeycloakGroup(org *orgv1.Organization, memb *controlv1.OrganizationMembers) keycloak.Group {
+func buildKeycloakGroup(org *orgv1.Organization, memb *controlv1.OrganizationMembers, attrs OrganizationAttributes) idp.Group {
 	groupMem := make([]string, 0, len(memb.Spec.UserRefs))
 
 	for _, u := range memb.Spec.UserRefs {
 		groupMem = append(groupMem, u.Name)
 	}
 
-	return keycloak.NewGroup(org.Spec.DisplayName, org.Name).WithMemberNames(groupMem...)
+	groupAttrs, ownedPrefixes := attrs.groupAttributes(org)
+	return idp.NewGroup(org.Spec.DisplayName, org.Name).
+		WithID(org.Annotations[groupIDAnnot]).
+		WithAttributes(groupAttrs, ownedPrefixes...).
+		WithMemberNames(groupMem...)
+}
+
+// triggerRequestedSync triggers the organization import if requested by the annotation of the Organization, and removes the annotation.
+func (r *OrganizationReconciler) triggerRequestedSync(ctx context.Context, org *orgv1.Organization) error {
+	if _, ok := org.Annotations[syncRequestAnnot]; !ok || r.SyncTrigger == nil {
+		return nil
+	}
+	if r.DryRun {
+		log.FromContext(ctx).Info("Not triggering requested organization import in dry-run mode")
+		r.Recorder.Event(org, "Normal", dryRunReason, "would trigger the organization import")
+		return nil
+	}
+	log.FromContext(ctx).Info("Triggering organization import on request")
+	if err := r.SyncTrigger.Trigger(ImportSyncJob); err != nil {
+		return err
+	}
+	r.Recorder.Event(org, "Normal", "SyncTriggered", "Triggered the organization import")
+
+	delete(org.Annotations, syncRequestAnnot)
+	return r.Update(ctx, org)
+}
+
+// setGroupIDAnnotation stores the ID of the Keycloak group on the given object, if it changed.
+func setGroupIDAnnotation(ctx context.Context, c client.Client, obj client.Object, id string) error {
+	annotations := obj.GetAnnotations()
+	if id == "" || annotations[groupIDAnnot] == id {
+		return nil
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[groupIDAnnot] = id
+	obj.SetAnnotations(annotations)
+	return c.Update(ctx, obj)
+}
+
+// requeueOnKeycloakError returns the given error from a Keycloak call to controller-runtime, unless it is permanent, see idp.IsPermanent.
+// Retrying a permanent error would not change the outcome, so it is only logged, and the object is reconciled again on its next change or resync.
+// Errors of several realms are only considered permanent if the errors of all realms are permanent.
+func requeueOnKeycloakError(ctx context.Context, err error) (ctrl.Result, error) {
+	permanent := err != nil
+	forEachRealmError(err, func(_ string, err error) {
+		permanent = permanent && idp.IsPermanent(err)
+	})
+	if permanent {
+		log.FromContext(ctx).Error(err, "Permanent Keycloak error, not requeueing", "kind", idp.KindOf(err))
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	"github.com/vshn/appuio-keycloak-adapter/scim"
+	"github.com/vshn/appuio-keycloak-adapter/scim/scimtest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -28,7 +31,7 @@ func Test_OrganizationController_Reconcile_Success(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb)
-	group := keycloak.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
 		Return(group, nil).
@@ -55,14 +58,41 @@ func Test_OrganizationController_Reconcile_Success(t *testing.T) {
 	assert.Equal(t, "keycloak-adapter.vshn.net/finalizer", newMemb.Finalizers[0], "expected finalizer")
 }
 
+func Test_OrganizationController_Reconcile_SCIM(t *testing.T) {
+	ctx := context.Background()
+
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+	srv.AddUser("bar")
+	srv.AddUser("bar3")
+
+	c, _, _ := prepareTest(t, fooOrg, fooMemb)
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: scim.NewClient(srv.URL, "token"),
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "bar3"}, srv.Members("/foo"))
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
+	group, _ := srv.Group("/foo")
+	assert.Equal(t, group["id"], newOrg.Annotations["keycloak-adapter.vshn.net/group-id"])
+}
+
 func Test_OrganizationController_Reconcile_Failure(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
-	group := keycloak.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
-		Return(keycloak.Group{}, errors.New("create failed")).
+		Return(idp.Group{}, errors.New("create failed")).
 		Times(1)
 
 	erMock.EXPECT().
@@ -91,32 +121,92 @@ func Test_OrganizationController_Reconcile_Failure(t *testing.T) {
 	assert.Equal(t, "keycloak-adapter.vshn.net/finalizer", newMemb.Finalizers[0], "expected finalizer")
 }
 
+func Test_OrganizationController_Reconcile_PermanentFailure(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(idp.Group{}, &idp.Error{Kind: idp.ErrNotFound, Err: errors.New("404 Not Found")}).
+		Times(1)
+
+	erMock.EXPECT().
+		Event(gomock.Any(), "Warning", "UpdateFailed", gomock.Any()).
+		Times(1)
+
+	res, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Recorder: erMock,
+		Keycloak: keyMock,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err, "permanent errors are not requeued")
+	assert.False(t, res.Requeue)
+	assert.Zero(t, res.RequeueAfter)
+}
+
+func Test_OrganizationController_Reconcile_AuthorizationFailure(t *testing.T) {
+	for name, kind := range map[string]idp.ErrorKind{
+		"unauthorized": idp.ErrUnauthorized,
+		"forbidden":    idp.ErrForbidden,
+		"conflict":     idp.ErrConflict,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
+			keyMock.EXPECT().
+				PutGroup(gomock.Any(), gomock.Any()).
+				Return(idp.Group{}, &idp.Error{Kind: kind, Err: errors.New(string(kind))}).
+				Times(1)
+			erMock.EXPECT().
+				Event(gomock.Any(), "Warning", "UpdateFailed", gomock.Any()).
+				Times(1)
+
+			_, err := (&OrganizationReconciler{
+				Client:   c,
+				Scheme:   &runtime.Scheme{},
+				Recorder: erMock,
+				Keycloak: keyMock,
+			}).Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: types.NamespacedName{
+					Name: "foo",
+				},
+			})
+			require.ErrorIs(t, err, kind, "credentials, roles, and conflicting changes might be fixed, so the reconcile is requeued")
+		})
+	}
+}
+
 func Test_OrganizationController_Reconcile_Member_Failure(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, erMock := prepareTest(t, fooOrg, fooMemb)
-	group := keycloak.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
-		Return(keycloak.Group{}, &keycloak.MembershipSyncErrors{
+		Return(idp.Group{}, &idp.MembershipSyncErrors{
 			{
 				Err:      errors.New("no user 'bar' found"),
 				Username: "bar",
-				Event:    keycloak.UserAddError,
+				Event:    idp.UserAddError,
 			},
 			{
 				Err:      errors.New("permission denied"),
 				Username: "foo",
-				Event:    keycloak.UserRemoveError,
+				Event:    idp.UserRemoveError,
 			},
 		}).
 		Times(1)
 
 	erMock.EXPECT().
-		Eventf(gomock.Any(), "Warning", string(keycloak.UserRemoveError), gomock.Any(), "foo").
+		Eventf(gomock.Any(), "Warning", string(idp.UserRemoveError), gomock.Any(), "foo").
 		Times(2)
 	erMock.EXPECT().
-		Eventf(gomock.Any(), "Warning", string(keycloak.UserAddError), gomock.Any(), "bar").
+		Eventf(gomock.Any(), "Warning", string(idp.UserAddError), gomock.Any(), "bar").
 		Times(2)
 
 	_, err := (&OrganizationReconciler{
@@ -151,7 +241,7 @@ func Test_OrganizationController_Reconcile_Delete(t *testing.T) {
 
 	c, keyMock, _ := prepareTest(t, &org, fooMemb)
 	keyMock.EXPECT().
-		DeleteGroup(gomock.Any(), "foo").
+		DeleteGroup(gomock.Any(), idp.NewGroup("Foo Inc.", "foo")).
 		Return(nil).
 		Times(1)
 
@@ -180,7 +270,7 @@ func Test_OrganizationController_Reconcile_Delete_Failure(t *testing.T) {
 
 	c, keyMock, erMock := prepareTest(t, &org, fooMemb)
 	keyMock.EXPECT().
-		DeleteGroup(gomock.Any(), "foo").
+		DeleteGroup(gomock.Any(), idp.NewGroup("Foo Inc.", "foo")).
 		Return(errors.New("Failed to delete")).
 		Times(1)
 
@@ -204,7 +294,172 @@ func Test_OrganizationController_Reconcile_Delete_Failure(t *testing.T) {
 	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
 }
 
+func Test_OrganizationController_Reconcile_StoreGroupID(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb)
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group.WithID("foo-id"), nil).
+		Times(1)
+
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: keyMock,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
+	assert.Equal(t, "foo-id", newOrg.Annotations["keycloak-adapter.vshn.net/group-id"])
+
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group.WithID("foo-id")).
+		Return(group.WithID("foo-id"), nil).
+		Times(1)
+
+	_, err = (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: keyMock,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err, "reuses stored group ID")
+}
+
+func Test_OrganizationController_Reconcile_Attributes(t *testing.T) {
+	ctx := context.Background()
+
+	org := fooOrg.DeepCopy()
+	org.CreationTimestamp = metav1.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	org.Spec.BillingEntityRef = "be-1234"
+	org.Labels = map[string]string{"example.com/tier": "gold", "unrelated": "x"}
+	org.Annotations = map[string]string{
+		"example.com/contact":                "ops@example.com",
+		"keycloak-adapter.vshn.net/group-id": "foo-id",
+		"other.com/ignored":                  "x",
+	}
+	c, keyMock, _ := prepareTest(t, org, fooMemb)
+	group := idp.NewGroup("Foo Inc.", "foo").
+		WithID("foo-id").
+		WithAttributes(map[string][]string{
+			"appuio.io/billing-entity": {"be-1234"},
+			"appuio.io/created":        {"2023-04-05T06:07:08Z"},
+			"example.com/tier":         {"gold"},
+			"example.com/missing":      nil,
+			"example.com/contact":      {"ops@example.com"},
+		}, "example.com/").
+		WithMemberNames("bar", "bar3")
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group, nil).
+		Times(1)
+
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: keyMock,
+		Attributes: OrganizationAttributes{
+			BillingEntityRef:  "appuio.io/billing-entity",
+			CreationTimestamp: "appuio.io/created",
+			Labels:            []string{"example.com/tier", "example.com/missing"},
+			AnnotationPrefix:  "example.com/",
+		},
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+}
+
+func Test_OrganizationController_Reconcile_Delete_WithGroupID(t *testing.T) {
+	ctx := context.Background()
+
+	org := *fooOrg
+	now := metav1.Now()
+	org.DeletionTimestamp = &now
+	org.Finalizers = []string{"keycloak-adapter.vshn.net/finalizer"}
+	org.Annotations = map[string]string{
+		"keycloak-adapter.vshn.net/group-id": "foo-id",
+	}
+
+	c, keyMock, _ := prepareTest(t, &org, fooMemb)
+	keyMock.EXPECT().
+		DeleteGroup(gomock.Any(), idp.NewGroup("Foo Inc.", "foo").WithID("foo-id")).
+		Return(nil).
+		Times(1)
+
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: keyMock,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+}
+
 // Reconcile should ignore organizations that are being imported
+func Test_OrganizationController_Reconcile_SyncRequested(t *testing.T) {
+	ctx := context.Background()
+
+	org := *fooOrg
+	org.Annotations = map[string]string{
+		"keycloak-adapter.vshn.net/sync-requested": "",
+	}
+
+	c, keyMock, eventMock := prepareTest(t, &org, fooMemb)
+	group := idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3")
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group, nil).
+		Times(1)
+	eventMock.EXPECT().
+		Event(gomock.Any(), "Normal", "SyncTriggered", gomock.Any()).
+		Times(1)
+
+	var triggered []string
+	_, err := (&OrganizationReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Recorder: eventMock,
+		Keycloak: keyMock,
+		SyncTrigger: triggerFunc(func(name string) error {
+			triggered = append(triggered, name)
+			return nil
+		}),
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: "foo",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ImportSyncJob}, triggered)
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "foo"}, &newOrg))
+	assert.NotContains(t, newOrg.Annotations, "keycloak-adapter.vshn.net/sync-requested", "annotation is removed")
+}
+
+// triggerFunc is a SyncTrigger calling the function.
+type triggerFunc func(name string) error
+
+func (f triggerFunc) Trigger(name string) error {
+	return f(name)
+}
+
 func Test_OrganizationController_Reconcile_Ignore(t *testing.T) {
 	ctx := context.Background()
 
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	orgv1 "github.com/appuio/control-api/apis/organization/v1"
 	controlv1 "github.com/appuio/control-api/apis/v1"
@@ -16,7 +17,10 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 )
 
 const orgImportAnnot = "keycloak-adapter.vshn.net/importing"
@@ -31,6 +35,13 @@ type PeriodicSyncer struct {
 	// SyncClusterRoles to give to group members when importing
 	SyncClusterRoles           []string
 	SyncClusterRolesUserPrefix string
+
+	// Attributes configures the fields of the Organization read from attributes of the Keycloak group when importing
+	Attributes OrganizationAttributes
+
+	// DryRun must be set if the client skips all writes, see DryRunClient.
+	// Objects "created" in dry-run mode can't be read back, so the members of imported organizations are assumed to be empty.
+	DryRun bool
 }
 
 //+kubebuilder:rbac:groups=appuio.io,resources=organizationmembers,verbs=create
@@ -44,15 +55,30 @@ type PeriodicSyncer struct {
 
 // Sync lists all Keycloak groups in the realm and creates corresponding Organizations if they do not exist
 func (r *PeriodicSyncer) Sync(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "PeriodicSyncer.Sync")
+	start := time.Now()
+	err := r.sync(ctx)
+	syncDuration.Set(time.Since(start).Seconds())
+	if err == nil {
+		syncLastSuccess.SetToCurrentTime()
+	}
+	endSpan(span, err)
+	return err
+}
+
+func (r *PeriodicSyncer) sync(ctx context.Context) error {
 	logger := log.FromContext(ctx)
 
 	gs, err := r.Keycloak.ListGroups(ctx)
 	if err != nil {
+		syncFailuresTotal.WithLabelValues(syncFailureListGroups).Inc()
 		return fmt.Errorf("cannot list Keycloak groups: %w", err)
 	}
+	syncGroups.Set(float64(len(gs)))
 
 	orgMap, err := r.fetchOrganizationMap(ctx)
 	if err != nil {
+		syncFailuresTotal.WithLabelValues(syncFailureListOrganizations).Inc()
 		return fmt.Errorf("cannot list Organizations: %w", err)
 	}
 
@@ -61,6 +87,7 @@ func (r *PeriodicSyncer) Sync(ctx context.Context) error {
 		org, err := r.syncGroup(ctx, g, orgMap)
 		if err != nil {
 			logger.WithValues("group", g).Error(err, "import of group failed")
+			syncFailuresTotal.WithLabelValues(syncFailureImportGroup).Inc()
 			if org != nil {
 				r.Recorder.Event(org, "Warning", "ImportFailed", err.Error())
 			}
@@ -76,9 +103,52 @@ func (r *PeriodicSyncer) Sync(ctx context.Context) error {
 	return nil
 }
 
-func (r *PeriodicSyncer) createMissingUsers(ctx context.Context, groups []keycloak.Group) error {
+// SyncUserStatus lists all Keycloak users in the realm and refreshes the status of the corresponding Users.
+// Only Users whose status changed are written.
+func (r *PeriodicSyncer) SyncUserStatus(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "PeriodicSyncer.SyncUserStatus")
+	defer func() { endSpan(span, err) }()
+
+	logger := log.FromContext(ctx)
+
+	kcUsers, err := r.Keycloak.ListUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list Keycloak users: %w", err)
+	}
+
+	users := controlv1.UserList{}
+	if err := r.List(ctx, &users); err != nil {
+		return fmt.Errorf("cannot list Users: %w", err)
+	}
+	userMap := make(map[string]*controlv1.User, len(users.Items))
+	for i, u := range users.Items {
+		userMap[u.Name] = &users.Items[i]
+	}
+
+	var updateErr error
+	for _, kcUser := range kcUsers {
+		user, ok := userMap[kcUser.Username]
+		if !ok {
+			continue
+		}
+		status := userStatusFromKeycloakUser(kcUser)
+		if user.Status == status {
+			continue
+		}
+		logger.V(1).WithValues("user", user.Name).Info("updating user status")
+		patch := client.MergeFrom(user.DeepCopy())
+		user.Status = status
+		if err := r.Status().Patch(ctx, user, patch); err != nil {
+			updateErr = multierr.Append(updateErr, fmt.Errorf("cannot update status of User %q: %w", user.Name, err))
+		}
+	}
+	return updateErr
+}
+
+func (r *PeriodicSyncer) createMissingUsers(ctx context.Context, groups []idp.Group) error {
 	existing, err := r.fetchAPIUsers(ctx)
 	if err != nil {
+		syncFailuresTotal.WithLabelValues(syncFailureListUsers).Inc()
 		return fmt.Errorf("cannot list Users: %w", err)
 	}
 
@@ -90,9 +160,11 @@ func (r *PeriodicSyncer) createMissingUsers(ctx context.Context, groups []keyclo
 			}
 			err := r.createUser(ctx, m)
 			if err != nil {
+				syncFailuresTotal.WithLabelValues(syncFailureCreateUser).Inc()
 				createErr = multierr.Append(createErr, err)
 				continue
 			}
+			syncCreatedTotal.WithLabelValues("user").Inc()
 			existing[m.Username] = struct{}{}
 		}
 	}
@@ -100,7 +172,7 @@ func (r *PeriodicSyncer) createMissingUsers(ctx context.Context, groups []keyclo
 	return createErr
 }
 
-func (r *PeriodicSyncer) createUser(ctx context.Context, m keycloak.User) error {
+func (r *PeriodicSyncer) createUser(ctx context.Context, m idp.User) error {
 	return r.Create(ctx, &controlv1.User{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: m.Username,
@@ -113,22 +185,26 @@ func (r *PeriodicSyncer) createUser(ctx context.Context, m keycloak.User) error
 	})
 }
 
-func (r *PeriodicSyncer) syncGroup(ctx context.Context, g keycloak.Group, orgMap map[string]*orgv1.Organization) (runtime.Object, error) {
+func (r *PeriodicSyncer) syncGroup(ctx context.Context, g idp.Group, orgMap map[string]*orgv1.Organization) (_ runtime.Object, err error) {
+	ctx, span := tracer.Start(ctx, "PeriodicSyncer.syncGroup", trace.WithAttributes(attribute.String("group", g.Path())))
+	defer func() { endSpan(span, err) }()
+
 	logger := log.FromContext(ctx)
 
-	const depth = 0
-	switch len(g.PathMembers()) - depth {
-	case 1:
+	parent, hasParent := g.Parent()
+	if !hasParent {
 		return r.syncOrganization(ctx, g, orgMap[g.BaseName()])
-	case 2:
+	}
+	if _, hasGrandparent := parent.Parent(); !hasGrandparent {
 		return r.syncTeam(ctx, g)
 	}
 
-	logger.Info("skipped syncing group. invalid hierarchy", "group", g)
+	// Groups nested below teams are managed in Keycloak only
+	logger.V(1).Info("skipped syncing group nested below team", "group", g, "parent", parent)
 	return nil, nil
 }
 
-func (r *PeriodicSyncer) syncTeam(ctx context.Context, g keycloak.Group) (*controlv1.Team, error) {
+func (r *PeriodicSyncer) syncTeam(ctx context.Context, g idp.Group) (*controlv1.Team, error) {
 	logger := log.FromContext(ctx)
 	var err error
 
@@ -139,10 +215,11 @@ func (r *PeriodicSyncer) syncTeam(ctx context.Context, g keycloak.Group) (*contr
 	err = r.Client.Get(ctx, teamKey, team)
 	if err != nil && apierrors.IsNotFound(err) {
 		logger.V(1).WithValues("group", g).Info("creating team")
-		t, err := r.createTeam(ctx, teamKey.Namespace, teamKey.Name, g.Members)
+		t, err := r.createTeam(ctx, teamKey.Namespace, teamKey.Name, g)
 		if err != nil {
 			return nil, fmt.Errorf("error creating team %+v: %w", teamKey, err)
 		}
+		syncCreatedTotal.WithLabelValues("team").Inc()
 		team = t
 	} else if err != nil {
 		return nil, fmt.Errorf("error getting team %+v: %w", teamKey, err)
@@ -151,7 +228,7 @@ func (r *PeriodicSyncer) syncTeam(ctx context.Context, g keycloak.Group) (*contr
 	return team, nil
 }
 
-func (r *PeriodicSyncer) syncOrganization(ctx context.Context, g keycloak.Group, org *orgv1.Organization) (*orgv1.Organization, error) {
+func (r *PeriodicSyncer) syncOrganization(ctx context.Context, g idp.Group, org *orgv1.Organization) (*orgv1.Organization, error) {
 	logger := log.FromContext(ctx)
 	var err error
 
@@ -161,6 +238,7 @@ func (r *PeriodicSyncer) syncOrganization(ctx context.Context, g keycloak.Group,
 		if err != nil {
 			return org, err
 		}
+		syncCreatedTotal.WithLabelValues("organization").Inc()
 	}
 	if org.Annotations[orgImportAnnot] == "true" {
 		logger.V(1).WithValues("group", g).Info("updating organization members")
@@ -208,7 +286,7 @@ func (r *PeriodicSyncer) fetchAPIUsers(ctx context.Context) (map[string]struct{}
 	return userMap, nil
 }
 
-func (r *PeriodicSyncer) createTeam(ctx context.Context, namespace, name string, members []keycloak.User) (*controlv1.Team, error) {
+func (r *PeriodicSyncer) createTeam(ctx context.Context, namespace, name string, group idp.Group) (*controlv1.Team, error) {
 	team := &controlv1.Team{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -218,16 +296,21 @@ func (r *PeriodicSyncer) createTeam(ctx context.Context, namespace, name string,
 			DisplayName: name,
 		},
 	}
+	if group.ID() != "" {
+		team.Annotations = map[string]string{
+			groupIDAnnot: group.ID(),
+		}
+	}
 
-	team.Spec.UserRefs = make([]controlv1.UserRef, len(members))
-	for i, m := range members {
+	team.Spec.UserRefs = make([]controlv1.UserRef, len(group.Members))
+	for i, m := range group.Members {
 		team.Spec.UserRefs[i] = controlv1.UserRef{Name: m.Username}
 	}
 	err := r.Create(ctx, team)
 	return team, err
 }
 
-func (r *PeriodicSyncer) startImportOrganizationFromGroup(ctx context.Context, group keycloak.Group) (*orgv1.Organization, error) {
+func (r *PeriodicSyncer) startImportOrganizationFromGroup(ctx context.Context, group idp.Group) (*orgv1.Organization, error) {
 	org := &orgv1.Organization{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: group.BaseName(),
@@ -239,6 +322,10 @@ func (r *PeriodicSyncer) startImportOrganizationFromGroup(ctx context.Context, g
 			DisplayName: group.BaseName(),
 		},
 	}
+	r.Attributes.applyToOrganization(group, org)
+	if group.ID() != "" {
+		org.Annotations[groupIDAnnot] = group.ID()
+	}
 	err := r.Create(ctx, org)
 	return org, err
 }
@@ -249,13 +336,16 @@ func (r *PeriodicSyncer) finishImportOrganizationFromGroup(ctx context.Context,
 
 }
 
-func (r *PeriodicSyncer) updateOrganizationMembersFromGroup(ctx context.Context, group keycloak.Group) error {
+func (r *PeriodicSyncer) updateOrganizationMembersFromGroup(ctx context.Context, group idp.Group) error {
 	orgMemb := controlv1.OrganizationMembers{}
 	err := r.Get(ctx, types.NamespacedName{
 		Namespace: group.BaseName(),
 		Name:      "members",
 	}, &orgMemb)
-	if err != nil {
+	if apierrors.IsNotFound(err) && r.DryRun {
+		orgMemb.Namespace = group.BaseName()
+		orgMemb.Name = "members"
+	} else if err != nil {
 		return err
 	}
 	orgMemb.Spec.UserRefs = make([]controlv1.UserRef, len(group.Members))
@@ -265,7 +355,7 @@ func (r *PeriodicSyncer) updateOrganizationMembersFromGroup(ctx context.Context,
 	return r.Update(ctx, &orgMemb)
 }
 
-func (r *PeriodicSyncer) setRolebindingsFromGroup(ctx context.Context, group keycloak.Group) error {
+func (r *PeriodicSyncer) setRolebindingsFromGroup(ctx context.Context, group idp.Group) error {
 	subjects := []rbacv1.Subject{}
 	for _, m := range group.Members {
 		subjects = append(subjects, rbacv1.Subject{
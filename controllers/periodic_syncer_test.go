@@ -2,6 +2,7 @@ package controllers_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	orgv1 "github.com/appuio/control-api/apis/organization/v1"
@@ -10,13 +11,14 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 
 	. "github.com/vshn/appuio-keycloak-adapter/controllers"
 
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 func Test_Sync_Success(t *testing.T) {
@@ -45,19 +47,19 @@ func Test_Sync_Success(t *testing.T) {
 		},
 	)
 
-	barOrg := keycloak.NewGroup("Bar Inc.", "bar")
-	barOrg.Members = []keycloak.User{
+	barOrg := idp.NewGroup("Bar Inc.", "bar").WithID("bar-id")
+	barOrg.Members = []idp.User{
 		{Username: "bar", DefaultOrganizationRef: "bar"},
 		{Username: "bar3", DefaultOrganizationRef: "bar-mss"},
 	}
-	barTeam := keycloak.NewGroup("Bar Team", "bar", "bar-team")
-	barTeam.Members = []keycloak.User{
+	barTeam := idp.NewGroup("Bar Team", "bar", "bar-team").WithID("bar-team-id")
+	barTeam.Members = []idp.User{
 		{Username: "bar-tm-1"},
 		{Username: "bar-tm-2", DefaultOrganizationRef: "bar-outsourcing"},
 	}
 	keyMock.EXPECT().
 		ListGroups(gomock.Any()).
-		Return([]keycloak.Group{barOrg, barTeam}, nil).
+		Return([]idp.Group{barOrg, barTeam}, nil).
 		Times(1)
 
 	err := (&PeriodicSyncer{
@@ -72,6 +74,7 @@ func Test_Sync_Success(t *testing.T) {
 	newOrg := orgv1.Organization{}
 	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "bar"}, &newOrg))
 	assert.NotContains(t, newOrg.Annotations, "keycloak-adapter.vshn.net/importing")
+	assert.Equal(t, "bar-id", newOrg.Annotations["keycloak-adapter.vshn.net/group-id"])
 	newMemb := controlv1.OrganizationMembers{}
 	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "members", Namespace: "bar"}, &newMemb))
 	assert.ElementsMatch(t, []controlv1.UserRef{
@@ -112,10 +115,11 @@ func Test_Sync_Success(t *testing.T) {
 		{Name: "bar-tm-1"},
 		{Name: "bar-tm-2"},
 	}, newTeam.Spec.UserRefs, "user refs for created team")
+	assert.Equal(t, "bar-team-id", newTeam.Annotations["keycloak-adapter.vshn.net/group-id"])
 
 	createdUsers := controlv1.UserList{}
 	require.NoError(t, c.List(ctx, &createdUsers), "create users")
-	comparable := make([]keycloak.User, len(createdUsers.Items))
+	comparable := make([]idp.User, len(createdUsers.Items))
 	for i := range createdUsers.Items {
 		comparable[i].Username = createdUsers.Items[i].Name
 		comparable[i].DefaultOrganizationRef = createdUsers.Items[i].Spec.Preferences.DefaultOrganizationRef
@@ -134,9 +138,9 @@ func Test_Sync_Fail_Update(t *testing.T) {
 	})
 	// By not adding buzzMember manually we simulate an error while updating the members resource
 
-	groups := []keycloak.Group{
-		keycloak.NewGroup("Buzz Inc.", "buzz").WithMemberNames("buzz1", "buzz"),
-		keycloak.NewGroup("Bar Inc.", "bar").WithMemberNames("bar", "bar3"),
+	groups := []idp.Group{
+		idp.NewGroup("Buzz Inc.", "buzz").WithMemberNames("buzz1", "buzz"),
+		idp.NewGroup("Bar Inc.", "bar").WithMemberNames("bar", "bar3"),
 	}
 	keyMock.EXPECT().
 		ListGroups(gomock.Any()).
@@ -169,14 +173,50 @@ func Test_Sync_Fail_Update(t *testing.T) {
 
 }
 
+func Test_Sync_Metrics(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb)
+	keyMock.EXPECT().
+		ListGroups(gomock.Any()).
+		Return([]idp.Group{
+			idp.NewGroup("Foo Inc.", "foo").WithMemberNames("bar", "bar3"),
+			idp.NewGroup("Foo Team", "foo", "new-team").WithMemberNames("new-member"),
+		}, nil).
+		Times(1)
+	keyMock.EXPECT().
+		ListGroups(gomock.Any()).
+		Return(nil, errors.New("unavailable")).
+		Times(1)
+
+	teamsBefore := metricValue(t, "appuio_keycloak_adapter_sync_created_total", "kind", "team")
+	usersBefore := metricValue(t, "appuio_keycloak_adapter_sync_created_total", "kind", "user")
+	failuresBefore := metricValue(t, "appuio_keycloak_adapter_sync_failures_total", "reason", "list_groups")
+
+	syncer := &PeriodicSyncer{
+		Client:   c,
+		Keycloak: keyMock,
+	}
+	require.NoError(t, syncer.Sync(ctx))
+	lastSuccess := metricValue(t, "appuio_keycloak_adapter_sync_last_success_timestamp_seconds")
+	require.Error(t, syncer.Sync(ctx))
+
+	assert.Equal(t, teamsBefore+1, metricValue(t, "appuio_keycloak_adapter_sync_created_total", "kind", "team"))
+	assert.Equal(t, usersBefore+3, metricValue(t, "appuio_keycloak_adapter_sync_created_total", "kind", "user"))
+	assert.Equal(t, failuresBefore+1, metricValue(t, "appuio_keycloak_adapter_sync_failures_total", "reason", "list_groups"))
+	assert.Equal(t, 2.0, metricValue(t, "appuio_keycloak_adapter_sync_groups"))
+	assert.NotZero(t, lastSuccess)
+	assert.Equal(t, lastSuccess, metricValue(t, "appuio_keycloak_adapter_sync_last_success_timestamp_seconds"), "failed sync does not update last success")
+}
+
 func Test_Sync_Skip_Existing(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb, barTeam) // We need to add barMember manually as there is no control API in the tests creating them
 
-	groups := []keycloak.Group{
-		keycloak.NewGroup("Foo Inc.", "foo").WithMemberNames("foo", "foo2"),
-		keycloak.NewGroup("Foo Inc. Bar Team", "foo", "bar").WithMemberNames("updated-member-1", "updated-member-2"),
+	groups := []idp.Group{
+		idp.NewGroup("Foo Inc.", "foo").WithMemberNames("foo", "foo2"),
+		idp.NewGroup("Foo Inc. Bar Team", "foo", "bar").WithMemberNames("updated-member-1", "updated-member-2"),
 	}
 	keyMock.EXPECT().
 		ListGroups(gomock.Any()).
@@ -207,6 +247,79 @@ func Test_Sync_Skip_Existing(t *testing.T) {
 	}, newTeam.Spec.UserRefs)
 }
 
+func Test_Sync_Skip_NestedBelowTeam(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb, barTeam)
+
+	groups := []idp.Group{
+		idp.NewGroup("Foo Inc.", "foo"),
+		idp.NewGroup("Foo Inc. Bar Team", "foo", "bar"),
+		idp.NewGroup("Sub Team", "foo", "bar", "sub").WithMemberNames("sub-member"),
+	}
+	keyMock.EXPECT().
+		ListGroups(gomock.Any()).
+		Return(groups, nil).
+		Times(1)
+
+	err := (&PeriodicSyncer{
+		Client:   c,
+		Keycloak: keyMock,
+	}).Sync(ctx)
+	require.NoError(t, err)
+
+	teams := controlv1.TeamList{}
+	require.NoError(t, c.List(ctx, &teams))
+	require.Len(t, teams.Items, 1)
+	assert.Equal(t, "bar", teams.Items[0].Name)
+	newOrg := orgv1.Organization{}
+	require.Error(t, c.Get(ctx, types.NamespacedName{Name: "sub"}, &newOrg))
+}
+
+func Test_Sync_ImportAttributes(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, _ := prepareTest(t, &controlv1.OrganizationMembers{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "members",
+			Namespace: "bar",
+		},
+	})
+
+	barOrg := idp.NewGroup("Bar Inc.", "bar").WithAttributes(map[string][]string{
+		"appuio.io/billing-entity":            {"be-1234"},
+		"appuio.io/created":                   {"2023-04-05T06:07:08Z"},
+		"example.com/tier":                    {"gold"},
+		"example.com/contact":                 {"ops@example.com"},
+		"keycloak-adapter.vshn.net/importing": {"false"},
+		"other.com/ignored":                   {"x"},
+	})
+	keyMock.EXPECT().
+		ListGroups(gomock.Any()).
+		Return([]idp.Group{barOrg}, nil).
+		Times(1)
+
+	err := (&PeriodicSyncer{
+		Client:   c,
+		Keycloak: keyMock,
+		Attributes: OrganizationAttributes{
+			BillingEntityRef:  "appuio.io/billing-entity",
+			CreationTimestamp: "appuio.io/created",
+			Labels:            []string{"example.com/tier"},
+			AnnotationPrefix:  "example.com/",
+		},
+	}).Sync(ctx)
+	require.NoError(t, err)
+
+	newOrg := orgv1.Organization{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "bar"}, &newOrg))
+	assert.Equal(t, "be-1234", newOrg.Spec.BillingEntityRef)
+	assert.Equal(t, map[string]string{"example.com/tier": "gold"}, newOrg.Labels)
+	assert.Equal(t, "ops@example.com", newOrg.Annotations["example.com/contact"])
+	assert.NotContains(t, newOrg.Annotations, "other.com/ignored")
+	assert.NotContains(t, newOrg.Annotations, "keycloak-adapter.vshn.net/importing")
+}
+
 func Test_Sync_Skip_ExistingUsers(t *testing.T) {
 	ctx := context.Background()
 	subject := controlv1.User{
@@ -226,8 +339,8 @@ func Test_Sync_Skip_ExistingUsers(t *testing.T) {
 
 	c, keyMock, _ := prepareTest(t, fooOrg, fooMemb, &subject)
 
-	fooGroup := keycloak.NewGroup("Foo Inc.", "foo")
-	fooGroup.Members = []keycloak.User{
+	fooGroup := idp.NewGroup("Foo Inc.", "foo")
+	fooGroup.Members = []idp.User{
 		{
 			Username:               subject.Name,
 			DefaultOrganizationRef: "updated-organization",
@@ -236,7 +349,7 @@ func Test_Sync_Skip_ExistingUsers(t *testing.T) {
 
 	keyMock.EXPECT().
 		ListGroups(gomock.Any()).
-		Return([]keycloak.Group{fooGroup}, nil).
+		Return([]idp.Group{fooGroup}, nil).
 		Times(1)
 
 	err := (&PeriodicSyncer{
@@ -256,9 +369,9 @@ func Test_Sync_Skip_UserInMultipleGroups(t *testing.T) {
 
 	keyMock.EXPECT().
 		ListGroups(gomock.Any()).
-		Return([]keycloak.Group{
-			keycloak.NewGroup("Foo Inc.", "foo").WithMemberNames("in-multiple-groups"),
-			keycloak.NewGroup("Foo Inc. Bar Team", "foo", "bar").WithMemberNames("in-multiple-groups"),
+		Return([]idp.Group{
+			idp.NewGroup("Foo Inc.", "foo").WithMemberNames("in-multiple-groups"),
+			idp.NewGroup("Foo Inc. Bar Team", "foo", "bar").WithMemberNames("in-multiple-groups"),
 		}, nil).
 		Times(1)
 
@@ -268,3 +381,87 @@ func Test_Sync_Skip_UserInMultipleGroups(t *testing.T) {
 	}).Sync(ctx)
 	require.NoError(t, err)
 }
+
+func Test_SyncUserStatus(t *testing.T) {
+	ctx := context.Background()
+
+	unchanged := &controlv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "unchanged"},
+		Status: controlv1.UserStatus{
+			ID:          "unchanged-id",
+			Username:    "unchanged",
+			Email:       "unchanged@example.com",
+			DisplayName: "Un Changed",
+		},
+	}
+	changed := &controlv1.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "changed"},
+		Status: controlv1.UserStatus{
+			ID:          "changed-id",
+			Username:    "changed",
+			Email:       "old@example.com",
+			DisplayName: "Old Name",
+		},
+	}
+	c, keyMock, _ := prepareTest(t, unchanged, changed)
+	keyMock.EXPECT().
+		ListUsers(gomock.Any()).
+		Return([]idp.User{
+			{ID: "unchanged-id", Username: "unchanged", Email: "unchanged@example.com", FirstName: "Un", LastName: "Changed"},
+			{ID: "changed-id", Username: "changed", Email: "new@example.com", FirstName: "New", LastName: "Name"},
+			{ID: "unknown-id", Username: "unknown"},
+		}, nil).
+		Times(1)
+
+	before := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "unchanged"}, &before))
+
+	err := (&PeriodicSyncer{
+		Client:   c,
+		Keycloak: keyMock,
+	}).SyncUserStatus(ctx)
+	require.NoError(t, err)
+
+	after := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "unchanged"}, &after))
+	assert.Equal(t, before.ResourceVersion, after.ResourceVersion, "unchanged user not written")
+
+	updated := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "changed"}, &updated))
+	assert.Equal(t, "new@example.com", updated.Status.Email)
+	assert.Equal(t, "New Name", updated.Status.DisplayName)
+	assert.Equal(t, "changed-id", updated.Status.ID)
+
+	require.Error(t, c.Get(ctx, types.NamespacedName{Name: "unknown"}, &controlv1.User{}), "users are not created")
+}
+
+// metricValue returns the value of the counter or gauge with the given name and label pairs from the controller-runtime metrics registry.
+// Returns 0 if there is no such metric.
+func metricValue(t *testing.T, name string, labels ...string) float64 {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for i := 0; i+1 < len(labels); i += 2 {
+				found := false
+				for _, l := range m.GetLabel() {
+					if l.GetName() == labels[i] && l.GetValue() == labels[i+1] {
+						found = true
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			if m.GetCounter() != nil {
+				return m.GetCounter().GetValue()
+			}
+			return m.GetGauge().GetValue()
+		}
+	}
+	return 0
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// ImportSyncJob is the name of the job importing organizations from Keycloak.
+	ImportSyncJob = "import"
+	// UserStatusSyncJob is the name of the job refreshing the status of Users from Keycloak.
+	UserStatusSyncJob = "user-status"
+)
+
+// ErrUnknownSyncJob is returned when triggering a job that does not exist.
+var ErrUnknownSyncJob = errors.New("unknown sync job")
+
+// SyncTrigger requests on-demand synchronizations.
+type SyncTrigger interface {
+	// Trigger runs the job with the given name as soon as possible.
+	Trigger(name string) error
+}
+
+// SyncJob is a synchronization run periodically by a SyncRunnable.
+type SyncJob struct {
+	// Name identifies the job when triggering it on demand.
+	Name string
+	// Schedule decides when the job runs.
+	Schedule cron.Schedule
+	// Sync is the synchronization to run.
+	Sync func(ctx context.Context) error
+	// ErrorMessage is logged when the synchronization fails.
+	ErrorMessage string
+}
+
+// Backoff configures the retries of a failed synchronization.
+type Backoff struct {
+	// Attempts is the maximum number of attempts of a synchronization, including the first one.
+	Attempts int
+	// Initial is the delay before the first retry. It doubles with every retry.
+	Initial time.Duration
+	// Max caps the delay between retries. Not capped if 0.
+	Max time.Duration
+}
+
+// DefaultBackoff is used by a SyncRunnable without a Backoff.
+var DefaultBackoff = Backoff{Attempts: 6, Initial: 500 * time.Millisecond}
+
+// SyncRunnable runs periodic synchronizations, such as the organization import of the PeriodicSyncer, as part of the manager.
+// It needs leader election, so with several replicas only the leader runs the synchronizations.
+// The synchronizations stop with the manager.
+//
+// A job never runs concurrently with itself.
+// If a job is due while it is still running, it runs once more after the current run finished.
+// Further runs due in the meantime are skipped.
+type SyncRunnable struct {
+	// Jobs are the synchronizations to run.
+	// They must not be changed after the first call to Start or Trigger.
+	Jobs []SyncJob
+	// Timeout is the timeout of a single synchronization attempt.
+	Timeout time.Duration
+	// Jitter is the maximum of a random delay added to every scheduled run.
+	// Runs triggered on demand are not delayed.
+	Jitter time.Duration
+	// Backoff configures the retries of failed synchronizations.
+	// DefaultBackoff is used if empty.
+	Backoff Backoff
+
+	once sync.Once
+	// queues hold the pending run of each job.
+	queues []chan struct{}
+
+	mu sync.Mutex
+	// finished holds the time each job last finished a run, or the start of the runnable.
+	finished []time.Time
+}
+
+var _ manager.LeaderElectionRunnable = &SyncRunnable{}
+var _ SyncTrigger = &SyncRunnable{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (r *SyncRunnable) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs the jobs on their schedules, and whenever they are triggered, until the context is cancelled.
+// It waits for running jobs to finish before returning.
+func (r *SyncRunnable) Start(ctx context.Context) error {
+	r.init()
+	start := time.Now()
+	for i := range r.Jobs {
+		r.setFinished(i, start)
+	}
+
+	var wg sync.WaitGroup
+	for i, job := range r.Jobs {
+		i, job, queue := i, job, r.queues[i]
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.schedule(ctx, job, queue)
+		}()
+		go func() {
+			defer wg.Done()
+			r.work(ctx, job, queue, func() { r.setFinished(i, time.Now()) })
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Trigger runs the job with the given name as soon as possible, without jitter.
+// If the job is running, it runs again after the current run finished.
+// Triggers before the start of the runnable are kept until it starts.
+func (r *SyncRunnable) Trigger(name string) error {
+	r.init()
+
+	for i, job := range r.Jobs {
+		if job.Name == name {
+			r.enqueue(job, r.queues[i])
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownSyncJob, name)
+}
+
+// LivenessCheck returns a health check failing if a job has not finished a run within the given number of its schedule intervals, e.g. because a synchronization is stuck.
+// The check passes as long as the runnable is not started, so replicas which are not the leader are not affected.
+func (r *SyncRunnable) LivenessCheck(intervals int) healthz.Checker {
+	return func(_ *http.Request) error {
+		r.init()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		now := time.Now()
+		for i, job := range r.Jobs {
+			last := r.finished[i]
+			deadline := last
+			for n := 0; n < intervals && !deadline.IsZero(); n++ {
+				deadline = job.Schedule.Next(deadline)
+			}
+			if deadline.IsZero() {
+				continue
+			}
+			if now.After(deadline.Add(r.Jitter)) {
+				return fmt.Errorf("sync job %q has not finished a run since %s", job.Name, last.Format(time.RFC3339))
+			}
+		}
+		return nil
+	}
+}
+
+func (r *SyncRunnable) setFinished(i int, t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished[i] = t
+}
+
+func (r *SyncRunnable) init() {
+	r.once.Do(func() {
+		r.finished = make([]time.Time, len(r.Jobs))
+		r.queues = make([]chan struct{}, len(r.Jobs))
+		for i := range r.queues {
+			r.queues[i] = make(chan struct{}, 1)
+		}
+	})
+}
+
+// schedule queues runs of the job on its schedule.
+func (r *SyncRunnable) schedule(ctx context.Context, job SyncJob, queue chan struct{}) {
+	next := job.Schedule.Next(time.Now())
+	for !next.IsZero() {
+		timer := time.NewTimer(time.Until(next) + r.jitter())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		r.enqueue(job, queue)
+
+		// Keep the schedule independent of the jitter, unless the jitter delayed the run past the next one.
+		now := time.Now()
+		next = job.Schedule.Next(next)
+		if next.Before(now) {
+			next = job.Schedule.Next(now)
+		}
+	}
+}
+
+// work runs the queued runs of the job one after the other, and calls finished after every run.
+func (r *SyncRunnable) work(ctx context.Context, job SyncJob, queue chan struct{}, finished func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-queue:
+			r.run(ctx, job)
+			finished()
+		}
+	}
+}
+
+// enqueue queues a run of the job, unless a run is already queued.
+func (r *SyncRunnable) enqueue(job SyncJob, queue chan struct{}) {
+	select {
+	case queue <- struct{}{}:
+	default:
+		ctrl.Log.WithName("sync").V(1).Info("skipping run, job is already queued", "job", job.Name)
+	}
+}
+
+func (r *SyncRunnable) jitter() time.Duration {
+	if r.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(r.Jitter)))
+}
+
+func (r *SyncRunnable) run(ctx context.Context, job SyncJob) {
+	syncLog := ctrl.Log.WithName("sync")
+	backoff := r.Backoff
+	if backoff == (Backoff{}) {
+		backoff = DefaultBackoff
+	}
+	err := runWithBackoff(ctx, backoff,
+		func() error {
+			rCtx, cancel := context.WithTimeout(ctx, r.Timeout)
+			rCtx = logr.NewContext(rCtx, syncLog)
+			defer cancel()
+
+			return job.Sync(rCtx)
+		},
+		func(err error) {
+			syncLog.Error(err, job.ErrorMessage)
+		})
+	if err != nil {
+		syncLog.Info(job.ErrorMessage + " - giving up")
+	}
+}
+
+func runWithBackoff(ctx context.Context, b Backoff, run func() error, errRecorder func(err error)) error {
+	var err error
+	delay := b.Initial
+	for i := 0; i < b.Attempts || i == 0; i++ {
+		if i > 0 {
+			if b.Max > 0 && delay > b.Max {
+				delay = b.Max
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		err = run()
+		if err == nil {
+			return nil
+		}
+		errRecorder(err)
+	}
+	return err
+}
@@ -0,0 +1,195 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/controllers"
+)
+
+// every is a cron.Schedule with sub-second intervals.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// never is a cron.Schedule which never runs.
+type never struct{}
+
+func (never) Next(time.Time) time.Time {
+	return time.Time{}
+}
+
+func Test_SyncRunnable(t *testing.T) {
+	r := &SyncRunnable{Timeout: time.Second, Jitter: 5 * time.Millisecond}
+	assert.True(t, r.NeedLeaderElection(), "only the leader syncs")
+
+	var runs, failing atomic.Int32
+	r.Jobs = []SyncJob{
+		{
+			Schedule: every(10 * time.Millisecond),
+			Sync: func(ctx context.Context) error {
+				runs.Add(1)
+				return nil
+			},
+		},
+		{
+			Schedule: every(10 * time.Millisecond),
+			Sync: func(ctx context.Context) error {
+				failing.Add(1)
+				return errors.New("unavailable")
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Start(ctx) }()
+
+	require.Eventually(t, func() bool { return runs.Load() >= 2 && failing.Load() >= 1 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("runnable did not stop with the context")
+	}
+
+	stopped := runs.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, stopped, runs.Load(), "no runs after stopping")
+}
+
+func Test_SyncRunnable_NoOverlap(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var runs, running, overlaps atomic.Int32
+	r := &SyncRunnable{
+		Timeout: time.Second,
+		Jobs: []SyncJob{{
+			Name:     "import",
+			Schedule: never{},
+			Sync: func(ctx context.Context) error {
+				if running.Add(1) > 1 {
+					overlaps.Add(1)
+				}
+				defer running.Add(-1)
+				runs.Add(1)
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-release
+				return nil
+			},
+		}},
+	}
+	require.ErrorIs(t, r.Trigger("unknown"), ErrUnknownSyncJob)
+	require.NoError(t, r.Trigger("import"), "triggers before the start are kept")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Start(ctx) }()
+
+	<-started
+	for i := 0; i < 3; i++ {
+		require.NoError(t, r.Trigger("import"))
+	}
+	close(release)
+
+	require.Eventually(t, func() bool { return runs.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), runs.Load(), "triggers during a run are merged into a single run")
+	assert.Zero(t, overlaps.Load(), "runs never overlap")
+}
+
+func Test_SyncRunnable_Backoff(t *testing.T) {
+	var attempts atomic.Int32
+	r := &SyncRunnable{
+		Timeout: time.Second,
+		Backoff: Backoff{Attempts: 3, Initial: time.Millisecond, Max: 2 * time.Millisecond},
+		Jobs: []SyncJob{{
+			Name:     "import",
+			Schedule: never{},
+			Sync: func(ctx context.Context) error {
+				attempts.Add(1)
+				return errors.New("unavailable")
+			},
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Start(ctx) }()
+	require.NoError(t, r.Trigger("import"))
+
+	require.Eventually(t, func() bool { return attempts.Load() == 3 }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(3), attempts.Load(), "gives up after the configured attempts")
+}
+
+func Test_SyncRunnable_LivenessCheck(t *testing.T) {
+	release := make(chan struct{})
+	r := &SyncRunnable{
+		Timeout: time.Second,
+		Jobs: []SyncJob{{
+			Name:     "import",
+			Schedule: every(10 * time.Millisecond),
+			Sync: func(ctx context.Context) error {
+				<-release
+				return nil
+			},
+		}},
+	}
+	check := r.LivenessCheck(3)
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	require.NoError(t, check(req), "passes if not started")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Start(ctx) }()
+
+	require.Eventually(t, func() bool { return check(req) != nil }, 5*time.Second, 5*time.Millisecond, "fails if stuck")
+	assert.ErrorContains(t, check(req), `sync job "import" has not finished a run`)
+	close(release)
+	require.Eventually(t, func() bool { return check(req) == nil }, 5*time.Second, 5*time.Millisecond, "passes once a run finished")
+}
+
+func Test_SyncTriggerServer(t *testing.T) {
+	var triggered []string
+	s := &SyncTriggerServer{Trigger: triggerFunc(func(name string) error {
+		if name != "import" {
+			return ErrUnknownSyncJob
+		}
+		triggered = append(triggered, name)
+		return nil
+	})}
+	assert.True(t, s.NeedLeaderElection(), "only the leader accepts triggers")
+
+	tcs := map[string]struct {
+		method, path string
+		status       int
+	}{
+		"trigger":     {http.MethodPost, "/sync/import", http.StatusAccepted},
+		"unknown job": {http.MethodPost, "/sync/foo", http.StatusNotFound},
+		"wrong path":  {http.MethodPost, "/import", http.StatusNotFound},
+		"no job":      {http.MethodPost, "/sync/", http.StatusNotFound},
+		"get":         {http.MethodGet, "/sync/import", http.StatusMethodNotAllowed},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+	assert.Equal(t, []string{"import"}, triggered)
+}
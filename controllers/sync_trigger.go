@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// SyncTriggerServer serves on-demand triggers of synchronizations over HTTP.
+// A `POST /sync/<job>` request runs the job with the given name as soon as possible, e.g. `POST /sync/import` runs the organization import.
+// It needs leader election, so only the replica running the synchronizations accepts triggers.
+type SyncTriggerServer struct {
+	// Addr is the address the server binds to.
+	Addr string
+	// Trigger runs the requested jobs.
+	Trigger SyncTrigger
+}
+
+var _ manager.LeaderElectionRunnable = &SyncTriggerServer{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (s *SyncTriggerServer) NeedLeaderElection() bool {
+	return true
+}
+
+// Start serves triggers until the context is cancelled.
+func (s *SyncTriggerServer) Start(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// ServeHTTP implements http.Handler.
+// It responds with 202 Accepted if the job was triggered.
+func (s *SyncTriggerServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/sync/")
+	if name == req.URL.Path || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.Trigger.Trigger(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUnknownSyncJob) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
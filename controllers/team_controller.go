@@ -5,13 +5,16 @@ import (
 	"errors"
 
 	controlv1 "github.com/appuio/control-api/apis/v1"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TeamReconciler reconciles a Team object
@@ -28,7 +31,13 @@ type TeamReconciler struct {
 //+kubebuilder:rbac:groups=appuio.io,resources=teams/finalizers,verbs=update
 
 // Reconcile reacts on changes of teams and mirrors these changes to groups in Keycloak
-func (r *TeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *TeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracer.Start(ctx, "TeamReconciler.Reconcile", trace.WithAttributes(
+		attribute.String("organization", req.Namespace),
+		attribute.String("team", req.Name),
+	))
+	defer func() { endSpan(span, err) }()
+
 	log := log.FromContext(ctx)
 	log.V(4).WithValues("request", req).Info("Reconciling")
 
@@ -37,37 +46,50 @@ func (r *TeamReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	if err := r.Get(ctx, req.NamespacedName, team); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = withInvolvedObject(ctx, team)
 
 	if !team.ObjectMeta.DeletionTimestamp.IsZero() {
 		log.V(4).Info("Deleting Keycloak group..")
-		err := r.Keycloak.DeleteGroup(ctx, team.Namespace, team.Name)
+		err := r.Keycloak.DeleteGroup(ctx, idp.NewGroup(team.Spec.DisplayName, team.Namespace, team.Name).WithID(team.Annotations[groupIDAnnot]))
 		if err != nil {
-			r.Recorder.Event(team, "Warning", "DeletionFailed", "Failed to delete Keycloak Group")
-			return ctrl.Result{}, err
+			forEachRealmError(err, func(realm string, _ error) {
+				r.Recorder.Event(team, "Warning", "DeletionFailed", "Failed to delete Keycloak Group"+inRealm(realm))
+			})
+			return requeueOnKeycloakError(ctx, err)
 		}
 
 		err = r.removeFinalizer(ctx, team)
 		return ctrl.Result{}, err
 	}
-	err := r.addFinalizer(ctx, team)
+	err = r.addFinalizer(ctx, team)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
 	log.V(4).Info("Reconciling Keycloak group..")
 	group, err := r.Keycloak.PutGroup(ctx, buildTeamKeycloakGroup(team))
-	var membErrs *keycloak.MembershipSyncErrors
-	if errors.As(err, &membErrs) {
+	var failed RealmErrors
+	forEachRealmError(err, func(realm string, err error) {
+		var membErrs *idp.MembershipSyncErrors
+		if !errors.As(err, &membErrs) {
+			r.Recorder.Event(team, "Warning", "UpdateFailed", "Failed to update Keycloak Group"+inRealm(realm))
+			failed = append(failed, RealmError{Realm: realm, Err: err})
+			return
+		}
 		for _, membErr := range *membErrs {
-			r.Recorder.Eventf(team, "Warning", string(membErr.Event), "Failed to update membership of user %s", membErr.Username)
-			log.Error(membErr, "Failed to update membership", "user", membErr.Username)
+			r.Recorder.Eventf(team, "Warning", string(membErr.Event), "Failed to update membership of user %s"+inRealm(realm), membErr.Username)
+			log.Error(membErr, "Failed to update membership", "user", membErr.Username, "realm", realm)
 		}
-	} else if err != nil {
-		r.Recorder.Event(team, "Warning", "UpdateFailed", "Failed to update Keycloak Group")
-		return ctrl.Result{}, err
+	})
+	if len(failed) > 0 {
+		return requeueOnKeycloakError(ctx, failed)
 	}
 
 	log.V(4).Info("Updating status..")
+	err = setGroupIDAnnotation(ctx, r.Client, team, group.ID())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 	err = r.updateTeamStatus(ctx, team, group)
 	return ctrl.Result{}, err
 }
@@ -92,7 +114,7 @@ func (r *TeamReconciler) removeFinalizer(ctx context.Context, team client.Object
 	return nil
 }
 
-func (r *TeamReconciler) updateTeamStatus(ctx context.Context, team *controlv1.Team, group keycloak.Group) error {
+func (r *TeamReconciler) updateTeamStatus(ctx context.Context, team *controlv1.Team, group idp.Group) error {
 	userRefs := make([]controlv1.UserRef, 0, len(group.Members))
 	for _, u := range group.Members {
 		userRefs = append(userRefs, controlv1.UserRef{
@@ -103,14 +125,16 @@ func (r *TeamReconciler) updateTeamStatus(ctx context.Context, team *controlv1.T
 	return r.Status().Update(ctx, team)
 }
 
-func buildTeamKeycloakGroup(team *controlv1.Team) keycloak.Group {
+func buildTeamKeycloakGroup(team *controlv1.Team) idp.Group {
 	groupMem := make([]string, 0, len(team.Spec.UserRefs))
 
 	for _, u := range team.Spec.UserRefs {
 		groupMem = append(groupMem, u.Name)
 	}
 
-	return keycloak.NewGroup(team.Spec.DisplayName, team.Namespace, team.Name).WithMemberNames(groupMem...)
+	return idp.NewGroup(team.Spec.DisplayName, team.Namespace, team.Name).
+		WithID(team.Annotations[groupIDAnnot]).
+		WithMemberNames(groupMem...)
 }
 
 // SetupWithManager sets up the controller with the Manager.
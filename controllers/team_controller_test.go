@@ -9,7 +9,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -22,7 +22,7 @@ func Test_TeamController_Reconcile_Success(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, _ := prepareTest(t, barTeam)
-	group := keycloak.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
+	group := idp.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
 		Return(group, nil).
@@ -46,14 +46,42 @@ func Test_TeamController_Reconcile_Success(t *testing.T) {
 	assert.Equal(t, "keycloak-adapter.vshn.net/finalizer", reconciledTeam.Finalizers[0], "expected finalizer")
 }
 
+func Test_TeamController_Reconcile_StoreGroupID(t *testing.T) {
+	ctx := context.Background()
+
+	c, keyMock, _ := prepareTest(t, barTeam)
+	group := idp.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
+	keyMock.EXPECT().
+		PutGroup(gomock.Any(), group).
+		Return(group.WithID("bar-id"), nil).
+		Times(1)
+
+	_, err := (&TeamReconciler{
+		Client:   c,
+		Scheme:   &runtime.Scheme{},
+		Keycloak: keyMock,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Namespace: barTeam.Namespace,
+			Name:      barTeam.Name,
+		},
+	})
+	require.NoError(t, err)
+
+	reconciledTeam := controlv1.Team{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: barTeam.Namespace, Name: barTeam.Name}, &reconciledTeam))
+	assert.Equal(t, "bar-id", reconciledTeam.Annotations["keycloak-adapter.vshn.net/group-id"])
+	assert.ElementsMatch(t, []controlv1.UserRef{{Name: "baz"}, {Name: "qux"}}, reconciledTeam.Status.ResolvedUserRefs)
+}
+
 func Test_TeamController_Reconcile_Failure(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, erMock := prepareTest(t, barTeam)
-	group := keycloak.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
+	group := idp.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
-		Return(keycloak.Group{}, errors.New("create failed")).
+		Return(idp.Group{}, errors.New("create failed")).
 		Times(1)
 
 	erMock.EXPECT().
@@ -83,28 +111,28 @@ func Test_TeamController_Reconcile_Member_Failure(t *testing.T) {
 	ctx := context.Background()
 
 	c, keyMock, erMock := prepareTest(t, barTeam)
-	group := keycloak.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
+	group := idp.NewGroup(barTeam.Spec.DisplayName, barTeam.Namespace, barTeam.Name).WithMemberNames("baz", "qux")
 	keyMock.EXPECT().
 		PutGroup(gomock.Any(), group).
-		Return(keycloak.Group{}, &keycloak.MembershipSyncErrors{
+		Return(idp.Group{}, &idp.MembershipSyncErrors{
 			{
 				Err:      errors.New("no user 'bar' found"),
 				Username: "bar",
-				Event:    keycloak.UserAddError,
+				Event:    idp.UserAddError,
 			},
 			{
 				Err:      errors.New("permission denied"),
 				Username: "foo",
-				Event:    keycloak.UserRemoveError,
+				Event:    idp.UserRemoveError,
 			},
 		}).
 		Times(1)
 
 	erMock.EXPECT().
-		Eventf(gomock.Any(), "Warning", string(keycloak.UserRemoveError), gomock.Any(), "foo").
+		Eventf(gomock.Any(), "Warning", string(idp.UserRemoveError), gomock.Any(), "foo").
 		Times(1)
 	erMock.EXPECT().
-		Eventf(gomock.Any(), "Warning", string(keycloak.UserAddError), gomock.Any(), "bar").
+		Eventf(gomock.Any(), "Warning", string(idp.UserAddError), gomock.Any(), "bar").
 		Times(1)
 
 	_, err := (&TeamReconciler{
@@ -136,7 +164,7 @@ func Test_TeamController_Reconcile_Delete(t *testing.T) {
 
 	c, keyMock, _ := prepareTest(t, &team)
 	keyMock.EXPECT().
-		DeleteGroup(gomock.Any(), team.Namespace, team.Name).
+		DeleteGroup(gomock.Any(), idp.NewGroup(team.Spec.DisplayName, team.Namespace, team.Name)).
 		Return(nil).
 		Times(1)
 
@@ -169,7 +197,7 @@ func Test_TeamController_Reconcile_Delete_Failure(t *testing.T) {
 
 	c, keyMock, erMock := prepareTest(t, &team)
 	keyMock.EXPECT().
-		DeleteGroup(gomock.Any(), team.Namespace, team.Name).
+		DeleteGroup(gomock.Any(), idp.NewGroup(team.Spec.DisplayName, team.Namespace, team.Name)).
 		Return(errors.New("Failed to delete")).
 		Times(1)
 
@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the reconcilers and the syncer.
+// It uses the global tracer provider, which does not record anything unless configured.
+var tracer = otel.Tracer("github.com/vshn/appuio-keycloak-adapter/controllers")
+
+// endSpan records the given error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
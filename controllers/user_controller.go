@@ -2,16 +2,46 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
 	controlv1 "github.com/appuio/control-api/apis/v1"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UserDeletionPolicy defines what happens to the Keycloak user if a User is deleted.
+type UserDeletionPolicy string
+
+const (
+	// UserDeletionPolicyKeep leaves the Keycloak user untouched.
+	UserDeletionPolicyKeep UserDeletionPolicy = "keep"
+	// UserDeletionPolicyRemoveFromGroups removes the Keycloak user from all organization and team groups.
+	UserDeletionPolicyRemoveFromGroups UserDeletionPolicy = "remove-from-groups"
+	// UserDeletionPolicyDisable disables the Keycloak user.
+	UserDeletionPolicyDisable UserDeletionPolicy = "disable"
+	// UserDeletionPolicyDelete deletes the Keycloak user.
+	UserDeletionPolicyDelete UserDeletionPolicy = "delete"
 )
 
+// ParseUserDeletionPolicy returns the UserDeletionPolicy with the given name.
+func ParseUserDeletionPolicy(policy string) (UserDeletionPolicy, error) {
+	switch p := UserDeletionPolicy(policy); p {
+	case UserDeletionPolicyKeep, UserDeletionPolicyRemoveFromGroups, UserDeletionPolicyDisable, UserDeletionPolicyDelete:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown user deletion policy %q", policy)
+}
+
+var userFinalizer = "keycloak-adapter.vshn.net/finalizer"
+
 // UserReconciler reconciles a User object
 type UserReconciler struct {
 	client.Client
@@ -19,13 +49,21 @@ type UserReconciler struct {
 	Scheme   *runtime.Scheme
 
 	Keycloak KeycloakClient
+
+	// DeletionPolicy defines what happens to the Keycloak user if the User is deleted.
+	// Defaults to UserDeletionPolicyKeep.
+	DeletionPolicy UserDeletionPolicy
 }
 
 //+kubebuilder:rbac:groups=appuio.io,resources=users,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=appuio.io,resources=users/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=appuio.io,resources=users/finalizers,verbs=update
 
 // Reconcile reacts on changes of users and mirrors these changes to Keycloak
-func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracer.Start(ctx, "UserReconciler.Reconcile", trace.WithAttributes(attribute.String("user", req.Name)))
+	defer func() { endSpan(span, err) }()
+
 	log := log.FromContext(ctx)
 	log.V(4).WithValues("request", req).Info("Reconciling")
 
@@ -34,16 +72,27 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	if err := r.Get(ctx, req.NamespacedName, &user); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	ctx = withInvolvedObject(ctx, &user)
 
 	if !user.ObjectMeta.DeletionTimestamp.IsZero() {
-		return ctrl.Result{}, nil
+		log.V(4).Info("Handling deletion..")
+		err := r.handleDeletion(ctx, &user)
+		return requeueOnKeycloakError(ctx, err)
+	}
+	if r.deletionPolicy() != UserDeletionPolicyKeep && !controllerutil.ContainsFinalizer(&user, userFinalizer) {
+		controllerutil.AddFinalizer(&user, userFinalizer)
+		if err := r.Update(ctx, &user); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	log.V(4).Info("Reconciling Keycloak group..")
 	kcUser, err := r.Keycloak.PutUser(ctx, buildKeycloakUser(user))
 	if err != nil {
-		r.Recorder.Event(&user, "Warning", "UpdateFailed", "Failed to update Keycloak User")
-		return ctrl.Result{}, err
+		forEachRealmError(err, func(realm string, _ error) {
+			r.Recorder.Event(&user, "Warning", "UpdateFailed", "Failed to update Keycloak User"+inRealm(realm))
+		})
+		return requeueOnKeycloakError(ctx, err)
 	}
 
 	log.V(4).Info("Updating status..")
@@ -51,17 +100,64 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	return ctrl.Result{}, err
 }
 
-func (r *UserReconciler) updateUserStatus(ctx context.Context, user controlv1.User, kcUser keycloak.User) error {
-	user.Status.ID = kcUser.ID
-	user.Status.Username = kcUser.Username
-	user.Status.Email = kcUser.Email
-	user.Status.DisplayName = kcUser.DisplayName()
-	user.Status.DefaultOrganizationRef = kcUser.DefaultOrganizationRef
+func (r *UserReconciler) deletionPolicy() UserDeletionPolicy {
+	if r.DeletionPolicy == "" {
+		return UserDeletionPolicyKeep
+	}
+	return r.DeletionPolicy
+}
+
+// handleDeletion applies the deletion policy to the Keycloak user and removes the finalizer.
+// Users without finalizer were created or last reconciled with the `keep` policy and are left untouched.
+func (r *UserReconciler) handleDeletion(ctx context.Context, user *controlv1.User) error {
+	if !controllerutil.ContainsFinalizer(user, userFinalizer) {
+		return nil
+	}
+
+	var err error
+	var reason, msg string
+	switch r.deletionPolicy() {
+	case UserDeletionPolicyRemoveFromGroups:
+		err = r.Keycloak.RemoveUserFromGroups(ctx, user.Name)
+		reason, msg = "RemovedFromGroups", "Removed Keycloak user from all groups"
+	case UserDeletionPolicyDisable:
+		err = r.Keycloak.DisableUser(ctx, user.Name)
+		reason, msg = "Disabled", "Disabled Keycloak user"
+	case UserDeletionPolicyDelete:
+		err = r.Keycloak.DeleteUser(ctx, user.Name)
+		reason, msg = "Deleted", "Deleted Keycloak user"
+	}
+	if err != nil {
+		forEachRealmError(err, func(realm string, _ error) {
+			r.Recorder.Eventf(user, "Warning", "DeletionFailed", "Failed to apply deletion policy %q to Keycloak user"+inRealm(realm), r.deletionPolicy())
+		})
+		return err
+	}
+	if reason != "" {
+		r.Recorder.Event(user, "Normal", reason, msg)
+	}
+
+	controllerutil.RemoveFinalizer(user, userFinalizer)
+	return r.Update(ctx, user)
+}
+
+func (r *UserReconciler) updateUserStatus(ctx context.Context, user controlv1.User, kcUser idp.User) error {
+	user.Status = userStatusFromKeycloakUser(kcUser)
 	return r.Status().Update(ctx, &user)
 }
 
-func buildKeycloakUser(u controlv1.User) keycloak.User {
-	return keycloak.User{
+func userStatusFromKeycloakUser(kcUser idp.User) controlv1.UserStatus {
+	return controlv1.UserStatus{
+		ID:                     kcUser.ID,
+		Username:               kcUser.Username,
+		Email:                  kcUser.Email,
+		DisplayName:            kcUser.DisplayName(),
+		DefaultOrganizationRef: kcUser.DefaultOrganizationRef,
+	}
+}
+
+func buildKeycloakUser(u controlv1.User) idp.User {
+	return idp.User{
 		Username:               u.Name,
 		DefaultOrganizationRef: u.Spec.Preferences.DefaultOrganizationRef,
 	}
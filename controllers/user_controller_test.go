@@ -14,7 +14,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	. "github.com/vshn/appuio-keycloak-adapter/controllers"
-	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 )
 
 func Test_UserController_Reconcile_Success(t *testing.T) {
@@ -31,7 +31,7 @@ func Test_UserController_Reconcile_Success(t *testing.T) {
 		},
 	}
 
-	keycloakUser := keycloak.User{
+	keycloakUser := idp.User{
 		ID:                     "subject-id",
 		Username:               subject.Name,
 		Email:                  "subject@email.com",
@@ -42,7 +42,7 @@ func Test_UserController_Reconcile_Success(t *testing.T) {
 
 	c, keyMock, _ := prepareTest(t, &subject)
 	keyMock.EXPECT().
-		PutUser(gomock.Any(), keycloak.User{
+		PutUser(gomock.Any(), idp.User{
 			Username:               subject.Name,
 			DefaultOrganizationRef: subject.Spec.Preferences.DefaultOrganizationRef,
 		}).
@@ -81,11 +81,11 @@ func Test_UserController_Reconcile_Failure(t *testing.T) {
 
 	c, keyMock, erMock := prepareTest(t, &subject)
 	keyMock.EXPECT().
-		PutUser(gomock.Any(), keycloak.User{
+		PutUser(gomock.Any(), idp.User{
 			Username:               subject.Name,
 			DefaultOrganizationRef: subject.Spec.Preferences.DefaultOrganizationRef,
 		}).
-		Return(keycloak.User{}, errors.New("unknown errors")).
+		Return(idp.User{}, errors.New("unknown errors")).
 		Times(1)
 	erMock.EXPECT().
 		Event(gomock.Any(), "Warning", "UpdateFailed", gomock.Any()).
@@ -103,3 +103,148 @@ func Test_UserController_Reconcile_Failure(t *testing.T) {
 	})
 	require.Error(t, err)
 }
+
+func Test_UserController_Reconcile_AddFinalizer(t *testing.T) {
+	ctx := context.Background()
+
+	subject := controlv1.User{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "subject-a",
+		},
+	}
+
+	c, keyMock, _ := prepareTest(t, &subject)
+	keyMock.EXPECT().
+		PutUser(gomock.Any(), idp.User{Username: subject.Name}).
+		Return(idp.User{Username: subject.Name}, nil).
+		Times(1)
+
+	_, err := (&UserReconciler{
+		Client:         c,
+		Scheme:         &runtime.Scheme{},
+		Keycloak:       keyMock,
+		DeletionPolicy: UserDeletionPolicyDisable,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: subject.Name,
+		},
+	})
+	require.NoError(t, err)
+
+	reconciledUser := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: subject.Name}, &reconciledUser))
+	require.Equal(t, []string{"keycloak-adapter.vshn.net/finalizer"}, reconciledUser.Finalizers)
+}
+
+func Test_UserController_Reconcile_Delete(t *testing.T) {
+	tcs := map[UserDeletionPolicy]struct {
+		mock   func(*MockKeycloakClient) *gomock.Call
+		reason string
+	}{
+		UserDeletionPolicyKeep: {},
+		UserDeletionPolicyRemoveFromGroups: {
+			mock: func(m *MockKeycloakClient) *gomock.Call {
+				return m.EXPECT().RemoveUserFromGroups(gomock.Any(), "subject-a")
+			},
+			reason: "RemovedFromGroups",
+		},
+		UserDeletionPolicyDisable: {
+			mock: func(m *MockKeycloakClient) *gomock.Call {
+				return m.EXPECT().DisableUser(gomock.Any(), "subject-a")
+			},
+			reason: "Disabled",
+		},
+		UserDeletionPolicyDelete: {
+			mock: func(m *MockKeycloakClient) *gomock.Call {
+				return m.EXPECT().DeleteUser(gomock.Any(), "subject-a")
+			},
+			reason: "Deleted",
+		},
+	}
+
+	for policy, tc := range tcs {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx := context.Background()
+
+			now := metav1.Now()
+			subject := controlv1.User{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "subject-a",
+					DeletionTimestamp: &now,
+					Finalizers:        []string{"keycloak-adapter.vshn.net/finalizer"},
+				},
+			}
+
+			c, keyMock, erMock := prepareTest(t, &subject)
+			if tc.mock != nil {
+				tc.mock(keyMock).Return(nil).Times(1)
+				erMock.EXPECT().
+					Event(gomock.Any(), "Normal", tc.reason, gomock.Any()).
+					Times(1)
+			}
+
+			_, err := (&UserReconciler{
+				Client:         c,
+				Scheme:         &runtime.Scheme{},
+				Keycloak:       keyMock,
+				Recorder:       erMock,
+				DeletionPolicy: policy,
+			}).Reconcile(ctx, ctrl.Request{
+				NamespacedName: types.NamespacedName{
+					Name: subject.Name,
+				},
+			})
+			require.NoError(t, err)
+
+			require.Error(t, c.Get(ctx, types.NamespacedName{Name: subject.Name}, &controlv1.User{}), "finalizer removed")
+		})
+	}
+}
+
+func Test_UserController_Reconcile_Delete_Failure(t *testing.T) {
+	ctx := context.Background()
+
+	now := metav1.Now()
+	subject := controlv1.User{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "subject-a",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{"keycloak-adapter.vshn.net/finalizer"},
+		},
+	}
+
+	c, keyMock, erMock := prepareTest(t, &subject)
+	keyMock.EXPECT().
+		DeleteUser(gomock.Any(), "subject-a").
+		Return(errors.New("unavailable")).
+		Times(1)
+	erMock.EXPECT().
+		Eventf(gomock.Any(), "Warning", "DeletionFailed", gomock.Any(), gomock.Any()).
+		Times(1)
+
+	_, err := (&UserReconciler{
+		Client:         c,
+		Scheme:         &runtime.Scheme{},
+		Keycloak:       keyMock,
+		Recorder:       erMock,
+		DeletionPolicy: UserDeletionPolicyDelete,
+	}).Reconcile(ctx, ctrl.Request{
+		NamespacedName: types.NamespacedName{
+			Name: subject.Name,
+		},
+	})
+	require.Error(t, err)
+
+	remaining := controlv1.User{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: subject.Name}, &remaining))
+	require.Equal(t, []string{"keycloak-adapter.vshn.net/finalizer"}, remaining.Finalizers)
+}
+
+func Test_ParseUserDeletionPolicy(t *testing.T) {
+	p, err := ParseUserDeletionPolicy("remove-from-groups")
+	require.NoError(t, err)
+	require.Equal(t, UserDeletionPolicyRemoveFromGroups, p)
+
+	_, err = ParseUserDeletionPolicy("purge")
+	require.Error(t, err)
+}
@@ -0,0 +1,38 @@
+// Package idp defines the interface between the controllers and the identity provider organizations, teams, and users are synced to.
+//
+// Organizations are top-level groups, teams are groups below the group of their organization.
+// The packages `keycloak` and `scim` implement the interface.
+package idp
+
+import "context"
+
+// Client manages the groups and users of an identity provider.
+type Client interface {
+	// PutGroup creates or updates the given group and sets its members.
+	// The method is idempotent.
+	// Failures to add or remove single members are returned as *MembershipSyncErrors together with the resulting group.
+	PutGroup(ctx context.Context, group Group) (Group, error)
+	// DeleteGroup deletes the given group and all groups below it.
+	// The method is idempotent.
+	DeleteGroup(ctx context.Context, group Group) error
+	// ListGroups returns all groups with their members, parents before their children.
+	ListGroups(ctx context.Context) ([]Group, error)
+
+	// PutUser updates the given user referenced by its username.
+	// Users are never created, a UserNotFoundError is returned if the user does not exist.
+	PutUser(ctx context.Context, user User) (User, error)
+	// ListUsers returns all users.
+	ListUsers(ctx context.Context) ([]User, error)
+	// RemoveUserFromGroups removes the user with the given username from all groups managed by the client.
+	RemoveUserFromGroups(ctx context.Context, username string) error
+	// DisableUser disables the user with the given username.
+	DisableUser(ctx context.Context, username string) error
+	// DeleteUser deletes the user with the given username.
+	DeleteUser(ctx context.Context, username string) error
+}
+
+// Pinger is implemented by clients able to check the connection to the identity provider.
+type Pinger interface {
+	// Ping checks that the identity provider is reachable and accepts the credentials of the client.
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,132 @@
+package idp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MembershipSyncError is a custom error indicating the failure of syncing the membership of a single user.
+type MembershipSyncError struct {
+	Err      error
+	Username string
+	Event    ErrEvent
+}
+
+func (err MembershipSyncError) Error() string {
+	return err.Err.Error()
+}
+
+func (err MembershipSyncError) Unwrap() error {
+	return err.Err
+}
+
+// UserNotFoundError indicates a user could not be found.
+type UserNotFoundError struct {
+	Username string
+}
+
+func (err UserNotFoundError) Is(target error) bool {
+	_, ok := target.(UserNotFoundError)
+	return ok
+}
+
+func (err UserNotFoundError) Error() string {
+	return fmt.Sprintf("user %q not found", err.Username)
+}
+
+// ErrEvent is the reason this error was thrown.
+// It should be short and unique, imagine people writing switch statements to handle them.
+type ErrEvent string
+
+// UserAddError indicates that the client was unable to add the user to the group
+var UserAddError ErrEvent = "AddUserFailed"
+
+// UserRemoveError indicates that the client was unable to remove the user from the group
+var UserRemoveError ErrEvent = "RemoveUserFailed"
+
+// MembershipSyncErrors is a cusom error that can be used to indicate that the client failed to sync one or more memberships.
+type MembershipSyncErrors []MembershipSyncError
+
+func (errs *MembershipSyncErrors) Error() string {
+	msgs := make([]string, len(*errs))
+	for i, err := range *errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ErrorKind classifies errors returned by the identity provider.
+// Errors returned by a client can be matched against the kinds using errors.Is.
+type ErrorKind string
+
+const (
+	// ErrNotFound indicates that the requested resource does not exist.
+	ErrNotFound ErrorKind = "not found"
+	// ErrConflict indicates that the resource conflicts with an existing one.
+	ErrConflict ErrorKind = "conflict"
+	// ErrForbidden indicates that the client is not allowed to access the resource.
+	ErrForbidden ErrorKind = "forbidden"
+	// ErrUnauthorized indicates that the identity provider rejected the credentials or token of the client.
+	ErrUnauthorized ErrorKind = "unauthorized"
+	// ErrRateLimited indicates that the identity provider rejected the request because too many requests were sent.
+	ErrRateLimited ErrorKind = "rate limited"
+	// ErrTransient indicates a network error or a server error, which might go away on retry.
+	ErrTransient ErrorKind = "transient"
+)
+
+func (k ErrorKind) Error() string {
+	return "idp: " + string(k)
+}
+
+// Error is an error returned by the identity provider together with its kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Is reports whether the error is of the given kind.
+func (err *Error) Is(target error) bool {
+	kind, ok := target.(ErrorKind)
+	return ok && kind == err.Kind
+}
+
+// KindOf returns the kind of the given error, or an empty kind if the error is not an Error.
+func KindOf(err error) ErrorKind {
+	var idpErr *Error
+	if errors.As(err, &idpErr) {
+		return idpErr.Kind
+	}
+	return ""
+}
+
+// Retryable returns true if errors of this kind might go away when retrying the request later.
+func (k ErrorKind) Retryable() bool {
+	return k == ErrTransient || k == ErrRateLimited
+}
+
+// Permanent returns true if retrying a request failing with this kind of error without changing it won't succeed.
+// Only missing resources are considered permanent.
+// Authorization errors go away once the credentials or roles of the client are fixed, and conflicts once the conflicting change is settled, so they are worth retrying.
+// Errors which can't be classified are not considered permanent.
+func (k ErrorKind) Permanent() bool {
+	return k == ErrNotFound
+}
+
+// IsRetryable returns true if the error might go away when retrying the request later, see ErrorKind.Retryable.
+func IsRetryable(err error) bool {
+	return KindOf(err).Retryable()
+}
+
+// IsPermanent returns true if retrying the request without changing it won't succeed, see ErrorKind.Permanent.
+func IsPermanent(err error) bool {
+	return KindOf(err).Permanent()
+}
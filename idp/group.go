@@ -0,0 +1,133 @@
+package idp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Group is a representation of a group in the identity provider.
+// Groups are identified by their path, the names of their ancestors followed by their own name.
+type Group struct {
+	id string
+	// parentID is the ID of the parent group, if known.
+	parentID string
+
+	path []string
+
+	Members []User
+
+	displayName string
+
+	attributes map[string][]string
+	// ownedAttributePrefixes are the prefixes of attributes managed by the caller.
+	ownedAttributePrefixes []string
+}
+
+// NewGroup creates a new group.
+func NewGroup(displayName string, path ...string) Group {
+	return Group{path: path, displayName: displayName}
+}
+
+// NewGroupFromPath creates a new group.
+func NewGroupFromPath(displayName string, path string) Group {
+	return NewGroup(displayName, strings.Split(strings.TrimPrefix(path, "/"), "/")...)
+}
+
+// WithMemberNames returns a copy of the group with given members added.
+func (g Group) WithMemberNames(members ...string) Group {
+	m := make([]User, len(members))
+	for i := range members {
+		m[i].Username = members[i]
+	}
+	g.Members = m
+	return g
+}
+
+// ID returns the ID of the group in the identity provider, if known.
+func (g Group) ID() string {
+	return g.id
+}
+
+// WithID returns a copy of the group with the given ID.
+// A known ID allows the client to access the group directly.
+// The group is still looked up by its path if there is no group with the given ID and a matching path.
+func (g Group) WithID(id string) Group {
+	g.id = id
+	return g
+}
+
+// WithParentID returns a copy of the group with the given ID of its parent group.
+func (g Group) WithParentID(id string) Group {
+	g.parentID = id
+	return g
+}
+
+// WithPath returns a copy of the group with the given path.
+func (g Group) WithPath(path ...string) Group {
+	g.path = path
+	return g
+}
+
+// DisplayName returns the human readable name of the group.
+func (g Group) DisplayName() string {
+	return g.displayName
+}
+
+// WithAttributes returns a copy of the group with the given attributes.
+// PutGroup sets these attributes on the group and removes attributes without values.
+// Existing attributes starting with one of the owned prefixes, which are not part of the given attributes, are removed as well.
+// The `displayName` attribute is always managed through the display name of the group.
+func (g Group) WithAttributes(attributes map[string][]string, ownedPrefixes ...string) Group {
+	g.attributes = attributes
+	g.ownedAttributePrefixes = ownedPrefixes
+	return g
+}
+
+// Attributes returns the attributes of the group, excluding the display name.
+func (g Group) Attributes() map[string][]string {
+	return g.attributes
+}
+
+// OwnedAttributePrefixes returns the prefixes of the attributes managed by the caller, see WithAttributes.
+func (g Group) OwnedAttributePrefixes() []string {
+	return g.ownedAttributePrefixes
+}
+
+// Parent returns the parent of the group, or false if the group is a top-level group.
+// The returned group only carries the path and, if known, the ID of the parent.
+func (g Group) Parent() (Group, bool) {
+	if len(g.path) <= 1 {
+		return Group{}, false
+	}
+	return NewGroup("", g.path[:len(g.path)-1]...).WithID(g.parentID), true
+}
+
+// Depth returns the number of ancestors of the group.
+// Top-level groups have a depth of 0.
+func (g Group) Depth() int {
+	if len(g.path) == 0 {
+		return 0
+	}
+	return len(g.path) - 1
+}
+
+// Path returns the path of the group.
+func (g Group) Path() string {
+	if len(g.path) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/%s", strings.Join(g.path, "/"))
+}
+
+// PathMembers returns the split path of the group.
+func (g Group) PathMembers() []string {
+	return g.path
+}
+
+// BaseName returns the name of the group.
+func (g Group) BaseName() string {
+	if len(g.path) == 0 {
+		return ""
+	}
+	return g.path[len(g.path)-1]
+}
@@ -0,0 +1,36 @@
+package idp
+
+// User is a representation of a user in the identity provider.
+// Fields tagged with `kcattr` are mapped to the Keycloak user attribute of the given name, other backends map them on their own.
+// The option `clear` removes the attribute if the field is empty.
+type User struct {
+	ID string
+	// Username is the .metadata.name in kubernetes and the unique user name in the identity provider.
+	Username string
+
+	Email     string
+	FirstName string
+	LastName  string
+
+	// DefaultOrganizationRef is always set by the adapter, so an empty value clears the attribute.
+	DefaultOrganizationRef string `kcattr:"appuio.io/default-organization,clear"`
+	// PreferredLanguage is not changed if empty, as users can set it in Keycloak themselves.
+	PreferredLanguage string `kcattr:"locale"`
+	// BillingContact is nil if unknown.
+	BillingContact *bool `kcattr:"appuio.io/billing-contact"`
+	// NotificationPreferences are the kinds of notifications the user subscribed to.
+	// They are nil if unknown, an empty list clears them.
+	NotificationPreferences []string `kcattr:"appuio.io/notifications"`
+}
+
+// DisplayName returns the disply name of this user
+func (u User) DisplayName() string {
+	if u.FirstName == "" {
+		return u.LastName
+	}
+	if u.LastName == "" {
+		return u.FirstName
+	}
+
+	return u.FirstName + " " + u.LastName
+}
@@ -10,6 +10,7 @@ import (
 
 	gocloak "github.com/Nerzal/gocloak/v13"
 	resty "github.com/go-resty/resty/v2"
+	jwt "github.com/golang-jwt/jwt/v5"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -94,6 +95,20 @@ func (mr *MockGoCloakMockRecorder) DeleteGroup(ctx, accessToken, realm, groupID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGroup", reflect.TypeOf((*MockGoCloak)(nil).DeleteGroup), ctx, accessToken, realm, groupID)
 }
 
+// DeleteUser mocks base method.
+func (m *MockGoCloak) DeleteUser(ctx context.Context, accessToken, realm, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, accessToken, realm, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockGoCloakMockRecorder) DeleteUser(ctx, accessToken, realm, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockGoCloak)(nil).DeleteUser), ctx, accessToken, realm, userID)
+}
+
 // DeleteUserFromGroup mocks base method.
 func (m *MockGoCloak) DeleteUserFromGroup(ctx context.Context, token, realm, userID, groupID string) error {
 	m.ctrl.T.Helper()
@@ -108,6 +123,36 @@ func (mr *MockGoCloakMockRecorder) DeleteUserFromGroup(ctx, token, realm, userID
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserFromGroup", reflect.TypeOf((*MockGoCloak)(nil).DeleteUserFromGroup), ctx, token, realm, userID, groupID)
 }
 
+// GetGroup mocks base method.
+func (m *MockGoCloak) GetGroup(ctx context.Context, token, realm, groupID string) (*gocloak.Group, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGroup", ctx, token, realm, groupID)
+	ret0, _ := ret[0].(*gocloak.Group)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGroup indicates an expected call of GetGroup.
+func (mr *MockGoCloakMockRecorder) GetGroup(ctx, token, realm, groupID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroup", reflect.TypeOf((*MockGoCloak)(nil).GetGroup), ctx, token, realm, groupID)
+}
+
+// GetGroupByPath mocks base method.
+func (m *MockGoCloak) GetGroupByPath(ctx context.Context, token, realm, groupPath string) (*gocloak.Group, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGroupByPath", ctx, token, realm, groupPath)
+	ret0, _ := ret[0].(*gocloak.Group)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGroupByPath indicates an expected call of GetGroupByPath.
+func (mr *MockGoCloakMockRecorder) GetGroupByPath(ctx, token, realm, groupPath interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroupByPath", reflect.TypeOf((*MockGoCloak)(nil).GetGroupByPath), ctx, token, realm, groupPath)
+}
+
 // GetGroupMembers mocks base method.
 func (m *MockGoCloak) GetGroupMembers(ctx context.Context, accessToken, realm, groupID string, params gocloak.GetGroupsParams) ([]*gocloak.User, error) {
 	m.ctrl.T.Helper()
@@ -167,6 +212,21 @@ func (mr *MockGoCloakMockRecorder) GetServerInfo(ctx, accessToken interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetServerInfo", reflect.TypeOf((*MockGoCloak)(nil).GetServerInfo), ctx, accessToken)
 }
 
+// GetUserGroups mocks base method.
+func (m *MockGoCloak) GetUserGroups(ctx context.Context, accessToken, realm, userID string, params gocloak.GetGroupsParams) ([]*gocloak.Group, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserGroups", ctx, accessToken, realm, userID, params)
+	ret0, _ := ret[0].([]*gocloak.Group)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserGroups indicates an expected call of GetUserGroups.
+func (mr *MockGoCloakMockRecorder) GetUserGroups(ctx, accessToken, realm, userID, params interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserGroups", reflect.TypeOf((*MockGoCloak)(nil).GetUserGroups), ctx, accessToken, realm, userID, params)
+}
+
 // GetUsers mocks base method.
 func (m *MockGoCloak) GetUsers(ctx context.Context, accessToken, realm string, params gocloak.GetUsersParams) ([]*gocloak.User, error) {
 	m.ctrl.T.Helper()
@@ -197,6 +257,55 @@ func (mr *MockGoCloakMockRecorder) LoginAdmin(ctx, username, password, realm int
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginAdmin", reflect.TypeOf((*MockGoCloak)(nil).LoginAdmin), ctx, username, password, realm)
 }
 
+// LoginClient mocks base method.
+func (m *MockGoCloak) LoginClient(ctx context.Context, clientID, clientSecret, realm string, scopes ...string) (*gocloak.JWT, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, clientID, clientSecret, realm}
+	for _, a := range scopes {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "LoginClient", varargs...)
+	ret0, _ := ret[0].(*gocloak.JWT)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoginClient indicates an expected call of LoginClient.
+func (mr *MockGoCloakMockRecorder) LoginClient(ctx, clientID, clientSecret, realm interface{}, scopes ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, clientID, clientSecret, realm}, scopes...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginClient", reflect.TypeOf((*MockGoCloak)(nil).LoginClient), varargs...)
+}
+
+// LoginClientSignedJWT mocks base method.
+func (m *MockGoCloak) LoginClientSignedJWT(ctx context.Context, clientID, realm string, key interface{}, signedMethod jwt.SigningMethod, expiresAt *jwt.NumericDate) (*gocloak.JWT, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoginClientSignedJWT", ctx, clientID, realm, key, signedMethod, expiresAt)
+	ret0, _ := ret[0].(*gocloak.JWT)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoginClientSignedJWT indicates an expected call of LoginClientSignedJWT.
+func (mr *MockGoCloakMockRecorder) LoginClientSignedJWT(ctx, clientID, realm, key, signedMethod, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginClientSignedJWT", reflect.TypeOf((*MockGoCloak)(nil).LoginClientSignedJWT), ctx, clientID, realm, key, signedMethod, expiresAt)
+}
+
+// Logout mocks base method.
+func (m *MockGoCloak) Logout(ctx context.Context, clientID, clientSecret, realm, refreshToken string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Logout", ctx, clientID, clientSecret, realm, refreshToken)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Logout indicates an expected call of Logout.
+func (mr *MockGoCloakMockRecorder) Logout(ctx, clientID, clientSecret, realm, refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Logout", reflect.TypeOf((*MockGoCloak)(nil).Logout), ctx, clientID, clientSecret, realm, refreshToken)
+}
+
 // LogoutPublicClient mocks base method.
 func (m *MockGoCloak) LogoutPublicClient(ctx context.Context, clientID, realm, accessToken, refreshToken string) error {
 	m.ctrl.T.Helper()
@@ -211,6 +320,21 @@ func (mr *MockGoCloakMockRecorder) LogoutPublicClient(ctx, clientID, realm, acce
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogoutPublicClient", reflect.TypeOf((*MockGoCloak)(nil).LogoutPublicClient), ctx, clientID, realm, accessToken, refreshToken)
 }
 
+// RefreshToken mocks base method.
+func (m *MockGoCloak) RefreshToken(ctx context.Context, refreshToken, clientID, clientSecret, realm string) (*gocloak.JWT, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefreshToken", ctx, refreshToken, clientID, clientSecret, realm)
+	ret0, _ := ret[0].(*gocloak.JWT)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefreshToken indicates an expected call of RefreshToken.
+func (mr *MockGoCloakMockRecorder) RefreshToken(ctx, refreshToken, clientID, clientSecret, realm interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefreshToken", reflect.TypeOf((*MockGoCloak)(nil).RefreshToken), ctx, refreshToken, clientID, clientSecret, realm)
+}
+
 // UpdateGroup mocks base method.
 func (m *MockGoCloak) UpdateGroup(ctx context.Context, accessToken, realm string, updatedGroup gocloak.Group) error {
 	m.ctrl.T.Helper()
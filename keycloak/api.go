@@ -0,0 +1,243 @@
+package keycloak
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 200 * time.Millisecond
+	maxRetryBackoff     = 5 * time.Second
+)
+
+func (c Client) maxRetries() int {
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	if c.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return c.MaxRetries
+}
+
+func (c Client) retryBackoff() time.Duration {
+	if c.RetryBackoff > 0 {
+		return c.RetryBackoff
+	}
+	return defaultRetryBackoff
+}
+
+// api returns the GoCloak client wrapped to classify errors, retry retryable requests, and record metrics.
+func (c Client) api() instrumentedGoCloak {
+	return instrumentedGoCloak{GoCloak: c.Client, maxRetries: c.maxRetries(), backoff: c.retryBackoff()}
+}
+
+// instrumentedGoCloak sends every request through do.
+// Methods not overridden, such as GetRequestWithBearerAuth, are passed through unchanged.
+type instrumentedGoCloak struct {
+	GoCloak
+
+	maxRetries int
+	backoff    time.Duration
+}
+
+// do sends a single request and retries it with exponential backoff and full jitter as long as it fails with a retryable error.
+// Requests creating resources must use doOnce instead.
+// Every attempt is recorded in the request metrics and traced in its own span under the given method.
+// The returned error is classified, see ErrorKind.
+func (k instrumentedGoCloak) do(ctx context.Context, method string, req func(ctx context.Context) error) error {
+	backoff := k.backoff
+	for attempt := 0; ; attempt++ {
+		err := k.attempt(ctx, method, attempt, req)
+		if err == nil || attempt >= k.maxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff) + 1))):
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// doOnce sends a single request without retrying it.
+// It is used for requests creating resources: if the first attempt was processed by Keycloak but the response got lost, a retry would fail with a conflict.
+// The caller fails instead, and the next reconcile finds the created resource.
+func (k instrumentedGoCloak) doOnce(ctx context.Context, method string, req func(ctx context.Context) error) error {
+	return k.attempt(ctx, method, 0, req)
+}
+
+// attempt sends a single request and returns its classified error.
+func (k instrumentedGoCloak) attempt(ctx context.Context, method string, attempt int, req func(ctx context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "keycloak.GoCloak/"+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("keycloak.attempt", attempt)))
+	start := time.Now()
+	err := req(ctx)
+	observeRequest(method, start, err)
+	span.SetAttributes(attribute.String("keycloak.status_class", statusClass(err)))
+	return endSpan(span, classified(err))
+}
+
+func (k instrumentedGoCloak) LoginAdmin(ctx context.Context, username, password, realm string) (res *gocloak.JWT, err error) {
+	err = k.do(ctx, "LoginAdmin", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.LoginAdmin(ctx, username, password, realm)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) LogoutPublicClient(ctx context.Context, clientID, realm, accessToken, refreshToken string) error {
+	return k.do(ctx, "LogoutPublicClient", func(ctx context.Context) error {
+		return k.GoCloak.LogoutPublicClient(ctx, clientID, realm, accessToken, refreshToken)
+	})
+}
+
+func (k instrumentedGoCloak) RefreshToken(ctx context.Context, refreshToken, clientID, clientSecret, realm string) (res *gocloak.JWT, err error) {
+	err = k.do(ctx, "RefreshToken", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.RefreshToken(ctx, refreshToken, clientID, clientSecret, realm)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) LoginClient(ctx context.Context, clientID, clientSecret, realm string, scopes ...string) (res *gocloak.JWT, err error) {
+	err = k.do(ctx, "LoginClient", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.LoginClient(ctx, clientID, clientSecret, realm, scopes...)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) LoginClientSignedJWT(ctx context.Context, clientID, realm string, key interface{}, signedMethod jwt.SigningMethod, expiresAt *jwt.NumericDate) (res *gocloak.JWT, err error) {
+	err = k.do(ctx, "LoginClientSignedJWT", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.LoginClientSignedJWT(ctx, clientID, realm, key, signedMethod, expiresAt)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) Logout(ctx context.Context, clientID, clientSecret, realm, refreshToken string) error {
+	return k.do(ctx, "Logout", func(ctx context.Context) error {
+		return k.GoCloak.Logout(ctx, clientID, clientSecret, realm, refreshToken)
+	})
+}
+
+func (k instrumentedGoCloak) CreateGroup(ctx context.Context, accessToken, realm string, group gocloak.Group) (res string, err error) {
+	err = k.doOnce(ctx, "CreateGroup", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.CreateGroup(ctx, accessToken, realm, group)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) CreateChildGroup(ctx context.Context, accessToken, realm, groupID string, group gocloak.Group) (res string, err error) {
+	err = k.doOnce(ctx, "CreateChildGroup", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.CreateChildGroup(ctx, accessToken, realm, groupID, group)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) GetGroups(ctx context.Context, accessToken, realm string, params gocloak.GetGroupsParams) (res []*gocloak.Group, err error) {
+	err = k.do(ctx, "GetGroups", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetGroups(ctx, accessToken, realm, params)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) GetGroup(ctx context.Context, token, realm, groupID string) (res *gocloak.Group, err error) {
+	err = k.do(ctx, "GetGroup", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetGroup(ctx, token, realm, groupID)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) GetGroupByPath(ctx context.Context, token, realm, groupPath string) (res *gocloak.Group, err error) {
+	err = k.do(ctx, "GetGroupByPath", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetGroupByPath(ctx, token, realm, groupPath)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) UpdateGroup(ctx context.Context, accessToken, realm string, updatedGroup gocloak.Group) error {
+	return k.do(ctx, "UpdateGroup", func(ctx context.Context) error {
+		return k.GoCloak.UpdateGroup(ctx, accessToken, realm, updatedGroup)
+	})
+}
+
+func (k instrumentedGoCloak) DeleteGroup(ctx context.Context, accessToken, realm, groupID string) error {
+	return k.do(ctx, "DeleteGroup", func(ctx context.Context) error {
+		return k.GoCloak.DeleteGroup(ctx, accessToken, realm, groupID)
+	})
+}
+
+func (k instrumentedGoCloak) GetGroupMembers(ctx context.Context, accessToken, realm, groupID string, params gocloak.GetGroupsParams) (res []*gocloak.User, err error) {
+	err = k.do(ctx, "GetGroupMembers", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetGroupMembers(ctx, accessToken, realm, groupID, params)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) GetUsers(ctx context.Context, accessToken, realm string, params gocloak.GetUsersParams) (res []*gocloak.User, err error) {
+	err = k.do(ctx, "GetUsers", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetUsers(ctx, accessToken, realm, params)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) UpdateUser(ctx context.Context, accessToken, realm string, user gocloak.User) error {
+	return k.do(ctx, "UpdateUser", func(ctx context.Context) error {
+		return k.GoCloak.UpdateUser(ctx, accessToken, realm, user)
+	})
+}
+
+func (k instrumentedGoCloak) DeleteUser(ctx context.Context, accessToken, realm, userID string) error {
+	return k.do(ctx, "DeleteUser", func(ctx context.Context) error {
+		return k.GoCloak.DeleteUser(ctx, accessToken, realm, userID)
+	})
+}
+
+func (k instrumentedGoCloak) GetUserGroups(ctx context.Context, accessToken, realm, userID string, params gocloak.GetGroupsParams) (res []*gocloak.Group, err error) {
+	err = k.do(ctx, "GetUserGroups", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetUserGroups(ctx, accessToken, realm, userID, params)
+		return err
+	})
+	return res, err
+}
+
+func (k instrumentedGoCloak) AddUserToGroup(ctx context.Context, token, realm, userID, groupID string) error {
+	return k.do(ctx, "AddUserToGroup", func(ctx context.Context) error {
+		return k.GoCloak.AddUserToGroup(ctx, token, realm, userID, groupID)
+	})
+}
+
+func (k instrumentedGoCloak) DeleteUserFromGroup(ctx context.Context, token, realm, userID, groupID string) error {
+	return k.do(ctx, "DeleteUserFromGroup", func(ctx context.Context) error {
+		return k.GoCloak.DeleteUserFromGroup(ctx, token, realm, userID, groupID)
+	})
+}
+
+func (k instrumentedGoCloak) GetServerInfo(ctx context.Context, accessToken string) (res *gocloak.ServerInfoRepresentation, err error) {
+	err = k.do(ctx, "GetServerInfo", func(ctx context.Context) (err error) {
+		res, err = k.GoCloak.GetServerInfo(ctx, accessToken)
+		return err
+	})
+	return res, err
+}
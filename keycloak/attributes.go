@@ -0,0 +1,112 @@
+package keycloak
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// attributeTag is the struct tag mapping a field to a Keycloak attribute.
+// Supported field types are `string`, mapped to the first value of the attribute, `[]string`, mapped to all values of the attribute, and `*bool`.
+//
+// Fields with a zero value don't change the attribute, with two exceptions:
+// a `[]string` that is empty but not nil removes the attribute, and so does an empty `string` tagged with the option `clear`, as in `kcattr:"name,clear"`.
+const attributeTag = "kcattr"
+
+// attributeTagClear is the tag option removing the attribute of an empty string field.
+const attributeTagClear = "clear"
+
+type attributeField struct {
+	attribute string
+	index     int
+	clear     bool
+}
+
+var (
+	stringType      = reflect.TypeOf("")
+	stringSliceType = reflect.TypeOf([]string{})
+	boolPtrType     = reflect.TypeOf((*bool)(nil))
+)
+
+// attributeFields returns the fields of the given struct type tagged with `kcattr`.
+// It panics on tagged fields of unsupported types or with unknown options.
+func attributeFields(t reflect.Type) []attributeField {
+	fields := make([]attributeField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup(attributeTag)
+		if !ok {
+			continue
+		}
+		switch f.Type {
+		case stringType, stringSliceType, boolPtrType:
+		default:
+			panic(fmt.Sprintf("field %s.%s: unsupported type %s for tag %q", t.Name(), f.Name, f.Type, attributeTag))
+		}
+		attr, opt, _ := strings.Cut(tag, ",")
+		field := attributeField{attribute: attr, index: i}
+		switch {
+		case opt == "":
+		case opt == attributeTagClear && f.Type == stringType:
+			field.clear = true
+		default:
+			panic(fmt.Sprintf("field %s.%s: unsupported option %q for tag %q", t.Name(), f.Name, opt, attributeTag))
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// readAttributes sets the tagged fields of the struct v points to from the given attributes.
+// Fields without a matching attribute are not touched.
+func readAttributes(fields []attributeField, attrs map[string][]string, v reflect.Value) {
+	for _, f := range fields {
+		values, ok := attrs[f.attribute]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		field := v.Field(f.index)
+		switch field.Type() {
+		case stringType:
+			field.SetString(values[0])
+		case stringSliceType:
+			field.Set(reflect.ValueOf(append([]string(nil), values...)))
+		case boolPtrType:
+			b, err := strconv.ParseBool(values[0])
+			if err != nil {
+				continue
+			}
+			field.Set(reflect.ValueOf(&b))
+		}
+	}
+}
+
+// writeAttributes sets the attributes from the tagged fields of the given struct.
+// Fields with a zero value are not written.
+// It returns the attributes to remove, see attributeTag for when a field clears its attribute.
+func writeAttributes(fields []attributeField, v reflect.Value, attrs map[string][]string) []string {
+	var removed []string
+	for _, f := range fields {
+		field := v.Field(f.index)
+		switch field.Type() {
+		case stringType:
+			if field.String() != "" {
+				attrs[f.attribute] = []string{field.String()}
+			} else if f.clear {
+				removed = append(removed, f.attribute)
+			}
+		case stringSliceType:
+			if field.Len() > 0 {
+				attrs[f.attribute] = append([]string(nil), field.Interface().([]string)...)
+			} else if !field.IsNil() {
+				removed = append(removed, f.attribute)
+			}
+		case boolPtrType:
+			if !field.IsNil() {
+				attrs[f.attribute] = []string{strconv.FormatBool(field.Elem().Bool())}
+			}
+		}
+	}
+	return removed
+}
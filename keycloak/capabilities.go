@@ -0,0 +1,97 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Minimum major versions of Keycloak supporting a feature
+const (
+	minVersionGroupByPath        = 4
+	minVersionExactSearch        = 22
+	minVersionChildGroupEndpoint = 23
+	minVersionOrganizations      = 25
+
+	// newestKnownVersion is the newest major version whose features are known.
+	newestKnownVersion = minVersionOrganizations
+)
+
+// Capabilities describes the features supported by a Keycloak server.
+type Capabilities struct {
+	// Version is the version reported by the server.
+	Version string
+
+	// ChildGroupEndpoint is set if sub groups are not returned when listing groups, but must be fetched from the `/groups/{id}/children` endpoint.
+	ChildGroupEndpoint bool
+	// GroupByPath is set if groups can be looked up by their path.
+	GroupByPath bool
+	// Organizations is set if the server supports the organizations API.
+	Organizations bool
+	// ExactSearch is set if group and user searches support exact matches.
+	ExactSearch bool
+}
+
+// CapabilitiesForVersion returns the capabilities of the given Keycloak version.
+// Versions without a numeric major version, such as `nightly` or an empty version, are assumed to be builds of a recent Keycloak and to support all features of the newest known version.
+func CapabilitiesForVersion(version string) Capabilities {
+	major := parseMajorVersion(version)
+	return Capabilities{
+		Version:            version,
+		ChildGroupEndpoint: major >= minVersionChildGroupEndpoint,
+		GroupByPath:        major >= minVersionGroupByPath,
+		Organizations:      major >= minVersionOrganizations,
+		ExactSearch:        major >= minVersionExactSearch,
+	}
+}
+
+// parseMajorVersion returns the major version of the given version, or newestKnownVersion if it has no numeric major version.
+func parseMajorVersion(version string) int {
+	majorStr, _, _ := strings.Cut(strings.TrimSpace(version), ".")
+	majorStr, _, _ = strings.Cut(majorStr, "-")
+	major, err := strconv.Atoi(majorStr)
+	if err != nil || major < 0 {
+		return newestKnownVersion
+	}
+	return major
+}
+
+// Capabilities returns the capabilities of the Keycloak server.
+// The result is cached for the lifetime of the session.
+func (c Client) Capabilities(ctx context.Context) (Capabilities, error) {
+	ctx, span := c.startSpan(ctx, "Capabilities")
+	var caps Capabilities
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		caps, err = c.capabilities(ctx, token)
+		return err
+	})
+	return caps, endSpan(span, err)
+}
+
+func (c Client) capabilities(ctx context.Context, token *session) (Capabilities, error) {
+	token.capsMu.Lock()
+	defer token.capsMu.Unlock()
+
+	if token.caps != nil {
+		return *token.caps, nil
+	}
+
+	serverInfo, err := c.api().GetServerInfo(ctx, token.AccessToken)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("failed to fetch version information: %w", err)
+	}
+	caps := CapabilitiesForVersion(serverVersion(serverInfo))
+	token.caps = &caps
+	return caps, nil
+}
+
+func serverVersion(info *gocloak.ServerInfoRepresentation) string {
+	if info == nil || info.SystemInfo == nil || info.SystemInfo.Version == nil {
+		return ""
+	}
+	return *info.SystemInfo.Version
+}
@@ -0,0 +1,112 @@
+package keycloak_test
+
+import (
+	"context"
+	"testing"
+
+	gocloak "github.com/Nerzal/gocloak/v13"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestCapabilitiesForVersion(t *testing.T) {
+	tcs := map[string]struct {
+		version  string
+		expected Capabilities
+	}{
+		"legacy": {
+			version:  "3.4.3.Final",
+			expected: Capabilities{},
+		},
+		"keycloak 22": {
+			version: "22.0.5",
+			expected: Capabilities{
+				GroupByPath: true,
+				ExactSearch: true,
+			},
+		},
+		"keycloak 23": {
+			version: "23.0.1",
+			expected: Capabilities{
+				ChildGroupEndpoint: true,
+				GroupByPath:        true,
+				ExactSearch:        true,
+			},
+		},
+		"keycloak 25": {
+			version: "25.0.0",
+			expected: Capabilities{
+				ChildGroupEndpoint: true,
+				GroupByPath:        true,
+				Organizations:      true,
+				ExactSearch:        true,
+			},
+		},
+		"nightly": {
+			version: "nightly",
+			expected: Capabilities{
+				ChildGroupEndpoint: true,
+				GroupByPath:        true,
+				Organizations:      true,
+				ExactSearch:        true,
+			},
+		},
+		"unknown": {
+			version: "",
+			expected: Capabilities{
+				ChildGroupEndpoint: true,
+				GroupByPath:        true,
+				Organizations:      true,
+				ExactSearch:        true,
+			},
+		},
+		"snapshot": {
+			version: "999.0.0-SNAPSHOT",
+			expected: Capabilities{
+				ChildGroupEndpoint: true,
+				GroupByPath:        true,
+				Organizations:      true,
+				ExactSearch:        true,
+			},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			tc.expected.Version = tc.version
+			assert.Equal(t, tc.expected, CapabilitiesForVersion(tc.version))
+		})
+	}
+}
+
+func TestCapabilities_CachedPerSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	mKeycloak.EXPECT().
+		LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+		Return(&gocloak.JWT{AccessToken: "token", ExpiresIn: 300}, nil).
+		Times(1)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mKeycloak.EXPECT().
+		GetGroups(gomock.Any(), "token", "target-realm", gomock.Any()).
+		Return([]*gocloak.Group{}, nil).
+		Times(2)
+
+	_, err := c.ListGroups(context.Background())
+	require.NoError(t, err)
+	_, err = c.ListGroups(context.Background())
+	require.NoError(t, err)
+
+	caps, err := c.Capabilities(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "22.0.0", caps.Version)
+	assert.True(t, caps.GroupByPath)
+	assert.False(t, caps.ChildGroupEndpoint)
+}
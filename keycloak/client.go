@@ -2,115 +2,54 @@ package keycloak
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/multierr"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 )
 
 // Group is a representation of a group in keycloak
-type Group struct {
-	id string
-
-	path []string
-
-	Members []User
-
-	displayName string
-}
+type Group = idp.Group
 
 // NewGroup creates a new group.
 func NewGroup(displayName string, path ...string) Group {
-	return Group{path: path, displayName: displayName}
+	return idp.NewGroup(displayName, path...)
 }
 
 // NewGroupFromPath creates a new group.
 func NewGroupFromPath(displayName string, path string) Group {
-	return NewGroup(displayName, strings.Split(strings.TrimPrefix(path, "/"), "/")...)
-}
-
-// WithMemberNames returns a copy of the group with given members added.
-func (g Group) WithMemberNames(members ...string) Group {
-	m := make([]User, len(members))
-	for i := range members {
-		m[i].Username = members[i]
-	}
-	g.Members = m
-	return g
-}
-
-// Path returns the path of the group.
-func (g Group) Path() string {
-	if len(g.path) == 0 {
-		return ""
-	}
-	return fmt.Sprintf("/%s", strings.Join(g.path, "/"))
-}
-
-// PathMembers returns the split path of the group.
-func (g Group) PathMembers() []string {
-	return g.path
-}
-
-// BaseName returns the name of the group.
-func (g Group) BaseName() string {
-	if len(g.path) == 0 {
-		return ""
-	}
-	return g.path[len(g.path)-1]
+	return idp.NewGroupFromPath(displayName, path)
 }
 
 // MembershipSyncError is a custom error indicating the failure of syncing the membership of a single user.
-type MembershipSyncError struct {
-	Err      error
-	Username string
-	Event    ErrEvent
-}
-
-func (err MembershipSyncError) Error() string {
-	return err.Err.Error()
-}
-
-func (err MembershipSyncError) Unwrap() error {
-	return err.Err
-}
+type MembershipSyncError = idp.MembershipSyncError
 
 // UserNotFoundError indicates a user could not be found.
-type UserNotFoundError struct {
-	Username string
-}
-
-func (err UserNotFoundError) Is(target error) bool {
-	_, ok := target.(UserNotFoundError)
-	return ok
-}
-
-func (err UserNotFoundError) Error() string {
-	return fmt.Sprintf("user %q not found", err.Username)
-}
+type UserNotFoundError = idp.UserNotFoundError
 
 // ErrEvent is the reason this error was thrown.
-// It should be short and unique, imagine people writing switch statements to handle them.
-type ErrEvent string
-
-// UserAddError indicates that the client was unable to add the user to the group
-var UserAddError ErrEvent = "AddUserFailed"
+type ErrEvent = idp.ErrEvent
 
-// UserRemoveError indicates that the client was unable to remove the user from the group
-var UserRemoveError ErrEvent = "RemoveUserFailed"
+var (
+	// UserAddError indicates that the client was unable to add the user to the group
+	UserAddError = idp.UserAddError
+	// UserRemoveError indicates that the client was unable to remove the user from the group
+	UserRemoveError = idp.UserRemoveError
+)
 
 // MembershipSyncErrors is a cusom error that can be used to indicate that the client failed to sync one or more memberships.
-type MembershipSyncErrors []MembershipSyncError
-
-func (errs *MembershipSyncErrors) Error() string {
-	errMsg := ""
-	for _, err := range *errs {
-		errMsg = fmt.Sprintf("%s\n", err.Error())
-	}
-	return errMsg
-}
+type MembershipSyncErrors = idp.MembershipSyncErrors
 
 //go:generate go run github.com/golang/mock/mockgen -source=$GOFILE -destination=./ZZ_mock_gocloak_test.go -package keycloak_test
 
@@ -119,16 +58,24 @@ func (errs *MembershipSyncErrors) Error() string {
 type GoCloak interface {
 	LoginAdmin(ctx context.Context, username, password, realm string) (*gocloak.JWT, error)
 	LogoutPublicClient(ctx context.Context, clientID, realm, accessToken, refreshToken string) error
+	RefreshToken(ctx context.Context, refreshToken, clientID, clientSecret, realm string) (*gocloak.JWT, error)
+	LoginClient(ctx context.Context, clientID, clientSecret, realm string, scopes ...string) (*gocloak.JWT, error)
+	LoginClientSignedJWT(ctx context.Context, clientID, realm string, key interface{}, signedMethod jwt.SigningMethod, expiresAt *jwt.NumericDate) (*gocloak.JWT, error)
+	Logout(ctx context.Context, clientID, clientSecret, realm, refreshToken string) error
 
 	CreateGroup(ctx context.Context, accessToken, realm string, group gocloak.Group) (string, error)
 	CreateChildGroup(ctx context.Context, accessToken, realm, groupID string, group gocloak.Group) (string, error)
 	GetGroups(ctx context.Context, accessToken, realm string, params gocloak.GetGroupsParams) ([]*gocloak.Group, error)
+	GetGroup(ctx context.Context, token, realm, groupID string) (*gocloak.Group, error)
+	GetGroupByPath(ctx context.Context, token, realm, groupPath string) (*gocloak.Group, error)
 	UpdateGroup(ctx context.Context, accessToken, realm string, updatedGroup gocloak.Group) error
 	DeleteGroup(ctx context.Context, accessToken, realm, groupID string) error
 
 	GetGroupMembers(ctx context.Context, accessToken, realm, groupID string, params gocloak.GetGroupsParams) ([]*gocloak.User, error)
 	GetUsers(ctx context.Context, accessToken, realm string, params gocloak.GetUsersParams) ([]*gocloak.User, error)
 	UpdateUser(ctx context.Context, accessToken, realm string, user gocloak.User) error
+	DeleteUser(ctx context.Context, accessToken, realm, userID string) error
+	GetUserGroups(ctx context.Context, accessToken, realm, userID string, params gocloak.GetGroupsParams) ([]*gocloak.Group, error)
 	AddUserToGroup(ctx context.Context, token, realm, userID, groupID string) error
 	DeleteUserFromGroup(ctx context.Context, token, realm, userID, groupID string) error
 	GetServerInfo(ctx context.Context, accessToken string) (*gocloak.ServerInfoRepresentation, error)
@@ -147,13 +94,46 @@ type Client struct {
 	Username   string
 	Password   string
 
+	// ClientID, if set, makes the client authenticate as the service account of the given Keycloak client using the client credentials grant.
+	// Username and Password are ignored in that case.
+	ClientID     string
+	ClientSecret string
+	// ClientKey, if set, is used to sign a JWT client assertion instead of authenticating with ClientSecret.
+	// See ParseClientKey.
+	ClientKey              interface{}
+	ClientKeySigningMethod jwt.SigningMethod
+
 	// RootGroup, if set, transparently manages groups under given root group.
 	// Searches and puts groups under the given root group and strips the root group from the return values.
 	// The root group must exist in Keycloak.
 	RootGroup string
-}
 
-// NewClient creates a new Client
+	// PageSize is the number of groups or members requested at once when listing them.
+	// Defaults to 100.
+	PageSize int
+	// Concurrency is the maximum number of requests sent in parallel when listing child groups and members.
+	// Defaults to 1.
+	Concurrency int
+	// MaxDepth limits the number of levels of nested groups returned by ListGroups.
+	// A MaxDepth of 1 only returns top-level groups, or the groups directly below the RootGroup.
+	// Defaults to no limit.
+	MaxDepth int
+
+	// MaxRetries is the number of times a single request is retried if it fails with a retryable error, see IsRetryable.
+	// Defaults to 3, a negative value disables retries.
+	MaxRetries int
+	// RetryBackoff is the initial upper bound of the random delay before retrying a request.
+	// It doubles with every retry, up to 5 seconds.
+	// Defaults to 200ms.
+	RetryBackoff time.Duration
+
+	// tokens caches the admin token between calls.
+	// If nil, every call logs in and out again.
+	tokens *tokenSource
+}
+
+// NewClient creates a new Client.
+// The returned client, and all copies of it, share a cached admin token which is refreshed as needed.
 func NewClient(host, realm, username, password string) Client {
 	return Client{
 		Client:   gocloak.NewClient(host),
@@ -161,156 +141,125 @@ func NewClient(host, realm, username, password string) Client {
 		Host:     strings.TrimRight(host, "/"),
 		Username: username,
 		Password: password,
+		tokens:   newTokenSource(),
 	}
 }
 
 // PutGroup creates the provided Keycloak group if it does not exist and adjusts the group members accordingly.
 // The method is idempotent.
 func (c Client) PutGroup(ctx context.Context, group Group) (Group, error) {
-	res := NewGroup(group.displayName, group.path...)
-	group = c.prependRoot(group)
-
-	token, err := c.login(ctx)
-	if err != nil {
-		return res, fmt.Errorf("failed binding to keycloak: %w", err)
-	}
-	defer c.logout(ctx, token)
+	ctx, span := c.startSpan(ctx, "PutGroup", groupPathAttr(group))
+	res := NewGroup(group.DisplayName(), group.PathMembers()...)
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.putGroup(ctx, token, group)
+		return err
+	})
+	return res, endSpan(span, err)
+}
 
-	found, foundMemb, err := c.getGroupAndMembers(ctx, token, group)
+func (c Client) putGroup(ctx context.Context, token *session, group Group) (Group, error) {
+	plan, err := c.planGroup(ctx, token, c.newUserResolver(token), group)
 	if err != nil {
-		return res, fmt.Errorf("failed finding group: %w", err)
-	}
-	if found == nil {
-		created, err := c.createGroup(ctx, token, group)
-		if err != nil {
-			return res, err
-		}
-		found = &created
-	} else {
-		if getDisplayNameOfGroup(found) != group.displayName {
-			found.Attributes = setDisplayName(found.Attributes, group.displayName)
-			err := c.updateGroup(ctx, token, *found)
-			if err != nil {
-				return res, err
-			}
-		}
+		return NewGroup(group.DisplayName(), group.PathMembers()...), err
 	}
-
-	membErr := MembershipSyncErrors{}
-
-	for _, fm := range foundMemb {
-		if !containsUsername(group.Members, *fm.Username) {
-			// user is not in group remove it
-			err := c.Client.DeleteUserFromGroup(ctx, token.AccessToken, c.Realm, *fm.ID, *found.ID)
-			if err != nil {
-				membErr = append(membErr, MembershipSyncError{
-					Err:      err,
-					Username: *fm.Username,
-					Event:    UserRemoveError,
-				})
-				continue
-			}
-		} else {
-			res.Members = append(res.Members, UserFromKeycloakUser(*fm))
-		}
-	}
-	newMemb := diffByUsername(group.Members, res.Members)
-
-	addedMemb, addMembErr := c.addUsersToGroup(ctx, token, *found.ID, newMemb)
-	res.Members = append(res.Members, addedMemb...)
-	if addMembErr != nil {
-		membErr = append(membErr, *addMembErr...)
-	}
-
-	if len(membErr) > 0 {
-		return res, &membErr
-	}
-	return res, nil
+	return c.applyPlan(ctx, token, plan)
 }
 
-func (c Client) createGroup(ctx context.Context, token *gocloak.JWT, group Group) (gocloak.Group, error) {
+// createGroup creates the given group below the parent group with the given ID, or as a top-level group if the parent ID is empty.
+func (c Client) createGroup(ctx context.Context, token *session, group Group, parentID string) (gocloak.Group, error) {
+	attributes, _ := setAttributes(nil, group.Attributes(), nil)
 	toCreate := gocloak.Group{
 		Name:       gocloak.StringP(group.BaseName()),
 		Path:       gocloak.StringP(group.Path()),
-		Attributes: setDisplayName(nil, group.displayName),
+		Attributes: setDisplayName(attributes, group.DisplayName()),
 	}
 
-	if len(group.PathMembers()) == 1 {
-		id, err := c.Client.CreateGroup(ctx, token.AccessToken, c.Realm, toCreate)
+	if parentID == "" {
+		id, err := c.api().CreateGroup(ctx, token.AccessToken, c.Realm, toCreate)
 		toCreate.ID = &id
 		return toCreate, err
 	}
 
+	id, err := c.api().CreateChildGroup(ctx, token.AccessToken, c.Realm, parentID, toCreate)
+	toCreate.ID = &id
+	return toCreate, err
+}
+
+// getParentID returns the Keycloak ID of the parent of the given group, or an empty string for top-level groups.
+// An error is returned if the parent group does not exist.
+func (c Client) getParentID(ctx context.Context, token *session, group Group) (string, error) {
 	p := group.PathMembers()
-	parent, err := c.getGroup(ctx, token, NewGroup(group.displayName, p[0:len(p)-1]...))
+	if len(p) <= 1 {
+		return "", nil
+	}
+	parent, err := c.getGroup(ctx, token, NewGroup(group.DisplayName(), p[0:len(p)-1]...))
 	if err != nil {
-		return toCreate, fmt.Errorf("error finding parent group for %v: %w", group, err)
+		return "", fmt.Errorf("error finding parent group for %v: %w", group, err)
 	}
 	if parent == nil {
-		return toCreate, fmt.Errorf("could not find parent group for %v", group)
+		return "", fmt.Errorf("could not find parent group for %v", group)
 	}
-
-	id, err := c.Client.CreateChildGroup(ctx, token.AccessToken, c.Realm, *parent.ID, toCreate)
-	toCreate.ID = &id
-	return toCreate, err
+	return *parent.ID, nil
 }
 
-func (c Client) updateGroup(ctx context.Context, token *gocloak.JWT, group gocloak.Group) error {
-	err := c.Client.UpdateGroup(ctx, token.AccessToken, c.Realm, group)
+func (c Client) updateGroup(ctx context.Context, token *session, group gocloak.Group) error {
+	err := c.api().UpdateGroup(ctx, token.AccessToken, c.Realm, group)
 	return err
 }
 
-// DeleteGroup deletes the Keycloak group by name.
+// DeleteGroup deletes the Keycloak group by ID or path.
 // The method is idempotent and will not do anything if the group does not exits.
-func (c Client) DeleteGroup(ctx context.Context, path ...string) error {
-	token, err := c.login(ctx)
-	if err != nil {
-		return fmt.Errorf("failed binding to keycloak: %w", err)
-	}
-	defer c.logout(ctx, token)
+func (c Client) DeleteGroup(ctx context.Context, group Group) error {
+	ctx, span := c.startSpan(ctx, "DeleteGroup", groupPathAttr(group))
+	err := c.withToken(ctx, func(token *session) error {
+		return c.deleteGroup(ctx, token, group)
+	})
+	return endSpan(span, err)
+}
 
-	found, err := c.getGroup(ctx, token, c.prependRoot(NewGroup("", path...)))
+func (c Client) deleteGroup(ctx context.Context, token *session, group Group) error {
+	found, err := c.getGroup(ctx, token, c.prependRoot(group))
 	if err != nil {
 		return fmt.Errorf("failed finding group: %w", err)
 	}
 	if found == nil {
 		return nil
 	}
-	return c.Client.DeleteGroup(ctx, token.AccessToken, c.Realm, *found.ID)
+	return c.api().DeleteGroup(ctx, token.AccessToken, c.Realm, *found.ID)
 }
 
-// ListGroups returns all top-level Keycloak groups in the realm and their direct children.
-// More deeply nested children are not returned.
+// ListGroups returns all Keycloak groups in the realm, walking the group tree up to the configured MaxDepth.
+// Parents are always returned before their children.
 // This is potentially very expensive, as it needs to iterate over all groups to get their members and sub groups.
 func (c Client) ListGroups(ctx context.Context) ([]Group, error) {
-	token, err := c.login(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed binding to keycloak: %w", err)
-	}
-	defer c.logout(ctx, token)
+	ctx, span := c.startSpan(ctx, "ListGroups")
+	var res []Group
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.listGroups(ctx, token)
+		return err
+	})
+	span.SetAttributes(attribute.Int("keycloak.groups", len(res)))
+	return res, endSpan(span, err)
+}
 
-	groups, err := c.Client.GetGroups(ctx, token.AccessToken, c.Realm, defaultParams)
+func (c Client) listGroups(ctx context.Context, token *session) ([]Group, error) {
+	groups, err := fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.Group, error) {
+		return c.api().GetGroups(ctx, token.AccessToken, c.Realm, pageParams(first, max))
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	serverInfo, err := c.Client.GetServerInfo(ctx, token.AccessToken)
+	caps, err := c.capabilities(ctx, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch version information: %w", err)
-	}
-
-	majorVersion, err := strconv.Atoi(strings.Split(*serverInfo.SystemInfo.Version, ".")[0])
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse version information: %w", err)
+		return nil, err
 	}
 
-	if majorVersion >= 23 {
-		for _, g := range groups {
-			subgroups, err := c.getChildGroups(ctx, token, *g.ID)
-			if err != nil {
-				return nil, fmt.Errorf("failed to fetch sub groups: %w", err)
-			}
-			g.SubGroups = &subgroups
+	if caps.ChildGroupEndpoint {
+		if err := c.fetchGroupTree(ctx, token, groups); err != nil {
+			return nil, err
 		}
 	}
 
@@ -319,20 +268,62 @@ func (c Client) ListGroups(ctx context.Context) ([]Group, error) {
 		return nil, fmt.Errorf("could not find root group %q", c.RootGroup)
 	}
 
-	res := flatGroups(rootGroups)
+	res := flatGroups(rootGroups, c.MaxDepth)
 
-	for i, g := range res {
-		memb, err := c.Client.GetGroupMembers(ctx, token.AccessToken, c.Realm, g.id, defaultParams)
+	err = c.forEach(ctx, len(res), func(ctx context.Context, i int) error {
+		memb, err := c.getGroupMembers(ctx, token, res[i].ID())
 		if err != nil {
-			return res, fmt.Errorf("failed finding groupmembers for group %s: %w", g.BaseName(), err)
+			return fmt.Errorf("failed finding groupmembers for group %s: %w", res[i].BaseName(), err)
 		}
 		res[i].Members = make([]User, len(memb))
 		for j, m := range memb {
 			res[i].Members[j] = UserFromKeycloakUser(*m)
 		}
+		return nil
+	})
+
+	return res, err
+}
+
+// fetchGroupTree fetches the sub groups of the given top-level groups level by level, up to the configured MaxDepth.
+// If a RootGroup is configured, only the tree below the root group is fetched.
+func (c Client) fetchGroupTree(ctx context.Context, token *session, groups []*gocloak.Group) error {
+	level := groups
+	maxDepth := c.MaxDepth
+	if c.RootGroup != "" {
+		level = nil
+		for _, g := range groups {
+			if *g.Name == c.RootGroup {
+				level = append(level, g)
+			}
+		}
+		if maxDepth > 0 {
+			maxDepth++
+		}
 	}
 
-	return res, nil
+	for depth := 1; len(level) > 0 && (maxDepth <= 0 || depth < maxDepth); depth++ {
+		err := c.forEach(ctx, len(level), func(ctx context.Context, i int) error {
+			subgroups, err := c.getChildGroups(ctx, token, *level[i].ID)
+			if err != nil {
+				return fmt.Errorf("failed to fetch sub groups: %w", err)
+			}
+			level[i].SubGroups = &subgroups
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		next := make([]*gocloak.Group, 0, len(level))
+		for _, g := range level {
+			for i := range *g.SubGroups {
+				next = append(next, &(*g.SubGroups)[i])
+			}
+		}
+		level = next
+	}
+	return nil
 }
 
 func (c Client) loginRealm() string {
@@ -343,22 +334,147 @@ func (c Client) loginRealm() string {
 }
 
 func (c Client) login(ctx context.Context) (*gocloak.JWT, error) {
-	return c.Client.LoginAdmin(ctx, c.Username, c.Password, c.loginRealm())
+	switch {
+	case c.ClientID != "" && c.ClientKey != nil:
+		expiresAt := jwt.NewNumericDate(time.Now().Add(clientAssertionLifetime))
+		return c.api().LoginClientSignedJWT(ctx, c.ClientID, c.loginRealm(), c.ClientKey, c.ClientKeySigningMethod, expiresAt)
+	case c.ClientID != "":
+		return c.api().LoginClient(ctx, c.ClientID, c.ClientSecret, c.loginRealm())
+	}
+	return c.api().LoginAdmin(ctx, c.Username, c.Password, c.loginRealm())
 }
 
 func (c Client) logout(ctx context.Context, token *gocloak.JWT) error {
+	if c.ClientID != "" {
+		// Client credential grants usually don't open a session.
+		// If they do, we can only end it if we have a secret to authenticate the client.
+		if token.RefreshToken == "" || c.ClientKey != nil {
+			return nil
+		}
+		return c.api().Logout(ctx, c.ClientID, c.ClientSecret, c.loginRealm(), token.RefreshToken)
+	}
 	// `admin-cli` is the client used when authenticating to the admin API
-	return c.Client.LogoutPublicClient(ctx, "admin-cli", c.loginRealm(), token.AccessToken, token.RefreshToken)
+	return c.api().LogoutPublicClient(ctx, "admin-cli", c.loginRealm(), token.AccessToken, token.RefreshToken)
+}
+
+func (c Client) refresh(ctx context.Context, token *gocloak.JWT) (*gocloak.JWT, error) {
+	if c.ClientID != "" {
+		if c.ClientKey != nil {
+			// Refreshing would need a new client assertion anyway
+			return c.login(ctx)
+		}
+		return c.api().RefreshToken(ctx, token.RefreshToken, c.ClientID, c.ClientSecret, c.loginRealm())
+	}
+	return c.api().RefreshToken(ctx, token.RefreshToken, "admin-cli", "", c.loginRealm())
+}
+
+// withToken calls fn with a token for the Keycloak admin API.
+// If the client caches tokens, a token rejected by Keycloak is discarded and fn is retried once with a new token.
+// Otherwise a new session is created for the call and closed afterwards.
+func (c Client) withToken(ctx context.Context, fn func(token *session) error) error {
+	if c.tokens == nil {
+		token, err := c.login(ctx)
+		if err != nil {
+			return fmt.Errorf("failed binding to keycloak: %w", err)
+		}
+		defer c.logout(ctx, token)
+		return fn(&session{JWT: token})
+	}
+
+	token, err := c.tokens.get(ctx, c.login, c.refresh, c.logout)
+	if err != nil {
+		return fmt.Errorf("failed binding to keycloak: %w", err)
+	}
+	err = fn(token)
+	if !isUnauthorized(err) {
+		return err
+	}
+
+	c.tokens.invalidate(token)
+	token, err = c.tokens.get(ctx, c.login, c.refresh, c.logout)
+	if err != nil {
+		return fmt.Errorf("failed binding to keycloak: %w", err)
+	}
+	return fn(token)
 }
 
-func (c Client) getGroup(ctx context.Context, token *gocloak.JWT, toSearch Group) (*gocloak.Group, error) {
+// Close ends the cached Keycloak session, if there is one.
+func (c Client) Close(ctx context.Context) error {
+	ctx, span := c.startSpan(ctx, "Close")
+	return endSpan(span, c.close(ctx))
+}
+
+// Ping checks that the Keycloak server is reachable and the client can log in, by fetching the server info.
+// A cached session is reused, and replaced if Keycloak rejects it.
+func (c Client) Ping(ctx context.Context) error {
+	ctx, span := c.startSpan(ctx, "Ping")
+	err := c.withToken(ctx, func(token *session) error {
+		_, err := c.api().GetServerInfo(ctx, token.AccessToken)
+		return err
+	})
+	return endSpan(span, err)
+}
+
+func (c Client) close(ctx context.Context) error {
+	if c.tokens == nil {
+		return nil
+	}
+	token := c.tokens.take()
+	if token == nil {
+		return nil
+	}
+	return c.logout(ctx, token.JWT)
+}
+
+// getGroup returns the group with the same path as the given group, or nil if there is no such group.
+// If the ID of the given group is known, the group is fetched directly.
+func (c Client) getGroup(ctx context.Context, token *session, toSearch Group) (*gocloak.Group, error) {
 	if len(toSearch.PathMembers()) == 0 {
 		return nil, nil
 	}
-	// This may return more than one 1 result
-	groups, err := c.Client.GetGroups(ctx, token.AccessToken, c.Realm, gocloak.GetGroupsParams{
-		Max:    defaultParams.Max,
-		Search: gocloak.StringP(toSearch.BaseName()),
+
+	if toSearch.ID() != "" {
+		group, err := c.api().GetGroup(ctx, token.AccessToken, c.Realm, toSearch.ID())
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		if err == nil && group.Path != nil && *group.Path == toSearch.Path() {
+			return group, nil
+		}
+		// The ID is stale, the group was either deleted or moved.
+	}
+
+	caps, err := c.capabilities(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !caps.GroupByPath {
+		return c.searchGroup(ctx, token, toSearch)
+	}
+
+	group, err := c.getGroupByPath(ctx, token, toSearch)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return group, err
+}
+
+func (c Client) getGroupByPath(ctx context.Context, token *session, toFind Group) (*gocloak.Group, error) {
+	segments := make([]string, len(toFind.PathMembers()))
+	for i, s := range toFind.PathMembers() {
+		segments[i] = url.PathEscape(s)
+	}
+	return c.api().GetGroupByPath(ctx, token.AccessToken, c.Realm, strings.Join(segments, "/"))
+}
+
+func (c Client) searchGroup(ctx context.Context, token *session, toSearch Group) (*gocloak.Group, error) {
+	// The search matches substrings of group names, so it may return more than one page of results.
+	groups, err := fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.Group, error) {
+		return c.api().GetGroups(ctx, token.AccessToken, c.Realm, gocloak.GetGroupsParams{
+			First:  gocloak.IntP(first),
+			Max:    gocloak.IntP(max),
+			Search: gocloak.StringP(toSearch.BaseName()),
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -386,42 +502,31 @@ func (c Client) getGroup(ctx context.Context, token *gocloak.JWT, toSearch Group
 	return find(g), nil
 }
 
-func (c Client) getChildGroups(ctx context.Context, token *gocloak.JWT, groupID string) ([]gocloak.Group, error) {
-	var result []*gocloak.Group
-	childGroupsUrl := strings.Join([]string{c.Host, "admin", "realms", c.Realm, "groups", groupID, "children"}, "/")
-	resp, err := c.Client.GetRequestWithBearerAuth(ctx, token.AccessToken).
-		SetResult(&result).
-		Get(childGroupsUrl)
-
-	if err != nil {
-		return nil, &gocloak.APIError{
-			Code:    0,
-			Message: "could not retrieve child groups",
-			Type:    gocloak.ParseAPIErrType(err),
-		}
-	}
-
-	if resp == nil {
-		return nil, &gocloak.APIError{
-			Message: "empty response",
-			Type:    gocloak.ParseAPIErrType(err),
-		}
-	}
-
-	if resp.IsError() {
-		var msg string
+func (c Client) getChildGroups(ctx context.Context, token *session, groupID string) ([]gocloak.Group, error) {
+	return fetchPaged(c.pageSize(), func(first, max int) ([]gocloak.Group, error) {
+		return c.getChildGroupsPage(ctx, token, groupID, first, max)
+	})
+}
 
-		if e, ok := resp.Error().(*gocloak.HTTPErrorResponse); ok && e.NotEmpty() {
-			msg = fmt.Sprintf("%s: %s", resp.Status(), e)
-		} else {
-			msg = resp.Status()
-		}
+func (c Client) getChildGroupsPage(ctx context.Context, token *session, groupID string, first, max int) ([]gocloak.Group, error) {
+	var groups []gocloak.Group
+	err := c.api().do(ctx, "GetChildGroups", func(ctx context.Context) error {
+		var err error
+		groups, err = c.requestChildGroupsPage(ctx, token, groupID, first, max)
+		return err
+	})
+	return groups, err
+}
 
-		return nil, &gocloak.APIError{
-			Code:    resp.StatusCode(),
-			Message: msg,
-			Type:    gocloak.ParseAPIErrType(err),
-		}
+func (c Client) requestChildGroupsPage(ctx context.Context, token *session, groupID string, first, max int) ([]gocloak.Group, error) {
+	var result []*gocloak.Group
+	_, err := c.adminRequest(ctx, token, http.MethodGet, []string{"groups", groupID, "children"}, map[string]string{
+		"first":               strconv.Itoa(first),
+		"max":                 strconv.Itoa(max),
+		"briefRepresentation": "false",
+	}, nil, &result)
+	if err != nil {
+		return nil, err
 	}
 
 	groupList := make([]gocloak.Group, len(result))
@@ -434,13 +539,19 @@ func (c Client) getChildGroups(ctx context.Context, token *gocloak.JWT, groupID
 	return groupList, nil
 }
 
-func (c Client) getGroupAndMembers(ctx context.Context, token *gocloak.JWT, toFind Group) (*gocloak.Group, []*gocloak.User, error) {
+func (c Client) getGroupMembers(ctx context.Context, token *session, groupID string) ([]*gocloak.User, error) {
+	return fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.User, error) {
+		return c.api().GetGroupMembers(ctx, token.AccessToken, c.Realm, groupID, pageParams(first, max))
+	})
+}
+
+func (c Client) getGroupAndMembers(ctx context.Context, token *session, toFind Group) (*gocloak.Group, []*gocloak.User, error) {
 	group, err := c.getGroup(ctx, token, toFind)
 	if err != nil || group == nil {
 		return group, nil, err
 	}
 
-	foundMemb, err := c.Client.GetGroupMembers(ctx, token.AccessToken, c.Realm, *group.ID, defaultParams)
+	foundMemb, err := c.getGroupMembers(ctx, token, *group.ID)
 	if err != nil {
 		return group, foundMemb, fmt.Errorf("failed finding groupmembers for group %v: %w", toFind, err)
 	}
@@ -448,22 +559,34 @@ func (c Client) getGroupAndMembers(ctx context.Context, token *gocloak.JWT, toFi
 
 }
 
-func (c Client) addUsersToGroup(ctx context.Context, token *gocloak.JWT, groupID string, users []User) ([]User, *MembershipSyncErrors) {
+// addUsersToGroup adds the given users, resolved to the given Keycloak IDs by username, to the group.
+// Users which could not be added are reported in a single MembershipSyncErrors.
+func (c Client) addUsersToGroup(ctx context.Context, token *session, groupID string, users []User, ids map[string]string) ([]User, *MembershipSyncErrors) {
+	return c.addUsers(ctx, users, ids, func(ctx context.Context, userID string) error {
+		return c.api().AddUserToGroup(ctx, token.AccessToken, c.Realm, userID, groupID)
+	})
+}
+
+// addUsers calls add in parallel for the Keycloak IDs of the given users and returns the added users.
+// Users which could not be added are reported in a single MembershipSyncErrors.
+func (c Client) addUsers(ctx context.Context, users []User, ids map[string]string, add func(ctx context.Context, userID string) error) ([]User, *MembershipSyncErrors) {
+	addErrs := make([]error, len(users))
+	added := make([]bool, len(users))
+	err := c.forEach(ctx, len(users), func(ctx context.Context, i int) error {
+		addErrs[i] = add(ctx, ids[users[i].Username])
+		added[i] = addErrs[i] == nil
+		return nil
+	})
+
 	res := make([]User, 0, len(users))
 	errs := MembershipSyncErrors{}
-	for _, user := range users {
-		usr, err := c.getUserByName(ctx, token, user.Username)
-		if err != nil {
-			errs = append(errs, MembershipSyncError{
-				Err:      err,
-				Username: user.Username,
-				Event:    UserAddError,
-			})
-			continue
+	for i, user := range users {
+		if addErrs[i] == nil && !added[i] {
+			// The context was cancelled before the user was added.
+			addErrs[i] = err
 		}
-		err = c.Client.AddUserToGroup(ctx, token.AccessToken, c.Realm, *usr.ID, groupID)
-		if err != nil {
-			errs = append(errs, MembershipSyncError{Err: err, Username: user.Username, Event: UserAddError})
+		if addErrs[i] != nil {
+			errs = append(errs, MembershipSyncError{Err: addErrs[i], Username: user.Username, Event: UserAddError})
 			continue
 		}
 		res = append(res, user)
@@ -474,11 +597,20 @@ func (c Client) addUsersToGroup(ctx context.Context, token *gocloak.JWT, groupID
 	return res, nil
 }
 
-func (c Client) getUserByName(ctx context.Context, token *gocloak.JWT, name string) (*gocloak.User, error) {
-	// This may return more than one 1 result
-	users, err := c.Client.GetUsers(ctx, token.AccessToken, c.Realm, gocloak.GetUsersParams{
-		Max:      defaultParams.Max,
-		Username: &name,
+// getUserByName returns the user with the given username.
+// Without an exact search, Keycloak matches usernames by substring.
+func (c Client) getUserByName(ctx context.Context, token *session, name string, exact bool) (*gocloak.User, error) {
+	// Without an exact search, the search matches substrings of usernames, so it may return more than one page of results.
+	users, err := fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.User, error) {
+		params := gocloak.GetUsersParams{
+			First:    gocloak.IntP(first),
+			Max:      gocloak.IntP(max),
+			Username: &name,
+		}
+		if exact {
+			params.Exact = gocloak.BoolP(true)
+		}
+		return c.api().GetUsers(ctx, token.AccessToken, c.Realm, params)
 	})
 	if err != nil {
 		return nil, err
@@ -493,7 +625,7 @@ func (c Client) getUserByName(ctx context.Context, token *gocloak.JWT, name stri
 
 func (c Client) prependRoot(g Group) Group {
 	if c.RootGroup != "" {
-		g.path = append([]string{c.RootGroup}, g.path...)
+		g = g.WithPath(append([]string{c.RootGroup}, g.PathMembers()...)...)
 	}
 	return g
 }
@@ -536,20 +668,123 @@ func (c Client) filterTreeWithRoot(groups []*gocloak.Group) []gocloak.Group {
 // PutUser updates the given user referenced by its `Username` property.
 // An error is returned if a user can't be found.
 func (c Client) PutUser(ctx context.Context, user User) (User, error) {
-	token, err := c.login(ctx)
-	if err != nil {
-		return User{}, fmt.Errorf("failed binding to keycloak: %w", err)
-	}
-	defer c.logout(ctx, token)
+	ctx, span := c.startSpan(ctx, "PutUser", usernameAttr(user.Username))
+	var res User
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.putUser(ctx, token, user)
+		return err
+	})
+	return res, endSpan(span, err)
+}
 
-	kcUser, err := c.getUserByName(ctx, token, user.Username)
+func (c Client) putUser(ctx context.Context, token *session, user User) (User, error) {
+	// Matching usernames are filtered client-side, so an exact search is not required.
+	kcUser, err := c.getUserByName(ctx, token, user.Username, false)
 	if err != nil {
 		return User{}, fmt.Errorf("failed querying keycloak for user %q: %w", user.Username, err)
 	}
 
-	user.ApplyTo(kcUser)
+	ApplyUserTo(user, kcUser)
 	return UserFromKeycloakUser(*kcUser),
-		c.Client.UpdateUser(ctx, token.AccessToken, c.Realm, *kcUser)
+		c.api().UpdateUser(ctx, token.AccessToken, c.Realm, *kcUser)
+}
+
+// ListUsers returns all Keycloak users in the realm.
+func (c Client) ListUsers(ctx context.Context) ([]User, error) {
+	ctx, span := c.startSpan(ctx, "ListUsers")
+	var res []User
+	err := c.withToken(ctx, func(token *session) error {
+		users, err := fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.User, error) {
+			return c.api().GetUsers(ctx, token.AccessToken, c.Realm, userPageParams(first, max))
+		})
+		if err != nil {
+			return fmt.Errorf("failed listing users: %w", err)
+		}
+		res = make([]User, len(users))
+		for i := range users {
+			res[i] = UserFromKeycloakUser(*users[i])
+		}
+		return nil
+	})
+	span.SetAttributes(attribute.Int("keycloak.users", len(res)))
+	return res, endSpan(span, err)
+}
+
+// RemoveUserFromGroups removes the user with the given username from all groups managed by the client.
+// If a RootGroup is set, only groups below the root group are considered.
+// Without a RootGroup, the user is removed from every group in the realm, including groups not managed by the adapter.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) RemoveUserFromGroups(ctx context.Context, username string) error {
+	ctx, span := c.startSpan(ctx, "RemoveUserFromGroups", usernameAttr(username))
+	err := c.withToken(ctx, func(token *session) error {
+		user, err := c.getUserByName(ctx, token, username, false)
+		if errors.Is(err, UserNotFoundError{}) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed querying keycloak for user %q: %w", username, err)
+		}
+
+		groups, err := fetchPaged(c.pageSize(), func(first, max int) ([]*gocloak.Group, error) {
+			return c.api().GetUserGroups(ctx, token.AccessToken, c.Realm, *user.ID, pageParams(first, max))
+		})
+		if err != nil {
+			return fmt.Errorf("failed listing groups of user %q: %w", username, err)
+		}
+
+		var errs error
+		for _, g := range groups {
+			if c.RootGroup != "" && !strings.HasPrefix(*g.Path, "/"+c.RootGroup+"/") {
+				continue
+			}
+			err := c.api().DeleteUserFromGroup(ctx, token.AccessToken, c.Realm, *user.ID, *g.ID)
+			if err != nil && !isNotFound(err) {
+				errs = multierr.Append(errs, fmt.Errorf("failed removing user %q from group %q: %w", username, *g.Path, err))
+			}
+		}
+		return errs
+	})
+	return endSpan(span, err)
+}
+
+// DisableUser disables the user with the given username.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) DisableUser(ctx context.Context, username string) error {
+	ctx, span := c.startSpan(ctx, "DisableUser", usernameAttr(username))
+	err := c.withToken(ctx, func(token *session) error {
+		user, err := c.getUserByName(ctx, token, username, false)
+		if errors.Is(err, UserNotFoundError{}) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed querying keycloak for user %q: %w", username, err)
+		}
+		if user.Enabled != nil && !*user.Enabled {
+			return nil
+		}
+		user.Enabled = gocloak.BoolP(false)
+		return c.api().UpdateUser(ctx, token.AccessToken, c.Realm, *user)
+	})
+	return endSpan(span, err)
+}
+
+// DeleteUser deletes the user with the given username.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) DeleteUser(ctx context.Context, username string) error {
+	ctx, span := c.startSpan(ctx, "DeleteUser", usernameAttr(username))
+	err := c.withToken(ctx, func(token *session) error {
+		user, err := c.getUserByName(ctx, token, username, false)
+		if errors.Is(err, UserNotFoundError{}) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed querying keycloak for user %q: %w", username, err)
+		}
+		err = c.api().DeleteUser(ctx, token.AccessToken, c.Realm, *user.ID)
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+	return endSpan(span, err)
 }
 
 func containsUsername(s []User, a string) bool {
@@ -576,27 +811,41 @@ func diffByUsername(a, b []User) []User {
 	return diff
 }
 
-func flatGroups(gcp []gocloak.Group) []Group {
+// flatGroups returns the groups of the given trees up to the given depth, parents before their children.
+// A maxDepth of 0 or less returns all groups.
+func flatGroups(gcp []gocloak.Group, maxDepth int) []Group {
 	flat := make([]Group, 0)
-	var flatten func([]gocloak.Group)
-	flatten = func(groups []gocloak.Group) {
+	var flatten func(groups []gocloak.Group, parentID string, depth int)
+	flatten = func(groups []gocloak.Group, parentID string, depth int) {
+		if maxDepth > 0 && depth > maxDepth {
+			return
+		}
 		for _, g := range groups {
-			group := NewGroupFromPath(getDisplayNameOfGroup(&g), *g.Path)
-			group.id = *g.ID
+			group := NewGroupFromPath(getDisplayNameOfGroup(&g), *g.Path).
+				WithID(*g.ID).
+				WithAttributes(groupAttributes(&g)).
+				WithParentID(parentID)
 			flat = append(flat, group)
 			if g.SubGroups != nil {
-				flatten(*g.SubGroups)
+				flatten(*g.SubGroups, *g.ID, depth+1)
 			}
 		}
 	}
-	flatten(gcp)
+	flatten(gcp, "", 1)
 
 	return flat
 }
 
+// displayNameAttribute is the group attribute storing the display name of a group.
+const displayNameAttribute = "displayName"
+
 func getDisplayNameOfGroup(group *gocloak.Group) string {
-	if group.Attributes != nil {
-		displayNames, ok := (*group.Attributes)["displayName"]
+	return getDisplayName(group.Attributes)
+}
+
+func getDisplayName(attributes *map[string][]string) string {
+	if attributes != nil {
+		displayNames, ok := (*attributes)[displayNameAttribute]
 		if ok && len(displayNames) > 0 {
 			return displayNames[0]
 		}
@@ -610,14 +859,84 @@ func setDisplayName(attributes *map[string][]string, displayName string) *map[st
 		attributes = &attrMap
 	}
 	if displayName == "" {
-		delete(*attributes, "displayName")
+		delete(*attributes, displayNameAttribute)
 	} else {
-		(*attributes)["displayName"] = []string{displayName}
+		(*attributes)[displayNameAttribute] = []string{displayName}
+	}
+	return attributes
+}
+
+// setAttributes sets the desired attributes, removes attributes without values, and removes attributes with an owned prefix which are not desired.
+// Returns whether the attributes changed.
+func setAttributes(attributes *map[string][]string, desired map[string][]string, ownedPrefixes []string) (*map[string][]string, bool) {
+	if attributes == nil {
+		attrMap := make(map[string][]string)
+		attributes = &attrMap
+	}
+	changed := false
+	for k := range *attributes {
+		if _, ok := desired[k]; ok || k == displayNameAttribute {
+			continue
+		}
+		for _, prefix := range ownedPrefixes {
+			if prefix != "" && strings.HasPrefix(k, prefix) {
+				delete(*attributes, k)
+				changed = true
+				break
+			}
+		}
+	}
+	for k, v := range desired {
+		if k == displayNameAttribute {
+			continue
+		}
+		current, exists := (*attributes)[k]
+		if len(v) == 0 {
+			if exists {
+				delete(*attributes, k)
+				changed = true
+			}
+			continue
+		}
+		if !exists || !stringsEqual(current, v) {
+			(*attributes)[k] = v
+			changed = true
+		}
+	}
+	return attributes, changed
+}
+
+// groupAttributes returns the attributes of the given group, excluding the display name.
+func groupAttributes(group *gocloak.Group) map[string][]string {
+	return withoutDisplayName(group.Attributes)
+}
+
+// withoutDisplayName returns a copy of the given attributes without the display name.
+func withoutDisplayName(attrs *map[string][]string) map[string][]string {
+	if attrs == nil {
+		return nil
+	}
+	var attributes map[string][]string
+	for k, v := range *attrs {
+		if k == displayNameAttribute {
+			continue
+		}
+		if attributes == nil {
+			attributes = make(map[string][]string, len(*attrs))
+		}
+		attributes[k] = v
 	}
 	return attributes
 }
 
-var defaultParams = gocloak.GetGroupsParams{
-	Max:                 gocloak.IntP(-1),
-	BriefRepresentation: gocloak.BoolP(false), // required in order to get attributes when listing groups
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -25,13 +25,11 @@ func TestDeleteGroup_simple(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
 	mockDeleteGroup(mKeycloak, c, "foo-id")
 
-	err := c.DeleteGroup(context.TODO(), "foo-gmbh")
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh"))
 	require.NoError(t, err)
 }
 
@@ -45,13 +43,11 @@ func TestDeleteGroup_RootGroup(t *testing.T) {
 		RootGroup: "root-group",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh"),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "root-group/foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh"))
 	mockDeleteGroup(mKeycloak, c, "foo-id")
 
-	err := c.DeleteGroup(context.TODO(), "foo-gmbh")
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh"))
 	require.NoError(t, err)
 }
 
@@ -67,12 +63,104 @@ func TestDeleteGroup_subgroup(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "parent/foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "parent", "foo-gmbh"))
+	mockDeleteGroup(mKeycloak, c, "foo-id")
+
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "parent", "foo-gmbh"))
+	require.NoError(t, err)
+}
+
+func TestDeleteGroup_notFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", nil)
+
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh"))
+	require.NoError(t, err)
+}
+
+func TestDeleteGroup_searchFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "3.4.3")
+	mockGetGroups(mKeycloak, c, "foo gmbh",
 		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "parent", "foo-gmbh"),
+			func() *gocloak.Group {
+				g := newGocloakGroup("", "parent-id", "parent")
+				g.SubGroups = &[]gocloak.Group{*newGocloakGroup("Foo Inc.", "foo-id", "parent", "foo gmbh")}
+				return g
+			}(),
 		})
 	mockDeleteGroup(mKeycloak, c, "foo-id")
 
-	err := c.DeleteGroup(context.TODO(), "parent", "foo-gmbh")
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "parent", "foo gmbh"))
+	require.NoError(t, err)
+}
+
+func TestDeleteGroup_withID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:    mKeycloak,
+		RootGroup: "root-group",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetGroup(mKeycloak, c, "foo-id", newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh"))
+	mockDeleteGroup(mKeycloak, c, "foo-id")
+
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh").WithID("foo-id"))
+	require.NoError(t, err)
+}
+
+func TestDeleteGroup_staleID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroup(mKeycloak, c, "stale-id", nil)
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
+	mockDeleteGroup(mKeycloak, c, "foo-id")
+
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh").WithID("stale-id"))
+	require.NoError(t, err)
+}
+
+func TestDeleteGroup_searchNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "3.4.3")
+	mockGetGroups(mKeycloak, c, "foo-gmbh",
+		[]*gocloak.Group{
+			newGocloakGroup("Foo Inc.", "test-id", "foo-gmbh-test"),
+		})
+
+	err := c.DeleteGroup(context.TODO(), NewGroup("", "foo-gmbh"))
 	require.NoError(t, err)
 }
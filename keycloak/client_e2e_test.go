@@ -0,0 +1,158 @@
+package keycloak_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak/keycloaktest"
+)
+
+var e2eVersions = []string{"3.4.3", "22.0.5", "23.0.0", "25.0.1"}
+
+func TestE2E_PutListDeleteGroups(t *testing.T) {
+	for _, version := range e2eVersions {
+		t.Run(version, func(t *testing.T) {
+			srv := keycloaktest.NewServer("appuio", version)
+			defer srv.Close()
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+			c := srv.NewClient("admin", "secret")
+			c.PageSize = 1
+			ctx := context.Background()
+
+			_, err := c.PutGroup(ctx, NewGroup("Foo Inc.", "foo").WithMemberNames("alice"))
+			require.NoError(t, err)
+			_, err = c.PutGroup(ctx, NewGroup("Foo Team", "foo", "team").WithMemberNames("alice", "bob"))
+			require.NoError(t, err)
+			_, err = c.PutGroup(ctx, NewGroup("Bar Inc.", "bar").WithMemberNames("bob"))
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"/bar", "/foo", "/foo/team"}, srv.GroupPaths())
+			assert.Equal(t, []string{"alice", "bob"}, srv.Members("foo", "team"))
+			team, ok := srv.Group("foo", "team")
+			require.True(t, ok)
+			assert.Equal(t, []string{"Foo Team"}, (*team.Attributes)["displayName"])
+
+			groups, err := c.ListGroups(ctx)
+			require.NoError(t, err)
+			paths := map[string][]string{}
+			for _, g := range groups {
+				for _, m := range g.Members {
+					paths[g.Path()] = append(paths[g.Path()], m.Username)
+				}
+			}
+			assert.Equal(t, map[string][]string{
+				"/bar":      {"bob"},
+				"/foo":      {"alice"},
+				"/foo/team": {"alice", "bob"},
+			}, paths)
+
+			_, err = c.PutGroup(ctx, NewGroup("Foo Team", "foo", "team").WithMemberNames("bob"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bob"}, srv.Members("foo", "team"))
+
+			require.NoError(t, c.DeleteGroup(ctx, NewGroup("", "foo", "team")))
+			require.NoError(t, c.DeleteGroup(ctx, NewGroup("", "foo", "team")), "deleting a missing group is idempotent")
+			assert.Equal(t, []string{"/bar", "/foo"}, srv.GroupPaths())
+		})
+	}
+}
+
+func TestE2E_Users(t *testing.T) {
+	for _, version := range e2eVersions {
+		t.Run(version, func(t *testing.T) {
+			srv := keycloaktest.NewServer("appuio", version)
+			defer srv.Close()
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("alice2")})
+			srv.AddGroup("Foo Inc.", "foo")
+			srv.AddMember("alice", "foo")
+			srv.AddMember("alice2", "foo")
+			c := srv.NewClient("admin", "secret")
+			ctx := context.Background()
+
+			_, err := c.PutUser(ctx, User{Username: "alice", DefaultOrganizationRef: "foo"})
+			require.NoError(t, err)
+			alice, _ := srv.User("alice")
+			assert.Equal(t, []string{"foo"}, (*alice.Attributes)["appuio.io/default-organization"])
+
+			users, err := c.ListUsers(ctx)
+			require.NoError(t, err)
+			require.Len(t, users, 2)
+			assert.Equal(t, "foo", users[0].DefaultOrganizationRef)
+
+			require.NoError(t, c.RemoveUserFromGroups(ctx, "alice"))
+			assert.Equal(t, []string{"alice2"}, srv.Members("foo"))
+
+			require.NoError(t, c.DisableUser(ctx, "alice"))
+			alice, _ = srv.User("alice")
+			assert.False(t, *alice.Enabled)
+
+			require.NoError(t, c.DeleteUser(ctx, "alice"))
+			require.NoError(t, c.DeleteUser(ctx, "alice"), "deleting a missing user is idempotent")
+			_, ok := srv.User("alice")
+			assert.False(t, ok)
+			_, ok = srv.User("alice2")
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestE2E_ExpiredToken(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	c := srv.NewClient("admin", "secret")
+	ctx := context.Background()
+
+	_, err := c.PutGroup(ctx, NewGroup("Foo Inc.", "foo"))
+	require.NoError(t, err)
+	srv.ExpireTokens()
+	_, err = c.PutGroup(ctx, NewGroup("Bar Inc.", "bar"))
+	require.NoError(t, err, "the client logs in again if the token is rejected")
+	assert.Equal(t, []string{"/bar", "/foo"}, srv.GroupPaths())
+}
+
+func TestE2E_InvalidCredentials(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	c := NewClient(srv.URL, srv.Realm, "admin", "wrong")
+
+	_, err := c.ListGroups(context.Background())
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestE2E_Ping(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := srv.NewClient("admin", "secret")
+	require.NoError(t, c.Ping(ctx))
+	srv.ExpireTokens()
+	require.NoError(t, c.Ping(ctx), "the client logs in again if the token is rejected")
+
+	err := NewClient(srv.URL, srv.Realm, "admin", "wrong").Ping(ctx)
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestE2E_PagedSearch(t *testing.T) {
+	// Keycloak 3 neither looks up groups by path nor searches users by exact name, so both are found by searching.
+	srv := keycloaktest.NewServer("appuio", "3.4.3")
+	defer srv.Close()
+	srv.AddGroup("", "afoo")
+	srv.AddGroup("", "foo")
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("aalice")})
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	c := srv.NewClient("admin", "secret")
+	c.PageSize = 1
+
+	_, err := c.PutGroup(context.Background(), NewGroup("Foo Inc.", "foo").WithMemberNames("alice"))
+	require.NoError(t, err, "matches beyond the first page are found")
+	assert.Equal(t, []string{"/afoo", "/foo"}, srv.GroupPaths())
+	assert.Equal(t, []string{"alice"}, srv.Members("foo"))
+}
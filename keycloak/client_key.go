@@ -0,0 +1,41 @@
+package keycloak
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clientAssertionLifetime is the validity of the signed JWT used to authenticate a client.
+const clientAssertionLifetime = time.Minute
+
+// ParseClientKey parses a PEM encoded RSA or EC private key used to sign client assertions.
+// It returns the key and a matching signing method.
+func ParseClientKey(pem []byte) (interface{}, jwt.SigningMethod, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return rsaKey, jwt.SigningMethodRS256, nil
+	}
+
+	ecKey, err := jwt.ParseECPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, nil, errors.New("key is neither a PEM encoded RSA nor EC private key")
+	}
+	method, err := ecSigningMethod(ecKey)
+	return ecKey, method, err
+}
+
+func ecSigningMethod(key *ecdsa.PrivateKey) (jwt.SigningMethod, error) {
+	switch key.Curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, fmt.Errorf("unsupported curve %q", key.Curve.Params().Name)
+}
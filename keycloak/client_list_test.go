@@ -2,6 +2,7 @@ package keycloak_test
 
 import (
 	context "context"
+	"errors"
 	"fmt"
 
 	"testing"
@@ -88,6 +89,7 @@ func TestListGroups_simple_keycloak23(t *testing.T) {
 	setupChildGroupResponse(c, "foo-id", make([]gocloak.Group, 0))
 	setupChildGroupResponse(c, "bar-id", make([]gocloak.Group, 0))
 	setupChildGroupResponse(c, "parent-id", *subGroups)
+	setupChildGroupResponse(c, "qux-id", make([]gocloak.Group, 0))
 
 	gs := []*gocloak.Group{
 		newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
@@ -96,7 +98,7 @@ func TestListGroups_simple_keycloak23(t *testing.T) {
 	}
 	mockLogin(mKeycloak, c)
 	mockListGroups(mKeycloak, c, gs)
-	mockKeycloakSubgroups(mKeycloak, rst, 3)
+	mockKeycloakSubgroups(mKeycloak, rst, 4)
 	for i, id := range []string{"foo-id", "bar-id", "parent-id", "qux-id"} {
 		us := []*gocloak.User{}
 		for j := 0; j < i; j++ {
@@ -166,6 +168,125 @@ func TestListGroups_RootGroup(t *testing.T) {
 	assert.Equal(t, "/foo-gmbh/foo-team", res[1].Path())
 }
 
+func TestListGroups_nested(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Host:   "https://example.com",
+		Realm:  "myrealm",
+	}
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	org := newGocloakGroup("Foo Inc.", "org-id", "org")
+	(*org.Attributes)["billing-entity"] = []string{"be-1"}
+	team := newGocloakGroup("", "team-id", "org", "team")
+	team.SubGroups = &[]gocloak.Group{*newGocloakGroup("", "sub-id", "org", "team", "sub")}
+	org.SubGroups = &[]gocloak.Group{*team}
+	mockLogin(mKeycloak, c)
+	mockListGroups(mKeycloak, c, []*gocloak.Group{org})
+	for _, id := range []string{"org-id", "team-id", "sub-id"} {
+		mockGetGroupMembers(mKeycloak, c, id, []*gocloak.User{})
+	}
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 3)
+	assert.Equal(t, "/org", res[0].Path())
+	assert.Equal(t, "/org/team", res[1].Path())
+	assert.Equal(t, "/org/team/sub", res[2].Path())
+	assert.Equal(t, map[string][]string{"billing-entity": {"be-1"}}, res[0].Attributes())
+	assert.Nil(t, res[1].Attributes())
+
+	_, ok := res[0].Parent()
+	assert.False(t, ok)
+	parent, ok := res[2].Parent()
+	require.True(t, ok)
+	assert.Equal(t, "/org/team", parent.Path())
+	assert.Equal(t, "team-id", parent.ID())
+	assert.Equal(t, 2, res[2].Depth())
+}
+
+func TestListGroups_nested_MaxDepth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:   mKeycloak,
+		Host:     "https://example.com",
+		Realm:    "myrealm",
+		MaxDepth: 2,
+	}
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	org := newGocloakGroup("Foo Inc.", "org-id", "org")
+	team := newGocloakGroup("", "team-id", "org", "team")
+	team.SubGroups = &[]gocloak.Group{*newGocloakGroup("", "sub-id", "org", "team", "sub")}
+	org.SubGroups = &[]gocloak.Group{*team}
+	mockLogin(mKeycloak, c)
+	mockListGroups(mKeycloak, c, []*gocloak.Group{org})
+	for _, id := range []string{"org-id", "team-id"} {
+		mockGetGroupMembers(mKeycloak, c, id, []*gocloak.User{})
+	}
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 2)
+	assert.Equal(t, "/org", res[0].Path())
+	assert.Equal(t, "/org/team", res[1].Path())
+}
+
+func TestListGroups_nested_keycloak23(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rst := setupHttpMock()
+	defer httpmock.DeactivateAndReset()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:    mKeycloak,
+		Host:      "https://example.com",
+		Realm:     "myrealm",
+		RootGroup: "root-group",
+		MaxDepth:  3,
+	}
+
+	mockGetServerInfo(mKeycloak, "23.0.0")
+	mockLogin(mKeycloak, c)
+	mockListGroups(mKeycloak, c, []*gocloak.Group{
+		newGocloakGroup("", "other-id", "other"),
+		newGocloakGroup("", "root-group-id", "root-group"),
+	})
+	setupChildGroupResponse(c, "root-group-id", []gocloak.Group{*newGocloakGroup("", "org-id", "root-group", "org")})
+	setupChildGroupResponse(c, "org-id", []gocloak.Group{*newGocloakGroup("", "team-id", "root-group", "org", "team")})
+	setupChildGroupResponse(c, "team-id", []gocloak.Group{*newGocloakGroup("", "sub-id", "root-group", "org", "team", "sub")})
+	// Only the tree below the root group is fetched, down to the maximum depth
+	mockKeycloakSubgroups(mKeycloak, rst, 3)
+	for _, id := range []string{"org-id", "team-id", "sub-id"} {
+		mockGetGroupMembers(mKeycloak, c, id, []*gocloak.User{})
+	}
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 3)
+	assert.Equal(t, "/org", res[0].Path())
+	assert.Equal(t, "/org/team", res[1].Path())
+	assert.Equal(t, "/org/team/sub", res[2].Path())
+
+	parent, ok := res[1].Parent()
+	require.True(t, ok)
+	assert.Equal(t, "org-id", parent.ID())
+	_, ok = res[0].Parent()
+	assert.False(t, ok, "groups below the root group are top-level groups")
+}
+
 func TestListGroups_RootGroup_no_groups_under_root(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -212,3 +333,202 @@ func TestListGroups_RootGroup_RootNotFound(t *testing.T) {
 	_, err := c.ListGroups(context.TODO())
 	require.Error(t, err)
 }
+
+func TestListGroups_paginated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:   mKeycloak,
+		Host:     "https://example.com",
+		Realm:    "myrealm",
+		PageSize: 2,
+	}
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockLogin(mKeycloak, c)
+	mockListGroupsPage(mKeycloak, c, 0, 2, []*gocloak.Group{
+		newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
+		newGocloakGroup("Bar Inc.", "bar-id", "bar-gmbh"),
+	})
+	mockListGroupsPage(mKeycloak, c, 2, 2, []*gocloak.Group{
+		newGocloakGroup("Baz Inc.", "baz-id", "baz-gmbh"),
+	})
+	mockGetGroupMembersPage(mKeycloak, c, "foo-id", 0, 2, []*gocloak.User{
+		{ID: gocloak.StringP("1"), Username: gocloak.StringP("user-1")},
+		{ID: gocloak.StringP("2"), Username: gocloak.StringP("user-2")},
+	})
+	mockGetGroupMembersPage(mKeycloak, c, "foo-id", 2, 2, []*gocloak.User{
+		{ID: gocloak.StringP("3"), Username: gocloak.StringP("user-3")},
+		{ID: gocloak.StringP("4"), Username: gocloak.StringP("user-4")},
+	})
+	mockGetGroupMembersPage(mKeycloak, c, "foo-id", 4, 2, []*gocloak.User{})
+	mockGetGroupMembersPage(mKeycloak, c, "bar-id", 0, 2, []*gocloak.User{})
+	mockGetGroupMembersPage(mKeycloak, c, "baz-id", 0, 2, []*gocloak.User{
+		{ID: gocloak.StringP("1"), Username: gocloak.StringP("user-1")},
+	})
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 3)
+	assert.Equal(t, "/foo-gmbh", res[0].Path())
+	assert.Equal(t, "/bar-gmbh", res[1].Path())
+	assert.Equal(t, "/baz-gmbh", res[2].Path())
+
+	require.Len(t, res[0].Members, 4)
+	assert.Equal(t, "user-4", res[0].Members[3].Username)
+	assert.Len(t, res[1].Members, 0)
+	assert.Len(t, res[2].Members, 1)
+}
+
+func TestListGroups_paginated_keycloak23(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rst := setupHttpMock()
+	defer httpmock.DeactivateAndReset()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:   mKeycloak,
+		Host:     "https://example.com",
+		Realm:    "myrealm",
+		PageSize: 2,
+	}
+
+	mockGetServerInfo(mKeycloak, "23.0.0")
+	mockLogin(mKeycloak, c)
+	mockListGroupsPage(mKeycloak, c, 0, 2, []*gocloak.Group{
+		newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
+	})
+	setupChildGroupPageResponse(c, "foo-id", 0, 2, []gocloak.Group{
+		*newGocloakGroup("Team 1", "team-1-id", "foo-gmbh", "team-1"),
+		*newGocloakGroup("Team 2", "team-2-id", "foo-gmbh", "team-2"),
+	})
+	setupChildGroupPageResponse(c, "foo-id", 2, 2, []gocloak.Group{
+		*newGocloakGroup("Team 3", "team-3-id", "foo-gmbh", "team-3"),
+	})
+	for _, id := range []string{"team-1-id", "team-2-id", "team-3-id"} {
+		setupChildGroupPageResponse(c, id, 0, 2, []gocloak.Group{})
+	}
+	mockKeycloakSubgroups(mKeycloak, rst, 5)
+	for _, id := range []string{"foo-id", "team-1-id", "team-2-id", "team-3-id"} {
+		mockGetGroupMembersPage(mKeycloak, c, id, 0, 2, []*gocloak.User{})
+	}
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 4)
+	assert.Equal(t, "/foo-gmbh", res[0].Path())
+	assert.Equal(t, "/foo-gmbh/team-1", res[1].Path())
+	assert.Equal(t, "/foo-gmbh/team-2", res[2].Path())
+	assert.Equal(t, "/foo-gmbh/team-3", res[3].Path())
+}
+
+func TestListGroups_parallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rst := setupHttpMock()
+	defer httpmock.DeactivateAndReset()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:      mKeycloak,
+		Host:        "https://example.com",
+		Realm:       "myrealm",
+		Concurrency: 4,
+	}
+
+	mockGetServerInfo(mKeycloak, "23.0.0")
+	mockLogin(mKeycloak, c)
+
+	gs := []*gocloak.Group{}
+	for i := 0; i < 10; i++ {
+		orgName := fmt.Sprintf("org-%d", i)
+		gs = append(gs, newGocloakGroup("", orgName+"-id", orgName))
+		setupChildGroupResponse(c, orgName+"-id", []gocloak.Group{
+			*newGocloakGroup("", orgName+"-team-id", orgName, "team"),
+		})
+		setupChildGroupResponse(c, orgName+"-team-id", []gocloak.Group{})
+		mockGetGroupMembers(mKeycloak, c, orgName+"-id", []*gocloak.User{
+			{ID: gocloak.StringP(orgName + "-user-id"), Username: gocloak.StringP(orgName + "-user")},
+		})
+		mockGetGroupMembers(mKeycloak, c, orgName+"-team-id", []*gocloak.User{})
+	}
+	mockListGroups(mKeycloak, c, gs)
+	mockKeycloakSubgroups(mKeycloak, rst, 20)
+
+	res, err := c.ListGroups(context.TODO())
+	require.NoError(t, err)
+
+	require.Len(t, res, 20)
+	for i := 0; i < 10; i++ {
+		orgName := fmt.Sprintf("org-%d", i)
+		assert.Equal(t, "/"+orgName, res[2*i].Path())
+		require.Len(t, res[2*i].Members, 1)
+		assert.Equal(t, orgName+"-user", res[2*i].Members[0].Username)
+		assert.Equal(t, "/"+orgName+"/team", res[2*i+1].Path())
+	}
+}
+
+func TestListGroups_parallel_error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:      mKeycloak,
+		Host:        "https://example.com",
+		Realm:       "myrealm",
+		Concurrency: 2,
+	}
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockLogin(mKeycloak, c)
+
+	gs := []*gocloak.Group{}
+	for i := 0; i < 10; i++ {
+		gs = append(gs, newGocloakGroup("", fmt.Sprintf("org-%d-id", i), fmt.Sprintf("org-%d", i)))
+	}
+	mockListGroups(mKeycloak, c, gs)
+	mKeycloak.EXPECT().
+		GetGroupMembers(gomock.Any(), "token", c.Realm, "org-0-id", gomock.Any()).
+		Return(nil, errors.New("unavailable")).
+		Times(1)
+	mKeycloak.EXPECT().
+		GetGroupMembers(gomock.Any(), "token", c.Realm, gomock.Any(), gomock.Any()).
+		Return([]*gocloak.User{}, nil).
+		MaxTimes(9)
+
+	_, err := c.ListGroups(context.TODO())
+	require.ErrorContains(t, err, "unavailable")
+}
+
+func TestListGroups_cancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:      mKeycloak,
+		Host:        "https://example.com",
+		Realm:       "myrealm",
+		Concurrency: 2,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockLogin(mKeycloak, c)
+	mockListGroups(mKeycloak, c, []*gocloak.Group{
+		newGocloakGroup("", "org-id", "org"),
+	})
+
+	_, err := c.ListGroups(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
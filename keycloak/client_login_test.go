@@ -2,9 +2,16 @@ package keycloak_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 
 	gocloak "github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
 	gomock "github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -71,3 +78,116 @@ func TestLogin_WithLoginRealm(t *testing.T) {
 	_, err := c.ListGroups(context.Background())
 	require.NoError(t, err)
 }
+
+func TestLogin_ClientCredentials(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Realm:        "target-realm",
+		ClientID:     "adapter",
+		ClientSecret: "secret",
+		Client:       mKeycloak,
+	}
+
+	mKeycloak.EXPECT().
+		LoginClient(gomock.Any(), "adapter", "secret", "target-realm").
+		Return(&gocloak.JWT{
+			AccessToken: "token",
+		}, nil).
+		Times(1)
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockListGroups(mKeycloak, c, []*gocloak.Group{})
+
+	_, err := c.ListGroups(context.Background())
+	require.NoError(t, err)
+}
+
+func TestLogin_ClientCredentials_WithSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Realm:        "target-realm",
+		LoginRealm:   "login-realm",
+		ClientID:     "adapter",
+		ClientSecret: "secret",
+		Client:       mKeycloak,
+	}
+
+	mKeycloak.EXPECT().
+		LoginClient(gomock.Any(), "adapter", "secret", "login-realm").
+		Return(&gocloak.JWT{
+			AccessToken:  "token",
+			RefreshToken: "refresh",
+		}, nil).
+		Times(1)
+	mKeycloak.EXPECT().
+		Logout(gomock.Any(), "adapter", "secret", "login-realm", "refresh").
+		Return(nil).
+		Times(1)
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockListGroups(mKeycloak, c, []*gocloak.Group{})
+
+	_, err := c.ListGroups(context.Background())
+	require.NoError(t, err)
+}
+
+func TestLogin_SignedJWT(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	parsedKey, method, err := ParseClientKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.Equal(t, jwt.SigningMethodES256, method)
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Realm:                  "target-realm",
+		ClientID:               "adapter",
+		ClientKey:              parsedKey,
+		ClientKeySigningMethod: method,
+		Client:                 mKeycloak,
+	}
+
+	mKeycloak.EXPECT().
+		LoginClientSignedJWT(gomock.Any(), "adapter", "target-realm", parsedKey, jwt.SigningMethodES256, gomock.Any()).
+		Return(&gocloak.JWT{
+			AccessToken:  "token",
+			RefreshToken: "refresh",
+		}, nil).
+		Times(1)
+
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockListGroups(mKeycloak, c, []*gocloak.Group{})
+
+	_, err = c.ListGroups(context.Background())
+	require.NoError(t, err)
+}
+
+func TestParseClientKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, method, err := ParseClientKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+	require.NoError(t, err)
+	require.Equal(t, jwt.SigningMethodRS256, method)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	_, method, err = ParseClientKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.Equal(t, jwt.SigningMethodES384, method)
+
+	_, _, err = ParseClientKey([]byte("not a key"))
+	require.Error(t, err)
+}
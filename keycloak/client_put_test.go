@@ -26,10 +26,8 @@ func TestPutGroup_simple(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
 	mockGetGroupMembers(mKeycloak, c, "foo-id",
 		[]*gocloak.User{
 			{
@@ -37,14 +35,15 @@ func TestPutGroup_simple(t *testing.T) {
 				Username: gocloak.StringP("user"),
 			},
 		})
-	mockGetUser(mKeycloak, c, "user2", "2")
-	mockGetUser(mKeycloak, c, "user3", "3")
+	mockGetUserExact(mKeycloak, c, "user2", "2")
+	mockGetUserExact(mKeycloak, c, "user3", "3")
 	mockAddUser(mKeycloak, c, "3", "foo-id")
 	mockAddUser(mKeycloak, c, "2", "foo-id")
 
 	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user", "user2", "user3"))
 	require.NoError(t, err)
 	assert.Len(t, g.Members, 3)
+	assert.Equal(t, "foo-id", g.ID())
 }
 
 func TestPutGroup_RootGroup_update(t *testing.T) {
@@ -57,14 +56,8 @@ func TestPutGroup_RootGroup_update(t *testing.T) {
 		RootGroup: "root-group",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			func() *gocloak.Group {
-				g := newGocloakGroup("Foo Inc.", "root-group-id", "root-group")
-				g.SubGroups = &[]gocloak.Group{*newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh")}
-				return g
-			}(),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "root-group/foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh"))
 	mockGetGroupMembers(mKeycloak, c, "foo-id",
 		[]*gocloak.User{
 			{
@@ -72,8 +65,8 @@ func TestPutGroup_RootGroup_update(t *testing.T) {
 				Username: gocloak.StringP("user"),
 			},
 		})
-	mockGetUser(mKeycloak, c, "user2", "2")
-	mockGetUser(mKeycloak, c, "user3", "3")
+	mockGetUserExact(mKeycloak, c, "user2", "2")
+	mockGetUserExact(mKeycloak, c, "user3", "3")
 	mockAddUser(mKeycloak, c, "3", "foo-id")
 	mockAddUser(mKeycloak, c, "2", "foo-id")
 
@@ -94,9 +87,10 @@ func TestPutGroup_new(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh", []*gocloak.Group{})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", nil)
 	mockCreateGroup(mKeycloak, c, "foo-gmbh", "Foo Inc.", "/foo-gmbh", "foo-id")
-	mockGetUser(mKeycloak, c, "user", "1")
+	mockGetUserExact(mKeycloak, c, "user", "1")
 	mockAddUser(mKeycloak, c, "1", "foo-id")
 
 	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user"))
@@ -115,16 +109,11 @@ func TestPutGroup_RootGroup_new(t *testing.T) {
 		RootGroup: "root-group",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh", []*gocloak.Group{})
-	mockGetGroups(mKeycloak, c, "root-group", []*gocloak.Group{
-		{
-			ID:   gocloak.StringP("root-group-id"),
-			Path: gocloak.StringP("/root-group"),
-			Name: gocloak.StringP("root-group"),
-		},
-	})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "root-group/foo-gmbh", nil)
+	mockGetGroupByPath(mKeycloak, c, "root-group", newGocloakGroup("", "root-group-id", "root-group"))
 	mockCreateChildGroup(mKeycloak, c, "root-group-id", "foo-gmbh", "Foo Inc.", "/root-group/foo-gmbh", "foo-id")
-	mockGetUser(mKeycloak, c, "user", "1")
+	mockGetUserExact(mKeycloak, c, "user", "1")
 	mockAddUser(mKeycloak, c, "1", "foo-id")
 
 	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user"))
@@ -143,8 +132,9 @@ func TestPutGroup_RootGroup_non_existing_root_group(t *testing.T) {
 		RootGroup: "root-group",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh", []*gocloak.Group{})
-	mockGetGroups(mKeycloak, c, "root-group", []*gocloak.Group{})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "root-group/foo-gmbh", nil)
+	mockGetGroupByPath(mKeycloak, c, "root-group", nil)
 
 	_, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user"))
 	require.Error(t, err)
@@ -162,16 +152,11 @@ func TestPutGroup_new_with_path(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh", []*gocloak.Group{})
-	mockGetGroups(mKeycloak, c, "Parent", []*gocloak.Group{
-		{
-			ID:   gocloak.StringP("Parent-ID"),
-			Path: gocloak.StringP("/Parent"),
-			Name: gocloak.StringP("Parent"),
-		},
-	})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "Parent/foo-gmbh", nil)
+	mockGetGroupByPath(mKeycloak, c, "Parent", newGocloakGroup("", "Parent-ID", "Parent"))
 	mockCreateChildGroup(mKeycloak, c, "Parent-ID", "foo-gmbh", "Foo Inc.", "/Parent/foo-gmbh", "foo-id")
-	mockGetUser(mKeycloak, c, "user", "1")
+	mockGetUserExact(mKeycloak, c, "user", "1")
 	mockAddUser(mKeycloak, c, "1", "foo-id")
 
 	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "Parent", "foo-gmbh").WithMemberNames("user"))
@@ -192,6 +177,7 @@ func TestPutGroup_multiple_matching_groups(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "3.4.3")
 	mockGetGroups(mKeycloak, c, "foo-gmbh",
 		[]*gocloak.Group{
 			newGocloakGroup("Foo Inc.", "test-id", "foo-gmbh-test"),
@@ -225,12 +211,10 @@ func TestPutGroup_multiple_matching_users(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
 	mockGetGroupMembers(mKeycloak, c, "foo-id", []*gocloak.User{})
-	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{
+	mockGetUsersExact(mKeycloak, c, "user", []*gocloak.User{
 		{ID: gocloak.StringP("fakeuser"), Username: gocloak.StringP("user-fake")},
 		{ID: gocloak.StringP("1"), Username: gocloak.StringP("user")},
 	})
@@ -253,10 +237,8 @@ func TestPutGroup_remove_member(t *testing.T) {
 		Password: "buzz",
 	}
 	mockLogin(mKeycloak, c)
-	mockGetGroups(mKeycloak, c, "foo-gmbh",
-		[]*gocloak.Group{
-			newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"),
-		})
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
 	mockGetGroupMembers(mKeycloak, c, "foo-id",
 		[]*gocloak.User{
 			{
@@ -268,8 +250,8 @@ func TestPutGroup_remove_member(t *testing.T) {
 				Username: gocloak.StringP("user4"),
 			},
 		})
-	mockGetUser(mKeycloak, c, "user2", "2")
-	mockGetUser(mKeycloak, c, "user3", "3")
+	mockGetUserExact(mKeycloak, c, "user2", "2")
+	mockGetUserExact(mKeycloak, c, "user3", "3")
 	mockAddUser(mKeycloak, c, "3", "foo-id")
 	mockAddUser(mKeycloak, c, "2", "foo-id")
 	mockRemoveUser(mKeycloak, c, "4", "foo-id")
@@ -278,3 +260,256 @@ func TestPutGroup_remove_member(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, g.Members, 3)
 }
+
+func TestPutGroup_withID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:    mKeycloak,
+		RootGroup: "root-group",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetGroup(mKeycloak, c, "foo-id", newGocloakGroup("Foo Inc.", "foo-id", "root-group", "foo-gmbh"))
+	mockGetGroupMembers(mKeycloak, c, "foo-id",
+		[]*gocloak.User{
+			{
+				ID:       gocloak.StringP("1"),
+				Username: gocloak.StringP("user"),
+			},
+		})
+
+	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithID("foo-id").WithMemberNames("user"))
+	require.NoError(t, err)
+	assert.Len(t, g.Members, 1)
+	assert.Equal(t, "foo-id", g.ID())
+}
+
+func TestPutGroup_movedID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroup(mKeycloak, c, "old-id", newGocloakGroup("Foo Inc.", "old-id", "other-parent", "foo-gmbh"))
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", nil)
+	mockCreateGroup(mKeycloak, c, "foo-gmbh", "Foo Inc.", "/foo-gmbh", "foo-id")
+
+	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithID("old-id"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo-id", g.ID())
+}
+
+func TestPutGroup_unresolved_members(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:      mKeycloak,
+		Realm:       "foo",
+		Concurrency: 4,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
+	mockGetGroupMembers(mKeycloak, c, "foo-id", []*gocloak.User{})
+	mockGetUserExact(mKeycloak, c, "user", "1")
+	mockGetUsersExact(mKeycloak, c, "missing-1", []*gocloak.User{})
+	mockGetUsersExact(mKeycloak, c, "missing-2", []*gocloak.User{})
+	mockAddUser(mKeycloak, c, "1", "foo-id")
+
+	// Duplicate names are only looked up once
+	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("missing-1", "user", "missing-2", "missing-1"))
+	assert.Len(t, g.Members, 1)
+
+	var membErrs *MembershipSyncErrors
+	require.ErrorAs(t, err, &membErrs)
+	require.Len(t, *membErrs, 3)
+	for _, e := range *membErrs {
+		assert.ErrorIs(t, e, UserNotFoundError{})
+		assert.Equal(t, UserAddError, e.Event)
+	}
+	assert.Equal(t, "missing-1", (*membErrs)[0].Username)
+	assert.Equal(t, "missing-2", (*membErrs)[1].Username)
+	assert.Contains(t, err.Error(), `user "missing-1" not found`)
+	assert.Contains(t, err.Error(), `user "missing-2" not found`)
+}
+
+func TestPutGroup_user_lookup_cached_per_call(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:      mKeycloak,
+		Realm:       "foo",
+		Concurrency: 4,
+	}
+	mockLogin(mKeycloak, c)
+	mKeycloak.EXPECT().
+		GetServerInfo(gomock.Any(), "token").
+		Return(&gocloak.ServerInfoRepresentation{
+			SystemInfo: &gocloak.SystemInfoRepresentation{Version: gocloak.StringP("22.0.0")},
+		}, nil).
+		Times(2)
+	foo := newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh")
+	mKeycloak.EXPECT().
+		GetGroupByPath(gomock.Any(), "token", c.Realm, "foo-gmbh").
+		Return(foo, nil).
+		Times(2)
+	mKeycloak.EXPECT().
+		GetGroupMembers(gomock.Any(), "token", c.Realm, "foo-id", gomock.Any()).
+		Return([]*gocloak.User{}, nil).
+		Times(2)
+	// The cache of a call is not shared with the next call.
+	mKeycloak.EXPECT().
+		GetUsers(gomock.Any(), "token", c.Realm, gocloak.GetUsersParams{
+			Username: gocloak.StringP("user"),
+			First:    gocloak.IntP(0),
+			Max:      gocloak.IntP(100),
+			Exact:    gocloak.BoolP(true),
+		}).
+		Return([]*gocloak.User{{ID: gocloak.StringP("1"), Username: gocloak.StringP("user")}}, nil).
+		Times(2)
+	mKeycloak.EXPECT().
+		AddUserToGroup(gomock.Any(), "token", c.Realm, "1", "foo-id").
+		Return(nil).
+		Times(2)
+
+	for i := 0; i < 2; i++ {
+		g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user"))
+		require.NoError(t, err)
+		assert.Len(t, g.Members, 1)
+	}
+}
+
+func TestPutGroup_legacy_user_search(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "21.1.2")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh"))
+	mockGetGroupMembers(mKeycloak, c, "foo-id", []*gocloak.User{})
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{
+		{ID: gocloak.StringP("fakeuser"), Username: gocloak.StringP("user-fake")},
+		{ID: gocloak.StringP("1"), Username: gocloak.StringP("user")},
+	})
+	mockAddUser(mKeycloak, c, "1", "foo-id")
+
+	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithMemberNames("user"))
+	require.NoError(t, err)
+	assert.Len(t, g.Members, 1)
+}
+
+func TestPutGroup_attributes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	existing := newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh")
+	(*existing.Attributes)["example.com/stale"] = []string{"old"}
+	(*existing.Attributes)["example.com/changed"] = []string{"old"}
+	(*existing.Attributes)["billing-entity"] = []string{"be-1"}
+	(*existing.Attributes)["unmanaged"] = []string{"keep"}
+
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", existing)
+	mockGetGroupMembers(mKeycloak, c, "foo-id", []*gocloak.User{})
+	mKeycloak.EXPECT().
+		UpdateGroup(gomock.Any(), "token", c.Realm, gocloak.Group{
+			ID:   gocloak.StringP("foo-id"),
+			Name: gocloak.StringP("foo-gmbh"),
+			Path: gocloak.StringP("/foo-gmbh"),
+			Attributes: &map[string][]string{
+				"displayName":         {"Foo Inc."},
+				"example.com/changed": {"new"},
+				"example.com/new":     {"a", "b"},
+				"unmanaged":           {"keep"},
+			},
+		}).
+		Return(nil).
+		Times(1)
+
+	g, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithAttributes(map[string][]string{
+		"example.com/changed": {"new"},
+		"example.com/new":     {"a", "b"},
+		"billing-entity":      nil,
+	}, "example.com/"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"example.com/changed": {"new"},
+		"example.com/new":     {"a", "b"},
+		"unmanaged":           {"keep"},
+	}, g.Attributes())
+}
+
+func TestPutGroup_attributes_unchanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	existing := newGocloakGroup("Foo Inc.", "foo-id", "foo-gmbh")
+	(*existing.Attributes)["example.com/label"] = []string{"value"}
+
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", existing)
+	mockGetGroupMembers(mKeycloak, c, "foo-id", []*gocloak.User{})
+
+	_, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithAttributes(map[string][]string{
+		"example.com/label": {"value"},
+	}, "example.com/"))
+	require.NoError(t, err)
+}
+
+func TestPutGroup_new_with_attributes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", nil)
+	mKeycloak.EXPECT().
+		CreateGroup(gomock.Any(), "token", c.Realm, gocloak.Group{
+			Name: gocloak.StringP("foo-gmbh"),
+			Path: gocloak.StringP("/foo-gmbh"),
+			Attributes: &map[string][]string{
+				"displayName":       {"Foo Inc."},
+				"example.com/label": {"value"},
+			},
+		}).
+		Return("foo-id", nil).
+		Times(1)
+
+	_, err := c.PutGroup(context.TODO(), NewGroup("Foo Inc.", "foo-gmbh").WithAttributes(map[string][]string{
+		"example.com/label": {"value"},
+		"example.com/empty": {},
+	}, "example.com/"))
+	require.NoError(t, err)
+}
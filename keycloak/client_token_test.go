@@ -0,0 +1,177 @@
+package keycloak_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	gocloak "github.com/Nerzal/gocloak/v13"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestToken_SharedAcrossConcurrentCalls(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	mKeycloak.EXPECT().
+		LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+		Return(&gocloak.JWT{
+			AccessToken:      "token",
+			RefreshToken:     "refresh",
+			ExpiresIn:        300,
+			RefreshExpiresIn: 1800,
+		}, nil).
+		Times(1)
+	mKeycloak.EXPECT().
+		GetServerInfo(gomock.Any(), "token").
+		Return(&gocloak.ServerInfoRepresentation{
+			SystemInfo: &gocloak.SystemInfoRepresentation{Version: gocloak.StringP("22.0.0")},
+		}, nil).
+		AnyTimes()
+	mKeycloak.EXPECT().
+		GetGroups(gomock.Any(), "token", "target-realm", gomock.Any()).
+		Return([]*gocloak.Group{}, nil).
+		AnyTimes()
+
+	// Every reconciler gets its own copy of the client
+	clients := []Client{c, c, c, c}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(c Client) {
+			defer wg.Done()
+			_, err := c.ListGroups(context.Background())
+			assert.NoError(t, err)
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+}
+
+func TestToken_Refresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	mKeycloak.EXPECT().
+		LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+		Return(&gocloak.JWT{
+			AccessToken:      "expiring",
+			RefreshToken:     "refresh",
+			ExpiresIn:        1,
+			RefreshExpiresIn: 1800,
+		}, nil).
+		Times(1)
+	mKeycloak.EXPECT().
+		RefreshToken(gomock.Any(), "refresh", "admin-cli", "", "target-realm").
+		Return(&gocloak.JWT{
+			AccessToken:      "token",
+			RefreshToken:     "refresh",
+			ExpiresIn:        300,
+			RefreshExpiresIn: 1800,
+		}, nil).
+		Times(1)
+	mockGetUsers(mKeycloak, c, "expiring-user", []*gocloak.User{})
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{})
+	mKeycloak.EXPECT().
+		GetUsers(gomock.Any(), "expiring", c.Realm, gomock.Any()).
+		Return([]*gocloak.User{}, nil).
+		Times(1)
+
+	_, err := c.PutUser(context.Background(), User{Username: "expiring-user"})
+	require.ErrorIs(t, err, UserNotFoundError{})
+	_, err = c.PutUser(context.Background(), User{Username: "expiring-user"})
+	require.ErrorIs(t, err, UserNotFoundError{})
+	_, err = c.PutUser(context.Background(), User{Username: "user"})
+	require.ErrorIs(t, err, UserNotFoundError{})
+}
+
+func TestToken_LoginOnUnauthorized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	gomock.InOrder(
+		mKeycloak.EXPECT().
+			LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+			Return(&gocloak.JWT{AccessToken: "revoked", ExpiresIn: 300}, nil),
+		mKeycloak.EXPECT().
+			GetUsers(gomock.Any(), "revoked", c.Realm, gomock.Any()).
+			Return(nil, &gocloak.APIError{Code: http.StatusUnauthorized, Message: "401 Unauthorized"}),
+		mKeycloak.EXPECT().
+			LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+			Return(&gocloak.JWT{AccessToken: "token", ExpiresIn: 300}, nil),
+	)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{})
+
+	_, err := c.PutUser(context.Background(), User{Username: "user"})
+	require.ErrorIs(t, err, UserNotFoundError{})
+}
+
+func TestToken_Close(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	mKeycloak.EXPECT().
+		LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+		Return(&gocloak.JWT{AccessToken: "token", RefreshToken: "refresh", ExpiresIn: 300}, nil).
+		Times(1)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{})
+	mKeycloak.EXPECT().
+		LogoutPublicClient(gomock.Any(), "admin-cli", "target-realm", "token", "refresh").
+		Return(nil).
+		Times(1)
+
+	_, err := c.PutUser(context.Background(), User{Username: "user"})
+	require.ErrorIs(t, err, UserNotFoundError{})
+
+	require.NoError(t, c.Close(context.Background()))
+	require.NoError(t, c.Close(context.Background()), "second close is a no-op")
+}
+
+func TestToken_LogoutReplacedSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := NewClient("https://example.com", "target-realm", "admin", "secret")
+	c.Client = mKeycloak
+
+	gomock.InOrder(
+		mKeycloak.EXPECT().
+			LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+			Return(&gocloak.JWT{AccessToken: "revoked", RefreshToken: "revoked-refresh", ExpiresIn: 300, RefreshExpiresIn: 1800}, nil),
+		mKeycloak.EXPECT().
+			GetUsers(gomock.Any(), "revoked", c.Realm, gomock.Any()).
+			Return(nil, &gocloak.APIError{Code: http.StatusUnauthorized, Message: "401 Unauthorized"}),
+		mKeycloak.EXPECT().
+			LoginAdmin(gomock.Any(), "admin", "secret", "target-realm").
+			Return(&gocloak.JWT{AccessToken: "token", RefreshToken: "refresh", ExpiresIn: 300, RefreshExpiresIn: 1800}, nil),
+		mKeycloak.EXPECT().
+			LogoutPublicClient(gomock.Any(), "admin-cli", "target-realm", "revoked", "revoked-refresh").
+			Return(&gocloak.APIError{Code: http.StatusBadRequest, Message: "400 Session not active"}),
+	)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{})
+
+	_, err := c.PutUser(context.Background(), User{Username: "user"})
+	require.ErrorIs(t, err, UserNotFoundError{}, "failure to end the replaced session is ignored")
+}
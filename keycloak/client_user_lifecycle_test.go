@@ -0,0 +1,174 @@
+package keycloak_test
+
+import (
+	context "context"
+	"net/http"
+	"testing"
+
+	gocloak "github.com/Nerzal/gocloak/v13"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestRemoveUserFromGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:    mKeycloak,
+		Realm:     "foo",
+		RootGroup: "root-group",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUser(mKeycloak, c, "user", "user-id")
+	mKeycloak.EXPECT().
+		GetUserGroups(gomock.Any(), "token", c.Realm, "user-id", gocloak.GetGroupsParams{
+			First:               gocloak.IntP(0),
+			Max:                 gocloak.IntP(100),
+			BriefRepresentation: gocloak.BoolP(false),
+		}).
+		Return([]*gocloak.Group{
+			newGocloakGroup("", "org-id", "root-group", "org"),
+			newGocloakGroup("", "team-id", "root-group", "org", "team"),
+			newGocloakGroup("", "other-id", "other"),
+		}, nil).
+		Times(1)
+	mockRemoveUser(mKeycloak, c, "user-id", "org-id")
+	mockRemoveUser(mKeycloak, c, "user-id", "team-id")
+
+	require.NoError(t, c.RemoveUserFromGroups(context.TODO(), "user"))
+}
+
+func TestRemoveUserFromGroups_notFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{})
+
+	require.NoError(t, c.RemoveUserFromGroups(context.TODO(), "user"))
+}
+
+func TestDisableUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{
+		{ID: gocloak.StringP("user-id"), Username: gocloak.StringP("user"), Enabled: gocloak.BoolP(true)},
+	})
+	mockUpdateUser(mKeycloak, c, gocloak.User{
+		ID:       gocloak.StringP("user-id"),
+		Username: gocloak.StringP("user"),
+		Enabled:  gocloak.BoolP(false),
+	})
+
+	require.NoError(t, c.DisableUser(context.TODO(), "user"))
+}
+
+func TestDisableUser_alreadyDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{
+		{ID: gocloak.StringP("user-id"), Username: gocloak.StringP("user"), Enabled: gocloak.BoolP(false)},
+	})
+
+	require.NoError(t, c.DisableUser(context.TODO(), "user"))
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUser(mKeycloak, c, "user", "user-id")
+	mKeycloak.EXPECT().
+		DeleteUser(gomock.Any(), "token", c.Realm, "user-id").
+		Return(&gocloak.APIError{Code: http.StatusNotFound}).
+		Times(1)
+
+	require.NoError(t, c.DeleteUser(context.TODO(), "user"), "user deleted concurrently")
+}
+
+func TestDeleteUser_notFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUsers(mKeycloak, c, "user", []*gocloak.User{
+		{ID: gocloak.StringP("other-id"), Username: gocloak.StringP("user-2")},
+	})
+
+	require.NoError(t, c.DeleteUser(context.TODO(), "user"))
+}
+
+func TestListUsers_paginated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:   mKeycloak,
+		Realm:    "foo",
+		PageSize: 2,
+	}
+	mockLogin(mKeycloak, c)
+	for first, users := range map[int][]*gocloak.User{
+		0: {
+			{ID: gocloak.StringP("1"), Username: gocloak.StringP("user-1"), Email: gocloak.StringP("user-1@example.com")},
+			{ID: gocloak.StringP("2"), Username: gocloak.StringP("user-2"), Attributes: &map[string][]string{
+				KeycloakDefaultOrganizationRef: {"foo"},
+			}},
+		},
+		2: {
+			{ID: gocloak.StringP("3"), Username: gocloak.StringP("user-3"), FirstName: gocloak.StringP("Three")},
+		},
+	} {
+		mKeycloak.EXPECT().
+			GetUsers(gomock.Any(), "token", c.Realm, gocloak.GetUsersParams{
+				First:               gocloak.IntP(first),
+				Max:                 gocloak.IntP(2),
+				BriefRepresentation: gocloak.BoolP(false),
+			}).
+			Return(users, nil).
+			Times(1)
+	}
+
+	users, err := c.ListUsers(context.TODO())
+	require.NoError(t, err)
+	require.Equal(t, []User{
+		{ID: "1", Username: "user-1", Email: "user-1@example.com"},
+		{ID: "2", Username: "user-2", DefaultOrganizationRef: "foo"},
+		{ID: "3", Username: "user-3", FirstName: "Three"},
+	}, users)
+}
@@ -0,0 +1,109 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
+
+// ErrorKind classifies errors returned by the Keycloak API.
+// Errors returned by the client can be matched against the kinds using errors.Is.
+type ErrorKind = idp.ErrorKind
+
+const (
+	// ErrNotFound indicates that the requested resource does not exist.
+	ErrNotFound = idp.ErrNotFound
+	// ErrConflict indicates that the resource conflicts with an existing one.
+	ErrConflict = idp.ErrConflict
+	// ErrForbidden indicates that the client is not allowed to access the resource.
+	ErrForbidden = idp.ErrForbidden
+	// ErrUnauthorized indicates that Keycloak rejected the credentials or token of the client.
+	ErrUnauthorized = idp.ErrUnauthorized
+	// ErrRateLimited indicates that Keycloak rejected the request because too many requests were sent.
+	ErrRateLimited = idp.ErrRateLimited
+	// ErrTransient indicates a network error or a server error, which might go away on retry.
+	ErrTransient = idp.ErrTransient
+)
+
+// Error is an error returned by the Keycloak API together with its kind.
+type Error = idp.Error
+
+// KindOf returns the kind of the given error, or an empty kind if the error can't be classified.
+// Unlike idp.KindOf, it also classifies errors returned by gocloak which were not wrapped yet.
+func KindOf(err error) ErrorKind {
+	if kind := idp.KindOf(err); kind != "" {
+		return kind
+	}
+	return classify(err)
+}
+
+// IsRetryable returns true if the error might go away when retrying the request later, see idp.ErrorKind.Retryable.
+func IsRetryable(err error) bool {
+	return KindOf(err).Retryable()
+}
+
+// IsPermanent returns true if retrying the request without changing it won't succeed, see idp.ErrorKind.Permanent.
+func IsPermanent(err error) bool {
+	return KindOf(err).Permanent()
+}
+
+// classify returns the kind of an error returned by gocloak.
+// gocloak reports network errors as API errors without status code.
+func classify(err error) ErrorKind {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ""
+	}
+
+	var apiErr *gocloak.APIError
+	if errors.As(err, &apiErr) {
+		switch code := apiErr.Code; {
+		case code == 0:
+			return ErrTransient
+		case code == http.StatusNotFound:
+			return ErrNotFound
+		case code == http.StatusConflict:
+			return ErrConflict
+		case code == http.StatusForbidden:
+			return ErrForbidden
+		case code == http.StatusUnauthorized:
+			return ErrUnauthorized
+		case code == http.StatusTooManyRequests:
+			return ErrRateLimited
+		case code == http.StatusInternalServerError, code == http.StatusBadGateway,
+			code == http.StatusServiceUnavailable, code == http.StatusGatewayTimeout:
+			return ErrTransient
+		}
+		return ""
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrTransient
+	}
+	return ""
+}
+
+// classified wraps the given error with its kind, if it can be classified.
+func classified(err error) error {
+	var kcErr *Error
+	if errors.As(err, &kcErr) {
+		return err
+	}
+	if kind := classify(err); kind != "" {
+		return &Error{Kind: kind, Err: err}
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	return KindOf(err) == ErrNotFound
+}
+
+func isUnauthorized(err error) bool {
+	return KindOf(err) == ErrUnauthorized
+}
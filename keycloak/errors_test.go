@@ -0,0 +1,184 @@
+package keycloak_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	gocloak "github.com/Nerzal/gocloak/v13"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestKindOf(t *testing.T) {
+	tcs := map[string]struct {
+		err       error
+		kind      ErrorKind
+		retryable bool
+		permanent bool
+	}{
+		"nil": {},
+		"unknown": {
+			err: errors.New("unknown"),
+		},
+		"bad request": {
+			err: &gocloak.APIError{Code: http.StatusBadRequest},
+		},
+		"network error": {
+			err:       &gocloak.APIError{Code: 0, Message: "connection refused"},
+			kind:      ErrTransient,
+			retryable: true,
+		},
+		"net.Error": {
+			err:       &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			kind:      ErrTransient,
+			retryable: true,
+		},
+		"not found": {
+			err:       &gocloak.APIError{Code: http.StatusNotFound},
+			kind:      ErrNotFound,
+			permanent: true,
+		},
+		"conflict": {
+			err:  &gocloak.APIError{Code: http.StatusConflict},
+			kind: ErrConflict,
+		},
+		"forbidden": {
+			err:  &gocloak.APIError{Code: http.StatusForbidden},
+			kind: ErrForbidden,
+		},
+		"unauthorized": {
+			err:  &gocloak.APIError{Code: http.StatusUnauthorized},
+			kind: ErrUnauthorized,
+		},
+		"rate limited": {
+			err:       &gocloak.APIError{Code: http.StatusTooManyRequests},
+			kind:      ErrRateLimited,
+			retryable: true,
+		},
+		"unavailable": {
+			err:       &gocloak.APIError{Code: http.StatusServiceUnavailable},
+			kind:      ErrTransient,
+			retryable: true,
+		},
+		"wrapped": {
+			err:       fmt.Errorf("failed finding group: %w", &Error{Kind: ErrNotFound, Err: errors.New("404 Not Found")}),
+			kind:      ErrNotFound,
+			permanent: true,
+		},
+		"cancelled": {
+			err: context.Canceled,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.kind, KindOf(tc.err))
+			assert.Equal(t, tc.retryable, IsRetryable(tc.err))
+			assert.Equal(t, tc.permanent, IsPermanent(tc.err))
+		})
+	}
+}
+
+func TestRetry_transient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:       mKeycloak,
+		Realm:        "foo",
+		RetryBackoff: time.Millisecond,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	gomock.InOrder(
+		mKeycloak.EXPECT().
+			GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+			Return(nil, &gocloak.APIError{Code: http.StatusServiceUnavailable}),
+		mKeycloak.EXPECT().
+			GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+			Return(nil, &gocloak.APIError{Code: http.StatusTooManyRequests}),
+		mKeycloak.EXPECT().
+			GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+			Return([]*gocloak.Group{}, nil),
+	)
+
+	groups, err := c.ListGroups(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestRetry_exhausted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:       mKeycloak,
+		Realm:        "foo",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	}
+	mockLogin(mKeycloak, c)
+	mKeycloak.EXPECT().
+		GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+		Return(nil, &gocloak.APIError{Code: http.StatusBadGateway}).
+		Times(3)
+
+	_, err := c.ListGroups(context.Background())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrTransient)
+	assert.True(t, IsRetryable(err))
+}
+
+func TestRetry_createNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:       mKeycloak,
+		Realm:        "foo",
+		RetryBackoff: time.Millisecond,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	mockGetGroupByPath(mKeycloak, c, "foo-gmbh", nil)
+	mKeycloak.EXPECT().
+		CreateGroup(gomock.Any(), "token", "foo", gomock.Any()).
+		Return("", &gocloak.APIError{Code: http.StatusBadGateway}).
+		Times(1)
+
+	_, err := c.PutGroup(context.Background(), NewGroup("Foo Inc.", "foo-gmbh"))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrTransient, "the group might have been created, so the request is not retried")
+}
+
+func TestRetry_forbiddenNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:       mKeycloak,
+		Realm:        "foo",
+		RetryBackoff: time.Millisecond,
+	}
+	mockLogin(mKeycloak, c)
+	mKeycloak.EXPECT().
+		GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+		Return(nil, &gocloak.APIError{Code: http.StatusForbidden}).
+		Times(1)
+
+	_, err := c.ListGroups(context.Background())
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.False(t, IsRetryable(err))
+}
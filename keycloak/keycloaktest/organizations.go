@@ -0,0 +1,315 @@
+package keycloaktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Organization is a Keycloak organization as stored by the server.
+type Organization struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name"`
+	Alias       string               `json:"alias"`
+	Enabled     bool                 `json:"enabled"`
+	Description string               `json:"description,omitempty"`
+	Attributes  map[string][]string  `json:"attributes,omitempty"`
+	Domains     []OrganizationDomain `json:"domains,omitempty"`
+}
+
+// OrganizationDomain is a domain of an organization.
+type OrganizationDomain struct {
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
+}
+
+// AddOrganization creates an enabled organization with the given name and display name attribute and returns its ID.
+// If the organization already exists only its display name is updated.
+func (s *Server) AddOrganization(displayName, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.organizationByName(name)
+	if o == nil {
+		o = s.createOrganization(Organization{Name: name, Enabled: true})
+	}
+	if displayName != "" {
+		o.Attributes["displayName"] = []string{displayName}
+	}
+	return o.ID
+}
+
+// AddOrganizationMember adds the user with the given username to the organization with the given name.
+// The user and the organization must exist.
+func (s *Server) AddOrganizationMember(username, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.organizationByName(name)
+	u := s.userByName(username)
+	if o == nil || u == nil {
+		panic(fmt.Sprintf("keycloaktest: organization %q or user %q not found", name, username))
+	}
+	s.addOrganizationMember(o.ID, *u.ID)
+}
+
+// SetOrganizationAttribute sets the values of an attribute of the organization with the given name, or removes the attribute if there are no values.
+// The organization must exist.
+func (s *Server) SetOrganizationAttribute(name, attribute string, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.organizationByName(name)
+	if o == nil {
+		panic(fmt.Sprintf("keycloaktest: organization %q not found", name))
+	}
+	if len(values) == 0 {
+		delete(o.Attributes, attribute)
+		return
+	}
+	if o.Attributes == nil {
+		o.Attributes = map[string][]string{}
+	}
+	o.Attributes[attribute] = append([]string(nil), values...)
+}
+
+// Organization returns the organization with the given name.
+func (s *Server) Organization(name string) (Organization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.organizationByName(name)
+	if o == nil {
+		return Organization{}, false
+	}
+	return copyOrganization(*o), true
+}
+
+// OrganizationNames returns the names of all organizations, sorted.
+func (s *Server) OrganizationNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.orgs))
+	for _, o := range s.sortedOrganizations() {
+		names = append(names, o.Name)
+	}
+	return names
+}
+
+// OrganizationMembers returns the usernames of the members of the organization with the given name, sorted.
+// Returns nil if the organization does not exist.
+func (s *Server) OrganizationMembers(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.organizationByName(name)
+	if o == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.orgMembers[o.ID]))
+	for _, u := range s.organizationMembers(o.ID) {
+		names = append(names, *u.Username)
+	}
+	return names
+}
+
+func (s *Server) serveOrganizations(w http.ResponseWriter, r *http.Request, segments []string) {
+	q := r.URL.Query()
+	switch {
+	case len(segments) == 0:
+		switch r.Method {
+		case http.MethodGet:
+			s.listOrganizations(w, q)
+		case http.MethodPost:
+			s.postOrganization(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case len(segments) == 3 && segments[0] == "members" && segments[2] == "organizations" && r.Method == http.MethodGet:
+		if _, ok := s.users[segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		res := make([]Organization, 0)
+		for _, o := range s.sortedOrganizations() {
+			if _, ok := s.orgMembers[o.ID][segments[1]]; ok {
+				rep := copyOrganization(*o)
+				if isBrief(q, true) {
+					rep.Attributes = nil
+				}
+				res = append(res, rep)
+			}
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		o, ok := s.orgs[segments[0]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Organization not found")
+			return
+		}
+		s.serveOrganization(w, r, o, segments[1:])
+	}
+}
+
+func (s *Server) serveOrganization(w http.ResponseWriter, r *http.Request, o *Organization, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, copyOrganization(*o))
+	case len(segments) == 0 && r.Method == http.MethodPut:
+		var rep Organization
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if rep.Name != "" && rep.Name != o.Name {
+			if s.organizationByName(rep.Name) != nil {
+				writeError(w, http.StatusConflict, "A organization with the same name already exists.")
+				return
+			}
+			o.Name = rep.Name
+		}
+		o.Enabled = rep.Enabled
+		o.Description = rep.Description
+		o.Attributes = copyAttributes(rep.Attributes)
+		if rep.Domains != nil {
+			o.Domains = append([]OrganizationDomain(nil), rep.Domains...)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 0 && r.Method == http.MethodDelete:
+		delete(s.orgs, o.ID)
+		delete(s.orgMembers, o.ID)
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 1 && segments[0] == "members" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, page(s.organizationMembers(o.ID), r.URL.Query()))
+	case len(segments) == 1 && segments[0] == "members" && r.Method == http.MethodPost:
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Keycloak accepts the user ID both as a JSON string and as plain text.
+		var userID string
+		if err := json.Unmarshal(raw, &userID); err != nil {
+			userID = strings.TrimSpace(string(raw))
+		}
+		if _, ok := s.users[userID]; !ok {
+			writeError(w, http.StatusNotFound, "User does not exist")
+			return
+		}
+		if _, ok := s.orgMembers[o.ID][userID]; ok {
+			writeError(w, http.StatusConflict, "User is already a member of the organization.")
+			return
+		}
+		s.addOrganizationMember(o.ID, userID)
+		w.WriteHeader(http.StatusCreated)
+	case len(segments) == 2 && segments[0] == "members" && r.Method == http.MethodDelete:
+		if _, ok := s.orgMembers[o.ID][segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "Not a member of the organization")
+			return
+		}
+		delete(s.orgMembers[o.ID], segments[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// listOrganizations lists the organizations whose name contains the `search` parameter, or matches it if `exact` is set.
+func (s *Server) listOrganizations(w http.ResponseWriter, q url.Values) {
+	search, searching := q["search"]
+	exact := q.Get("exact") == "true"
+
+	res := make([]Organization, 0)
+	for _, o := range s.sortedOrganizations() {
+		if searching {
+			name, want := strings.ToLower(o.Name), strings.ToLower(search[0])
+			if exact && name != want || !exact && !strings.Contains(name, want) {
+				continue
+			}
+		}
+		rep := copyOrganization(*o)
+		if isBrief(q, true) {
+			rep.Attributes = nil
+		}
+		res = append(res, rep)
+	}
+	writeJSON(w, http.StatusOK, page(res, q))
+}
+
+func (s *Server) postOrganization(w http.ResponseWriter, r *http.Request) {
+	var rep Organization
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil || rep.Name == "" {
+		writeError(w, http.StatusBadRequest, "Name can not be null")
+		return
+	}
+	// Keycloak 25 requires at least one domain, later versions don't.
+	if strings.HasPrefix(s.version, "25.") && len(rep.Domains) == 0 {
+		writeError(w, http.StatusBadRequest, "You must provide at least one domain")
+		return
+	}
+	if s.organizationByName(rep.Name) != nil {
+		writeError(w, http.StatusConflict, "A organization with the same name already exists.")
+		return
+	}
+	o := s.createOrganization(rep)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/realms/%s/organizations/%s", s.URL, s.Realm, o.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) createOrganization(rep Organization) *Organization {
+	o := copyOrganization(rep)
+	o.ID = s.newID("org")
+	if o.Alias == "" {
+		o.Alias = o.Name
+	}
+	s.orgs[o.ID] = &o
+	return &o
+}
+
+func (s *Server) addOrganizationMember(orgID, userID string) {
+	if s.orgMembers[orgID] == nil {
+		s.orgMembers[orgID] = map[string]struct{}{}
+	}
+	s.orgMembers[orgID][userID] = struct{}{}
+}
+
+func (s *Server) organizationMembers(orgID string) []gocloak.User {
+	res := make([]gocloak.User, 0, len(s.orgMembers[orgID]))
+	for _, u := range s.sortedUsers() {
+		if _, ok := s.orgMembers[orgID][*u.ID]; ok {
+			res = append(res, copyUser(*u))
+		}
+	}
+	return res
+}
+
+func (s *Server) organizationByName(name string) *Organization {
+	for _, o := range s.orgs {
+		if o.Name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *Server) sortedOrganizations() []*Organization {
+	res := make([]*Organization, 0, len(s.orgs))
+	for _, o := range s.orgs {
+		res = append(res, o)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func copyOrganization(o Organization) Organization {
+	o.Attributes = copyAttributes(o.Attributes)
+	o.Domains = append([]OrganizationDomain(nil), o.Domains...)
+	return o
+}
@@ -0,0 +1,729 @@
+// Package keycloaktest provides an in-memory fake of the Keycloak admin REST API for end to end tests of the Keycloak client.
+package keycloaktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+// Server is an in-memory fake of the subset of the Keycloak admin REST API used by the adapter.
+// It keeps groups, users, organizations, and memberships of a single realm.
+//
+// The server emulates the differences between Keycloak versions the client relies on, see keycloak.Capabilities:
+// Servers without ChildGroupEndpoint return the full group tree when listing groups, newer servers only return top-level groups and serve their children separately.
+// Servers without GroupByPath don't serve groups by path, and servers without ExactSearch ignore the `exact` parameter when searching users.
+// Organizations are only served by servers with Organizations.
+type Server struct {
+	*httptest.Server
+
+	// Realm is the realm served by the server.
+	// Tokens can be requested from this realm and from the `master` realm.
+	Realm string
+
+	mu sync.Mutex
+
+	version string
+	caps    keycloak.Capabilities
+
+	admins  map[string]string
+	clients map[string]string
+
+	tokens map[string]struct{}
+	nextID int
+
+	groups  map[string]*group
+	users   map[string]*gocloak.User
+	members map[string]map[string]struct{}
+
+	orgs       map[string]*Organization
+	orgMembers map[string]map[string]struct{}
+}
+
+type group struct {
+	id         string
+	name       string
+	parentID   string
+	attributes map[string][]string
+}
+
+// NewServer starts a new fake Keycloak server of the given version serving the given realm.
+// The server must be closed by calling Close.
+func NewServer(realm, version string) *Server {
+	s := &Server{
+		Realm:   realm,
+		version: version,
+		caps:    keycloak.CapabilitiesForVersion(version),
+		admins:  map[string]string{},
+		clients: map[string]string{},
+		tokens:  map[string]struct{}{},
+		groups:  map[string]*group{},
+		users:   map[string]*gocloak.User{},
+		members: map[string]map[string]struct{}{},
+
+		orgs:       map[string]*Organization{},
+		orgMembers: map[string]map[string]struct{}{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewClient returns a Keycloak client for the server, authenticating with the given admin credentials.
+// The admin is added to the server if necessary.
+func (s *Server) NewClient(username, password string) keycloak.Client {
+	s.AddAdmin(username, password)
+	return keycloak.NewClient(s.URL, s.Realm, username, password)
+}
+
+// AddAdmin allows logging in with the given username and password.
+func (s *Server) AddAdmin(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.admins[username] = password
+}
+
+// AddServiceAccount allows logging in as the service account of the given client using the client credentials grant.
+// Signed JWT client assertions are accepted for the client without verifying them.
+func (s *Server) AddServiceAccount(clientID, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[clientID] = secret
+}
+
+// ExpireTokens invalidates all issued tokens.
+// Following requests with one of these tokens fail with `401 Unauthorized`.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]struct{}{}
+}
+
+// AddGroup creates the group with the given path and display name and returns its ID.
+// Missing parent groups are created without display name.
+// If the group already exists only its display name is updated.
+func (s *Server) AddGroup(displayName string, path ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parentID := ""
+	var g *group
+	for _, name := range path {
+		g = s.childByName(parentID, name)
+		if g == nil {
+			g = s.createGroup(parentID, gocloak.Group{Name: gocloak.StringP(name)})
+		}
+		parentID = g.id
+	}
+	if g == nil {
+		panic("keycloaktest: empty group path")
+	}
+	if displayName != "" {
+		g.attributes["displayName"] = []string{displayName}
+	}
+	return g.id
+}
+
+// AddUser creates the given user and returns its ID.
+// Users are enabled unless specified otherwise.
+func (s *Server) AddUser(user gocloak.User) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createUser(user)
+}
+
+// AddMember adds the user with the given username to the group with the given path.
+// The user and the group must exist.
+func (s *Server) AddMember(username string, path ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupByPath(path)
+	u := s.userByName(username)
+	if g == nil || u == nil {
+		panic(fmt.Sprintf("keycloaktest: group %v or user %q not found", path, username))
+	}
+	s.addMember(g.id, *u.ID)
+}
+
+// Group returns the group with the given path.
+func (s *Server) Group(path ...string) (gocloak.Group, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupByPath(path)
+	if g == nil {
+		return gocloak.Group{}, false
+	}
+	return s.groupRepresentation(g, false), true
+}
+
+// GroupPaths returns the paths of all groups, sorted.
+func (s *Server) GroupPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.groups))
+	for _, g := range s.groups {
+		paths = append(paths, s.path(g))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Members returns the usernames of the members of the group with the given path, sorted.
+// Returns nil if the group does not exist.
+func (s *Server) Members(path ...string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupByPath(path)
+	if g == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.members[g.id]))
+	for _, u := range s.groupMembers(g.id) {
+		names = append(names, *u.Username)
+	}
+	return names
+}
+
+// User returns the user with the given username.
+func (s *Server) User(username string) (gocloak.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByName(username)
+	if u == nil {
+		return gocloak.User{}, false
+	}
+	return copyUser(*u), true
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments := pathSegments(r.URL)
+	switch {
+	case len(segments) == 5 && segments[0] == "realms" && strings.Join(segments[2:], "/") == "protocol/openid-connect/token":
+		s.serveToken(w, r, segments[1])
+	case len(segments) == 5 && segments[0] == "realms" && strings.Join(segments[2:], "/") == "protocol/openid-connect/logout":
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 2 && segments[0] == "admin" && segments[1] == "serverinfo":
+		if s.authorize(w, r) {
+			writeJSON(w, http.StatusOK, gocloak.ServerInfoRepresentation{
+				SystemInfo: &gocloak.SystemInfoRepresentation{Version: gocloak.StringP(s.version)},
+			})
+		}
+	case len(segments) >= 4 && segments[0] == "admin" && segments[1] == "realms":
+		if segments[2] != s.Realm {
+			writeError(w, http.StatusNotFound, "Realm not found.")
+			return
+		}
+		if s.authorize(w, r) {
+			s.serveAdmin(w, r, segments[3:])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request, realm string) {
+	if realm != s.Realm && realm != "master" {
+		writeError(w, http.StatusNotFound, "Realm does not exist")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ok := false
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		pw, exists := s.admins[r.PostForm.Get("username")]
+		ok = exists && pw == r.PostForm.Get("password")
+	case "client_credentials":
+		secret, exists := s.clients[r.PostForm.Get("client_id")]
+		ok = exists && (r.PostForm.Get("client_assertion") != "" || secret == r.PostForm.Get("client_secret"))
+	case "refresh_token":
+		_, ok = s.tokens[strings.TrimPrefix(r.PostForm.Get("refresh_token"), "refresh-")]
+	}
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := s.newID("token")
+	s.tokens[token] = struct{}{}
+	writeJSON(w, http.StatusOK, gocloak.JWT{
+		AccessToken:      token,
+		RefreshToken:     "refresh-" + token,
+		ExpiresIn:        300,
+		RefreshExpiresIn: 1800,
+		TokenType:        "Bearer",
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "HTTP 401 Unauthorized")
+	}
+	return ok
+}
+
+func (s *Server) serveAdmin(w http.ResponseWriter, r *http.Request, segments []string) {
+	q := r.URL.Query()
+	switch {
+	case segments[0] == "groups" && len(segments) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			s.listGroups(w, q)
+		case http.MethodPost:
+			s.postGroup(w, r, "")
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case segments[0] == "groups" && len(segments) == 2:
+		g, ok := s.groups[segments[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Could not find group by id")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s.groupRepresentation(g, !s.caps.ChildGroupEndpoint))
+		case http.MethodPut:
+			s.putGroup(w, r, g)
+		case http.MethodDelete:
+			s.deleteGroup(g.id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case segments[0] == "groups" && len(segments) == 3 && segments[2] == "children":
+		if _, ok := s.groups[segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "Could not find group by id")
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost:
+			s.postGroup(w, r, segments[1])
+		case r.Method == http.MethodGet && s.caps.ChildGroupEndpoint:
+			children := s.children(segments[1])
+			res := make([]gocloak.Group, 0, len(children))
+			for _, c := range children {
+				res = append(res, s.briefGroup(s.groupRepresentation(c, false), isBrief(q, false)))
+			}
+			writeJSON(w, http.StatusOK, page(res, q))
+		default:
+			writeError(w, http.StatusNotFound, "Not found")
+		}
+	case segments[0] == "groups" && len(segments) == 3 && segments[2] == "members" && r.Method == http.MethodGet:
+		if _, ok := s.groups[segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "Could not find group by id")
+			return
+		}
+		writeJSON(w, http.StatusOK, page(s.groupMembers(segments[1]), q))
+	case segments[0] == "group-by-path" && s.caps.GroupByPath && r.Method == http.MethodGet:
+		g := s.groupByPath(segments[1:])
+		if g == nil {
+			writeError(w, http.StatusNotFound, "Group path does not exist")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.groupRepresentation(g, !s.caps.ChildGroupEndpoint))
+	case segments[0] == "organizations" && s.caps.Organizations:
+		s.serveOrganizations(w, r, segments[1:])
+	case segments[0] == "users" && len(segments) == 1 && r.Method == http.MethodGet:
+		s.listUsers(w, q)
+	case segments[0] == "users" && len(segments) >= 2:
+		u, ok := s.users[segments[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		s.serveUser(w, r, u, segments[2:])
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) serveUser(w http.ResponseWriter, r *http.Request, u *gocloak.User, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, copyUser(*u))
+	case len(segments) == 0 && r.Method == http.MethodPut:
+		var update gocloak.User
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updateUser(u, update)
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 0 && r.Method == http.MethodDelete:
+		delete(s.users, *u.ID)
+		for _, m := range s.members {
+			delete(m, *u.ID)
+		}
+		for _, m := range s.orgMembers {
+			delete(m, *u.ID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(segments) == 1 && segments[0] == "groups" && r.Method == http.MethodGet:
+		var res []gocloak.Group
+		for _, g := range s.sortedGroups() {
+			if _, ok := s.members[g.id][*u.ID]; ok {
+				res = append(res, s.briefGroup(s.groupRepresentation(g, false), isBrief(r.URL.Query(), true)))
+			}
+		}
+		writeJSON(w, http.StatusOK, page(res, r.URL.Query()))
+	case len(segments) == 2 && segments[0] == "groups" && (r.Method == http.MethodPut || r.Method == http.MethodDelete):
+		if _, ok := s.groups[segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "Could not find group by id")
+			return
+		}
+		if r.Method == http.MethodPut {
+			s.addMember(segments[1], *u.ID)
+		} else {
+			delete(s.members[segments[1]], *u.ID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// listGroups lists the top-level groups.
+// Servers without ChildGroupEndpoint include all sub groups.
+// When searching, only groups with a matching name and their ancestors are returned, including all sub groups of matching groups.
+func (s *Server) listGroups(w http.ResponseWriter, q url.Values) {
+	brief := isBrief(q, true)
+	search, searching := q["search"]
+
+	res := make([]gocloak.Group, 0)
+	for _, g := range s.children("") {
+		rep := s.groupRepresentation(g, searching || !s.caps.ChildGroupEndpoint)
+		if searching {
+			var ok bool
+			rep, ok = filterGroup(rep, strings.ToLower(search[0]))
+			if !ok {
+				continue
+			}
+		}
+		res = append(res, s.briefGroup(rep, brief))
+	}
+	writeJSON(w, http.StatusOK, page(res, q))
+}
+
+func (s *Server) postGroup(w http.ResponseWriter, r *http.Request, parentID string) {
+	var rep gocloak.Group
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil || rep.Name == nil || *rep.Name == "" {
+		writeError(w, http.StatusBadRequest, "Group name is missing")
+		return
+	}
+	if s.childByName(parentID, *rep.Name) != nil {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Top level group named '%s' already exists.", *rep.Name))
+		return
+	}
+	g := s.createGroup(parentID, rep)
+	w.Header().Set("Location", fmt.Sprintf("%s/admin/realms/%s/groups/%s", s.URL, s.Realm, g.id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) putGroup(w http.ResponseWriter, r *http.Request, g *group) {
+	var rep gocloak.Group
+	if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rep.Name != nil && *rep.Name != g.name {
+		if s.childByName(g.parentID, *rep.Name) != nil {
+			writeError(w, http.StatusConflict, fmt.Sprintf("Sibling group named '%s' already exists.", *rep.Name))
+			return
+		}
+		g.name = *rep.Name
+	}
+	if rep.Attributes != nil {
+		g.attributes = copyAttributes(*rep.Attributes)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, q url.Values) {
+	username, byName := q["username"]
+	exact := s.caps.ExactSearch && q.Get("exact") == "true"
+
+	res := make([]gocloak.User, 0)
+	for _, u := range s.sortedUsers() {
+		if byName {
+			name, want := strings.ToLower(*u.Username), strings.ToLower(username[0])
+			if exact && name != want || !exact && !strings.Contains(name, want) {
+				continue
+			}
+		}
+		rep := copyUser(*u)
+		if isBrief(q, false) {
+			rep.Attributes = nil
+		}
+		res = append(res, rep)
+	}
+	writeJSON(w, http.StatusOK, page(res, q))
+}
+
+func (s *Server) createGroup(parentID string, rep gocloak.Group) *group {
+	g := &group{
+		id:         s.newID("group"),
+		name:       *rep.Name,
+		parentID:   parentID,
+		attributes: map[string][]string{},
+	}
+	if rep.Attributes != nil {
+		g.attributes = copyAttributes(*rep.Attributes)
+	}
+	s.groups[g.id] = g
+	return g
+}
+
+func (s *Server) deleteGroup(id string) {
+	for _, c := range s.children(id) {
+		s.deleteGroup(c.id)
+	}
+	delete(s.groups, id)
+	delete(s.members, id)
+}
+
+func (s *Server) createUser(user gocloak.User) string {
+	u := copyUser(user)
+	if u.ID == nil {
+		u.ID = gocloak.StringP(s.newID("user"))
+	}
+	if u.Enabled == nil {
+		u.Enabled = gocloak.BoolP(true)
+	}
+	s.users[*u.ID] = &u
+	return *u.ID
+}
+
+func (s *Server) addMember(groupID, userID string) {
+	if s.members[groupID] == nil {
+		s.members[groupID] = map[string]struct{}{}
+	}
+	s.members[groupID][userID] = struct{}{}
+}
+
+func (s *Server) groupMembers(groupID string) []gocloak.User {
+	res := make([]gocloak.User, 0, len(s.members[groupID]))
+	for _, u := range s.sortedUsers() {
+		if _, ok := s.members[groupID][*u.ID]; ok {
+			res = append(res, copyUser(*u))
+		}
+	}
+	return res
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+// groupRepresentation returns the representation of the given group, including all of its sub groups if requested.
+func (s *Server) groupRepresentation(g *group, subGroups bool) gocloak.Group {
+	attrs := copyAttributes(g.attributes)
+	rep := gocloak.Group{
+		ID:         gocloak.StringP(g.id),
+		Name:       gocloak.StringP(g.name),
+		Path:       gocloak.StringP(s.path(g)),
+		Attributes: &attrs,
+		SubGroups:  &[]gocloak.Group{},
+	}
+	if subGroups {
+		for _, c := range s.children(g.id) {
+			*rep.SubGroups = append(*rep.SubGroups, s.groupRepresentation(c, true))
+		}
+	}
+	return rep
+}
+
+// briefGroup removes the attributes of the given group and its sub groups if brief is set.
+func (s *Server) briefGroup(rep gocloak.Group, brief bool) gocloak.Group {
+	if !brief {
+		return rep
+	}
+	rep.Attributes = nil
+	subGroups := make([]gocloak.Group, len(*rep.SubGroups))
+	for i, sg := range *rep.SubGroups {
+		subGroups[i] = s.briefGroup(sg, true)
+	}
+	rep.SubGroups = &subGroups
+	return rep
+}
+
+func (s *Server) path(g *group) string {
+	if g.parentID == "" {
+		return "/" + g.name
+	}
+	return s.path(s.groups[g.parentID]) + "/" + g.name
+}
+
+func (s *Server) children(parentID string) []*group {
+	var res []*group
+	for _, g := range s.sortedGroups() {
+		if g.parentID == parentID {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+func (s *Server) childByName(parentID, name string) *group {
+	for _, g := range s.groups {
+		if g.parentID == parentID && g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (s *Server) groupByPath(path []string) *group {
+	var g *group
+	parentID := ""
+	for _, name := range path {
+		if g = s.childByName(parentID, name); g == nil {
+			return nil
+		}
+		parentID = g.id
+	}
+	return g
+}
+
+func (s *Server) userByName(username string) *gocloak.User {
+	for _, u := range s.users {
+		if *u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) sortedGroups() []*group {
+	res := make([]*group, 0, len(s.groups))
+	for _, g := range s.groups {
+		res = append(res, g)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+func (s *Server) sortedUsers() []*gocloak.User {
+	res := make([]*gocloak.User, 0, len(s.users))
+	for _, u := range s.users {
+		res = append(res, u)
+	}
+	sort.Slice(res, func(i, j int) bool { return *res[i].Username < *res[j].Username })
+	return res
+}
+
+// filterGroup returns the group if its name contains the search string, or the group with only the sub groups containing a match.
+func filterGroup(rep gocloak.Group, search string) (gocloak.Group, bool) {
+	if strings.Contains(strings.ToLower(*rep.Name), search) {
+		return rep, true
+	}
+	var subGroups []gocloak.Group
+	for _, sg := range *rep.SubGroups {
+		if filtered, ok := filterGroup(sg, search); ok {
+			subGroups = append(subGroups, filtered)
+		}
+	}
+	rep.SubGroups = &subGroups
+	return rep, len(subGroups) > 0
+}
+
+func updateUser(u *gocloak.User, update gocloak.User) {
+	if update.Email != nil {
+		u.Email = update.Email
+	}
+	if update.FirstName != nil {
+		u.FirstName = update.FirstName
+	}
+	if update.LastName != nil {
+		u.LastName = update.LastName
+	}
+	if update.Enabled != nil {
+		u.Enabled = update.Enabled
+	}
+	if update.Attributes != nil {
+		attrs := copyAttributes(*update.Attributes)
+		u.Attributes = &attrs
+	}
+}
+
+func copyUser(u gocloak.User) gocloak.User {
+	if u.Attributes != nil {
+		attrs := copyAttributes(*u.Attributes)
+		u.Attributes = &attrs
+	}
+	return u
+}
+
+func copyAttributes(attrs map[string][]string) map[string][]string {
+	res := make(map[string][]string, len(attrs))
+	for k, v := range attrs {
+		res[k] = append([]string(nil), v...)
+	}
+	return res
+}
+
+// isBrief returns the value of the `briefRepresentation` query parameter, or the given default.
+func isBrief(q url.Values, def bool) bool {
+	if v, err := strconv.ParseBool(q.Get("briefRepresentation")); err == nil {
+		return v
+	}
+	return def
+}
+
+// page returns the page of the given items selected by the `first` and `max` query parameters.
+// A negative or missing `max` returns all remaining items.
+func page[T any](items []T, q url.Values) []T {
+	first, _ := strconv.Atoi(q.Get("first"))
+	if first > len(items) {
+		first = len(items)
+	}
+	items = items[first:]
+	if max, err := strconv.Atoi(q.Get("max")); err == nil && max >= 0 && max < len(items) {
+		items = items[:max]
+	}
+	return items
+}
+
+// pathSegments returns the unescaped segments of the path of the given URL.
+func pathSegments(u *url.URL) []string {
+	var segments []string
+	for _, s := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"errorMessage": msg})
+}
@@ -0,0 +1,54 @@
+package keycloak
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "appuio_keycloak_adapter"
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "keycloak",
+		Name:      "requests_total",
+		Help:      "Total number of requests to the Keycloak API by method and status class. Retries are counted as separate requests.",
+	}, []string{"method", "status_class"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "keycloak",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the Keycloak API by method and status class.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "status_class"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal, requestDuration)
+}
+
+// observeRequest records a single request to the Keycloak API.
+func observeRequest(method string, start time.Time, err error) {
+	class := statusClass(err)
+	requestsTotal.WithLabelValues(method, class).Inc()
+	requestDuration.WithLabelValues(method, class).Observe(time.Since(start).Seconds())
+}
+
+// statusClass returns the class of the HTTP status code of the response, such as `2xx` or `5xx`.
+// Returns `error` if the request failed without a response.
+func statusClass(err error) string {
+	if err == nil {
+		return "2xx"
+	}
+	var apiErr *gocloak.APIError
+	if errors.As(err, &apiErr) && apiErr.Code >= 100 {
+		return fmt.Sprintf("%dxx", apiErr.Code/100)
+	}
+	return "error"
+}
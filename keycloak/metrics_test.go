@@ -0,0 +1,67 @@
+package keycloak_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	gocloak "github.com/Nerzal/gocloak/v13"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestMetrics_requests(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client:       mKeycloak,
+		Realm:        "foo",
+		RetryBackoff: time.Millisecond,
+	}
+	mockLogin(mKeycloak, c)
+	mockGetServerInfo(mKeycloak, "22.0.0")
+	gomock.InOrder(
+		mKeycloak.EXPECT().
+			GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+			Return(nil, &gocloak.APIError{Code: http.StatusServiceUnavailable}),
+		mKeycloak.EXPECT().
+			GetGroups(gomock.Any(), "token", "foo", gomock.Any()).
+			Return([]*gocloak.Group{}, nil),
+	)
+
+	okBefore := requestCount(t, "GetGroups", "2xx")
+	failedBefore := requestCount(t, "GetGroups", "5xx")
+
+	_, err := c.ListGroups(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, okBefore+1, requestCount(t, "GetGroups", "2xx"))
+	assert.Equal(t, failedBefore+1, requestCount(t, "GetGroups", "5xx"))
+}
+
+func requestCount(t *testing.T, method, statusClass string) float64 {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "appuio_keycloak_adapter_keycloak_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["method"] == method && labels["status_class"] == statusClass {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
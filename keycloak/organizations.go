@@ -0,0 +1,514 @@
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Nerzal/gocloak/v13"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/multierr"
+)
+
+// OrganizationsClient maps top-level groups to Keycloak Organizations instead of Keycloak groups.
+// Organizations are available since Keycloak 25 and must be enabled for the realm.
+//
+// Organizations are named and aliased after the top-level group and store its display name and attributes as organization attributes.
+// Groups below a top-level group, such as teams, are regular Keycloak groups below a top-level Keycloak group of the same name as the organization, which is created as needed and has no members.
+// The RootGroup of the client only applies to these groups.
+//
+// Organizations put by the client are marked with the attribute `managedBy: appuio-keycloak-adapter`, like the RootGroup marks the groups managed by a Client.
+// Only marked organizations are listed, and users are only removed from marked organizations.
+// Putting an unmarked organization of the same name marks it.
+type OrganizationsClient struct {
+	// Client is used to manage the nested groups and users, and to send all requests.
+	Client Client
+
+	// DomainSuffix, if set, adds the domain `<organization>.<DomainSuffix>` to new organizations.
+	// Keycloak 25 requires organizations to have at least one domain.
+	DomainSuffix string
+}
+
+const (
+	// managedByAttribute is the attribute marking the organizations managed by an OrganizationsClient.
+	managedByAttribute = "managedBy"
+	// managedByValue is the value of the managedByAttribute of managed organizations.
+	managedByValue = "appuio-keycloak-adapter"
+)
+
+type organizationRepresentation struct {
+	ID          *string              `json:"id,omitempty"`
+	Name        *string              `json:"name,omitempty"`
+	Alias       *string              `json:"alias,omitempty"`
+	Enabled     *bool                `json:"enabled,omitempty"`
+	Description *string              `json:"description,omitempty"`
+	Attributes  *map[string][]string `json:"attributes,omitempty"`
+	Domains     []organizationDomain `json:"domains,omitempty"`
+}
+
+type organizationDomain struct {
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
+}
+
+// PutGroup creates or updates the organization of the provided top-level group and adjusts its members accordingly.
+// Nested groups are put like Client.PutGroup does, after making sure the group of their organization exists.
+// The method is idempotent.
+func (c OrganizationsClient) PutGroup(ctx context.Context, group Group) (Group, error) {
+	name := "PutOrganization"
+	if group.Depth() > 0 {
+		name = "PutGroup"
+	}
+	ctx, span := c.Client.startSpan(ctx, name, groupPathAttr(group))
+	res := NewGroup(group.DisplayName(), group.PathMembers()...)
+	err := c.Client.withToken(ctx, func(token *session) error {
+		var err error
+		if group.Depth() > 0 {
+			res, err = c.putNestedGroup(ctx, token, group)
+		} else {
+			res, err = c.putOrganization(ctx, token, group)
+		}
+		return err
+	})
+	return res, endSpan(span, err)
+}
+
+// PlanGroup returns the changes PutGroup would make to the organization of the provided top-level group, without changing anything.
+// Nested groups are planned like Client.PlanGroup does.
+// Plans of organizations can't be applied with Client.ApplyPlan.
+func (c OrganizationsClient) PlanGroup(ctx context.Context, group Group) (GroupPlan, error) {
+	if group.Depth() > 0 {
+		return c.Client.PlanGroup(ctx, group)
+	}
+	ctx, span := c.Client.startSpan(ctx, "PlanOrganization", groupPathAttr(group))
+	res := GroupPlan{Group: group, organization: true}
+	err := c.Client.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.planOrganization(ctx, token, c.Client.newUserResolver(token), group)
+		return err
+	})
+	return res, endSpan(span, err)
+}
+
+// DeleteGroup deletes the organization of the provided top-level group, together with the group of the organization and all groups below it.
+// Nested groups are deleted like Client.DeleteGroup does.
+// The method is idempotent.
+func (c OrganizationsClient) DeleteGroup(ctx context.Context, group Group) error {
+	if group.Depth() > 0 {
+		return c.Client.DeleteGroup(ctx, group)
+	}
+	ctx, span := c.Client.startSpan(ctx, "DeleteOrganization", groupPathAttr(group))
+	err := c.Client.withToken(ctx, func(token *session) error {
+		org, err := c.getOrganization(ctx, token, group)
+		if err != nil {
+			return fmt.Errorf("failed finding organization: %w", err)
+		}
+		if org != nil {
+			err := c.Client.api().do(ctx, "DeleteOrganization", func(ctx context.Context) error {
+				_, err := c.Client.adminRequest(ctx, token, http.MethodDelete, []string{"organizations", *org.ID}, nil, nil, nil)
+				return err
+			})
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return c.Client.deleteGroup(ctx, token, NewGroup("", group.PathMembers()...))
+	})
+	return endSpan(span, err)
+}
+
+// ListGroups returns all Keycloak organizations managed by the client as top-level groups, followed by the groups nested below them.
+func (c OrganizationsClient) ListGroups(ctx context.Context) ([]Group, error) {
+	ctx, span := c.Client.startSpan(ctx, "ListGroups")
+	var res []Group
+	err := c.Client.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.listGroups(ctx, token)
+		return err
+	})
+	span.SetAttributes(attribute.Int("keycloak.groups", len(res)))
+	return res, endSpan(span, err)
+}
+
+// PutUser updates the given user, see Client.PutUser.
+func (c OrganizationsClient) PutUser(ctx context.Context, user User) (User, error) {
+	return c.Client.PutUser(ctx, user)
+}
+
+// ListUsers returns all Keycloak users in the realm.
+func (c OrganizationsClient) ListUsers(ctx context.Context) ([]User, error) {
+	return c.Client.ListUsers(ctx)
+}
+
+// RemoveUserFromGroups removes the user with the given username from all organizations and groups managed by the client, see Client.RemoveUserFromGroups.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c OrganizationsClient) RemoveUserFromGroups(ctx context.Context, username string) error {
+	groupErr := c.Client.RemoveUserFromGroups(ctx, username)
+
+	ctx, span := c.Client.startSpan(ctx, "RemoveUserFromOrganizations", usernameAttr(username))
+	err := c.Client.withToken(ctx, func(token *session) error {
+		return c.removeUserFromOrganizations(ctx, token, username)
+	})
+	return multierr.Append(groupErr, endSpan(span, err))
+}
+
+// DisableUser disables the user with the given username, see Client.DisableUser.
+func (c OrganizationsClient) DisableUser(ctx context.Context, username string) error {
+	return c.Client.DisableUser(ctx, username)
+}
+
+// DeleteUser deletes the user with the given username, see Client.DeleteUser.
+func (c OrganizationsClient) DeleteUser(ctx context.Context, username string) error {
+	return c.Client.DeleteUser(ctx, username)
+}
+
+// Close ends the cached Keycloak session, if there is one.
+func (c OrganizationsClient) Close(ctx context.Context) error {
+	return c.Client.Close(ctx)
+}
+
+func (c OrganizationsClient) putNestedGroup(ctx context.Context, token *session, group Group) (Group, error) {
+	orgGroup := c.Client.prependRoot(NewGroup("", group.PathMembers()[0]))
+	found, err := c.Client.getGroup(ctx, token, orgGroup)
+	if err != nil {
+		return NewGroup(group.DisplayName(), group.PathMembers()...), fmt.Errorf("failed finding group of organization: %w", err)
+	}
+	if found == nil {
+		parentID, err := c.Client.getParentID(ctx, token, orgGroup)
+		if err != nil {
+			return NewGroup(group.DisplayName(), group.PathMembers()...), err
+		}
+		if _, err := c.Client.createGroup(ctx, token, orgGroup, parentID); err != nil {
+			return NewGroup(group.DisplayName(), group.PathMembers()...), fmt.Errorf("failed creating group of organization: %w", err)
+		}
+	}
+	return c.Client.putGroup(ctx, token, group)
+}
+
+func (c OrganizationsClient) putOrganization(ctx context.Context, token *session, group Group) (Group, error) {
+	plan, err := c.planOrganization(ctx, token, c.Client.newUserResolver(token), group)
+	if err != nil {
+		return NewGroup(group.DisplayName(), group.PathMembers()...), err
+	}
+	return c.applyOrganizationPlan(ctx, token, plan)
+}
+
+func (c OrganizationsClient) planOrganization(ctx context.Context, token *session, users *userResolver, group Group) (GroupPlan, error) {
+	plan := GroupPlan{Group: group, organization: true}
+	if err := c.requireOrganizations(ctx, token); err != nil {
+		return plan, err
+	}
+
+	org, err := c.getOrganization(ctx, token, group)
+	if err != nil {
+		return plan, fmt.Errorf("failed finding organization: %w", err)
+	}
+	if org == nil {
+		plan.Create = true
+	} else {
+		plan.PreviousDisplayName = getDisplayName(org.Attributes)
+		plan.DisplayNameChanged = plan.PreviousDisplayName != group.DisplayName()
+		org.Attributes, plan.AttributesChanged = setAttributes(org.Attributes, group.Attributes(), group.OwnedAttributePrefixes())
+		org.Attributes = setDisplayName(org.Attributes, group.DisplayName())
+		if !isManagedOrganization(org) {
+			(*org.Attributes)[managedByAttribute] = []string{managedByValue}
+			plan.AttributesChanged = true
+		}
+		plan.existingOrganization = org
+
+		current, err := c.getOrganizationMembers(ctx, token, *org.ID)
+		if err != nil {
+			return plan, fmt.Errorf("failed finding members of organization %s: %w", group.BaseName(), err)
+		}
+		for _, m := range current {
+			if containsUsername(group.Members, *m.Username) {
+				plan.members = append(plan.members, UserFromKeycloakUser(*m))
+			} else {
+				plan.RemoveMembers = append(plan.RemoveMembers, UserFromKeycloakUser(*m))
+			}
+		}
+	}
+
+	plan.AddMembers, plan.memberIDs, plan.UnresolvedMembers = resolveUsers(ctx, users, diffByUsername(group.Members, plan.members))
+	return plan, nil
+}
+
+func (c OrganizationsClient) applyOrganizationPlan(ctx context.Context, token *session, plan GroupPlan) (Group, error) {
+	group := plan.Group
+	res := NewGroup(group.DisplayName(), group.PathMembers()...)
+
+	org := plan.existingOrganization
+	switch {
+	case plan.Create:
+		var err error
+		org, err = c.createOrganization(ctx, token, group)
+		if err != nil {
+			return res, err
+		}
+	case org == nil:
+		return res, fmt.Errorf("plan for organization %q neither creates nor updates an organization", group.Path())
+	case plan.DisplayNameChanged || plan.AttributesChanged:
+		err := c.Client.api().do(ctx, "UpdateOrganization", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodPut, []string{"organizations", *org.ID}, nil, org, nil)
+			return err
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+	res = res.WithID(*org.ID).WithAttributes(organizationAttributes(org))
+	res.Members = append(res.Members, plan.members...)
+
+	membErr := MembershipSyncErrors{}
+	for _, u := range plan.RemoveMembers {
+		err := c.Client.api().do(ctx, "DeleteOrganizationMember", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodDelete, []string{"organizations", *org.ID, "members", u.ID}, nil, nil, nil)
+			return err
+		})
+		if err != nil {
+			membErr = append(membErr, MembershipSyncError{Err: err, Username: u.Username, Event: UserRemoveError})
+		}
+	}
+
+	added, addErr := c.Client.addUsers(ctx, plan.AddMembers, plan.memberIDs, func(ctx context.Context, userID string) error {
+		return c.addOrganizationMember(ctx, token, *org.ID, userID)
+	})
+	res.Members = append(res.Members, added...)
+	membErr = append(membErr, plan.UnresolvedMembers...)
+	if addErr != nil {
+		membErr = append(membErr, *addErr...)
+	}
+
+	if len(membErr) > 0 {
+		return res, &membErr
+	}
+	return res, nil
+}
+
+func (c OrganizationsClient) createOrganization(ctx context.Context, token *session, group Group) (*organizationRepresentation, error) {
+	name := group.BaseName()
+	attributes, _ := setAttributes(nil, group.Attributes(), nil)
+	(*attributes)[managedByAttribute] = []string{managedByValue}
+	org := &organizationRepresentation{
+		Name:       gocloak.StringP(name),
+		Alias:      gocloak.StringP(name),
+		Enabled:    gocloak.BoolP(true),
+		Attributes: setDisplayName(attributes, group.DisplayName()),
+	}
+	if c.DomainSuffix != "" {
+		org.Domains = []organizationDomain{{Name: name + "." + c.DomainSuffix}}
+	}
+
+	err := c.Client.api().doOnce(ctx, "CreateOrganization", func(ctx context.Context) error {
+		resp, err := c.Client.adminRequest(ctx, token, http.MethodPost, []string{"organizations"}, nil, org, nil)
+		if err != nil {
+			return err
+		}
+		org.ID = gocloak.StringP(createdID(resp))
+		return nil
+	})
+	return org, err
+}
+
+func (c OrganizationsClient) addOrganizationMember(ctx context.Context, token *session, orgID, userID string) error {
+	// The endpoint expects the bare user ID as a JSON string.
+	body, err := json.Marshal(userID)
+	if err != nil {
+		return err
+	}
+	err = c.Client.api().do(ctx, "AddOrganizationMember", func(ctx context.Context) error {
+		_, err := c.Client.adminRequest(ctx, token, http.MethodPost, []string{"organizations", orgID, "members"}, nil, body, nil)
+		return err
+	})
+	// Keycloak rejects adding an existing member with a conflict, e.g. if a retried request was already processed.
+	if KindOf(err) == ErrConflict {
+		return nil
+	}
+	return err
+}
+
+// getOrganization returns the organization named like the given top-level group, or nil if there is no such organization.
+// If the ID of the given group is known, the organization is fetched directly.
+func (c OrganizationsClient) getOrganization(ctx context.Context, token *session, group Group) (*organizationRepresentation, error) {
+	name := group.BaseName()
+	if group.ID() != "" {
+		var org organizationRepresentation
+		err := c.Client.api().do(ctx, "GetOrganization", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodGet, []string{"organizations", group.ID()}, nil, nil, &org)
+			return err
+		})
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		if err == nil && org.Name != nil && *org.Name == name {
+			return &org, nil
+		}
+		// The ID is stale, the organization was deleted.
+	}
+
+	var orgs []*organizationRepresentation
+	err := c.Client.api().do(ctx, "GetOrganizations", func(ctx context.Context) error {
+		_, err := c.Client.adminRequest(ctx, token, http.MethodGet, []string{"organizations"}, map[string]string{
+			"search":              name,
+			"exact":               "true",
+			"briefRepresentation": "false",
+		}, nil, &orgs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		if org.Name != nil && *org.Name == name {
+			return org, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c OrganizationsClient) getOrganizationMembers(ctx context.Context, token *session, orgID string) ([]*gocloak.User, error) {
+	return fetchPaged(c.Client.pageSize(), func(first, max int) ([]*gocloak.User, error) {
+		var members []*gocloak.User
+		err := c.Client.api().do(ctx, "GetOrganizationMembers", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodGet, []string{"organizations", orgID, "members"}, map[string]string{
+				"first": strconv.Itoa(first),
+				"max":   strconv.Itoa(max),
+			}, nil, &members)
+			return err
+		})
+		return members, err
+	})
+}
+
+func (c OrganizationsClient) listGroups(ctx context.Context, token *session) ([]Group, error) {
+	if err := c.requireOrganizations(ctx, token); err != nil {
+		return nil, err
+	}
+
+	orgs, err := fetchPaged(c.Client.pageSize(), func(first, max int) ([]*organizationRepresentation, error) {
+		var orgs []*organizationRepresentation
+		err := c.Client.api().do(ctx, "GetOrganizations", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodGet, []string{"organizations"}, map[string]string{
+				"first":               strconv.Itoa(first),
+				"max":                 strconv.Itoa(max),
+				"briefRepresentation": "false",
+			}, nil, &orgs)
+			return err
+		})
+		return orgs, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed listing organizations: %w", err)
+	}
+
+	res := make([]Group, 0, len(orgs))
+	for _, org := range orgs {
+		if !isManagedOrganization(org) {
+			continue
+		}
+		res = append(res, NewGroup(getDisplayName(org.Attributes), *org.Name).
+			WithID(*org.ID).
+			WithAttributes(organizationAttributes(org)))
+	}
+	err = c.Client.forEach(ctx, len(res), func(ctx context.Context, i int) error {
+		memb, err := c.getOrganizationMembers(ctx, token, res[i].ID())
+		if err != nil {
+			return fmt.Errorf("failed finding members of organization %s: %w", res[i].BaseName(), err)
+		}
+		res[i].Members = make([]User, len(memb))
+		for j, m := range memb {
+			res[i].Members[j] = UserFromKeycloakUser(*m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Client.MaxDepth == 1 {
+		return res, nil
+	}
+	groups, err := c.Client.listGroups(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		// Top-level groups only hold the nested groups of the organizations.
+		if g.Depth() > 0 {
+			res = append(res, g)
+		}
+	}
+	return res, nil
+}
+
+func (c OrganizationsClient) removeUserFromOrganizations(ctx context.Context, token *session, username string) error {
+	if err := c.requireOrganizations(ctx, token); err != nil {
+		return err
+	}
+	user, err := c.Client.getUserByName(ctx, token, username, false)
+	if errors.Is(err, UserNotFoundError{}) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed querying keycloak for user %q: %w", username, err)
+	}
+
+	var orgs []*organizationRepresentation
+	err = c.Client.api().do(ctx, "GetMemberOrganizations", func(ctx context.Context) error {
+		_, err := c.Client.adminRequest(ctx, token, http.MethodGet, []string{"organizations", "members", *user.ID, "organizations"}, map[string]string{
+			"briefRepresentation": "false",
+		}, nil, &orgs)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed listing organizations of user %q: %w", username, err)
+	}
+
+	var errs error
+	for _, org := range orgs {
+		if !isManagedOrganization(org) {
+			continue
+		}
+		err := c.Client.api().do(ctx, "DeleteOrganizationMember", func(ctx context.Context) error {
+			_, err := c.Client.adminRequest(ctx, token, http.MethodDelete, []string{"organizations", *org.ID, "members", *user.ID}, nil, nil, nil)
+			return err
+		})
+		if err != nil && !isNotFound(err) {
+			errs = multierr.Append(errs, fmt.Errorf("failed removing user %q from organization %q: %w", username, *org.Name, err))
+		}
+	}
+	return errs
+}
+
+// isManagedOrganization returns true if the organization is marked with the managedByAttribute.
+func isManagedOrganization(org *organizationRepresentation) bool {
+	if org.Attributes == nil {
+		return false
+	}
+	values := (*org.Attributes)[managedByAttribute]
+	return len(values) > 0 && values[0] == managedByValue
+}
+
+// organizationAttributes returns the attributes of the organization without the display name and the managedByAttribute.
+func organizationAttributes(org *organizationRepresentation) map[string][]string {
+	attrs := withoutDisplayName(org.Attributes)
+	delete(attrs, managedByAttribute)
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// requireOrganizations returns an error if the Keycloak server does not support organizations.
+func (c OrganizationsClient) requireOrganizations(ctx context.Context, token *session) error {
+	caps, err := c.Client.capabilities(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !caps.Organizations {
+		return fmt.Errorf("keycloak %s does not support organizations, version %d or later is required", caps.Version, minVersionOrganizations)
+	}
+	return nil
+}
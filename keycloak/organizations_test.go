@@ -0,0 +1,178 @@
+package keycloak_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak/keycloaktest"
+)
+
+func TestOrganizationsClient_PutListDeleteGroups(t *testing.T) {
+	for _, version := range []string{"25.0.1", "26.0.0"} {
+		t.Run(version, func(t *testing.T) {
+			srv := keycloaktest.NewServer("appuio", version)
+			defer srv.Close()
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+			srv.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+			kc := srv.NewClient("admin", "secret")
+			kc.PageSize = 1
+			c := OrganizationsClient{Client: kc, DomainSuffix: "example.com"}
+			ctx := context.Background()
+
+			foo, err := c.PutGroup(ctx, NewGroup("Foo Inc.", "foo").WithMemberNames("alice"))
+			require.NoError(t, err)
+			assert.NotEmpty(t, foo.ID())
+			_, err = c.PutGroup(ctx, NewGroup("Foo Team", "foo", "team").WithMemberNames("alice", "bob"))
+			require.NoError(t, err)
+			_, err = c.PutGroup(ctx, NewGroup("Bar Inc.", "bar").WithMemberNames("bob"))
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{"bar", "foo"}, srv.OrganizationNames())
+			assert.Equal(t, []string{"alice"}, srv.OrganizationMembers("foo"))
+			org, ok := srv.Organization("foo")
+			require.True(t, ok)
+			assert.Equal(t, "foo", org.Alias)
+			assert.Equal(t, []string{"Foo Inc."}, org.Attributes["displayName"])
+			assert.Equal(t, []keycloaktest.OrganizationDomain{{Name: "foo.example.com"}}, org.Domains)
+
+			assert.Equal(t, []string{"/foo", "/foo/team"}, srv.GroupPaths(), "only nested groups are Keycloak groups")
+			assert.Empty(t, srv.Members("foo"))
+			assert.Equal(t, []string{"alice", "bob"}, srv.Members("foo", "team"))
+
+			groups, err := c.ListGroups(ctx)
+			require.NoError(t, err)
+			paths := map[string][]string{}
+			for _, g := range groups {
+				for _, m := range g.Members {
+					paths[g.Path()] = append(paths[g.Path()], m.Username)
+				}
+			}
+			assert.Equal(t, map[string][]string{
+				"/bar":      {"bob"},
+				"/foo":      {"alice"},
+				"/foo/team": {"alice", "bob"},
+			}, paths)
+
+			_, err = c.PutGroup(ctx, NewGroup("Foo AG", "foo").WithID(foo.ID()).WithMemberNames("bob"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bob"}, srv.OrganizationMembers("foo"))
+			org, _ = srv.Organization("foo")
+			assert.Equal(t, []string{"Foo AG"}, org.Attributes["displayName"])
+
+			require.NoError(t, c.RemoveUserFromGroups(ctx, "bob"))
+			assert.Empty(t, srv.OrganizationMembers("foo"))
+			assert.Empty(t, srv.OrganizationMembers("bar"))
+			assert.Equal(t, []string{"alice"}, srv.Members("foo", "team"))
+
+			require.NoError(t, c.DeleteGroup(ctx, NewGroup("", "foo")))
+			require.NoError(t, c.DeleteGroup(ctx, NewGroup("", "foo")), "deleting a missing organization is idempotent")
+			assert.Equal(t, []string{"bar"}, srv.OrganizationNames())
+			assert.Empty(t, srv.GroupPaths())
+		})
+	}
+}
+
+func TestOrganizationsClient_PutGroup_missing_user(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "26.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	c := OrganizationsClient{Client: srv.NewClient("admin", "secret")}
+
+	g, err := c.PutGroup(context.Background(), NewGroup("Foo Inc.", "foo").WithMemberNames("alice", "bob"))
+	var membErr *MembershipSyncErrors
+	require.ErrorAs(t, err, &membErr)
+	require.Len(t, *membErr, 1)
+	assert.Equal(t, "bob", (*membErr)[0].Username)
+	assert.Equal(t, UserAddError, (*membErr)[0].Event)
+	assert.Equal(t, []User{{Username: "alice"}}, g.Members)
+	assert.Equal(t, []string{"alice"}, srv.OrganizationMembers("foo"))
+}
+
+func TestOrganizationsClient_PutGroup_unsupported(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "24.0.5")
+	defer srv.Close()
+	c := OrganizationsClient{Client: srv.NewClient("admin", "secret")}
+
+	_, err := c.PutGroup(context.Background(), NewGroup("Foo Inc.", "foo"))
+	require.ErrorContains(t, err, "version 25 or later is required")
+	assert.Empty(t, srv.GroupPaths())
+}
+
+func TestOrganizationsClient_PlanGroup(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "26.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+	id := srv.AddOrganization("Foo", "foo")
+	srv.SetOrganizationAttribute("foo", "managedBy", "appuio-keycloak-adapter")
+	srv.AddOrganizationMember("bob", "foo")
+	c := OrganizationsClient{Client: srv.NewClient("admin", "secret")}
+	ctx := context.Background()
+
+	plan, err := c.PlanGroup(ctx, NewGroup("Foo Inc.", "foo").WithMemberNames("alice", "missing"))
+	require.NoError(t, err)
+	assert.False(t, plan.Create)
+	assert.Equal(t, id, plan.ID())
+	assert.True(t, plan.DisplayNameChanged)
+	assert.Equal(t, "Foo", plan.PreviousDisplayName)
+	require.Len(t, plan.AddMembers, 1)
+	assert.Equal(t, "alice", plan.AddMembers[0].Username)
+	require.Len(t, plan.RemoveMembers, 1)
+	assert.Equal(t, "bob", plan.RemoveMembers[0].Username)
+	require.Len(t, plan.UnresolvedMembers, 1)
+	assert.Equal(t, "missing", plan.UnresolvedMembers[0].Username)
+
+	assert.Equal(t, []string{"bob"}, srv.OrganizationMembers("foo"), "members unchanged")
+	org, _ := srv.Organization("foo")
+	assert.Equal(t, []string{"Foo"}, org.Attributes["displayName"], "display name unchanged")
+
+	plan, err = c.PlanGroup(ctx, NewGroup("Bar Inc.", "bar").WithMemberNames("alice"))
+	require.NoError(t, err)
+	assert.True(t, plan.Create)
+	assert.Empty(t, plan.ID())
+	require.Len(t, plan.AddMembers, 1)
+	assert.Equal(t, []string{"foo"}, srv.OrganizationNames(), "organization not created")
+
+	_, err = c.Client.ApplyPlan(ctx, plan)
+	require.Error(t, err, "plans of organizations can't be applied to groups")
+	assert.Empty(t, srv.GroupPaths())
+}
+
+func TestOrganizationsClient_unmanagedOrganizations(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "26.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	srv.AddOrganization("Other", "other")
+	srv.AddOrganizationMember("alice", "other")
+	c := OrganizationsClient{Client: srv.NewClient("admin", "secret")}
+	ctx := context.Background()
+
+	foo, err := c.PutGroup(ctx, NewGroup("Foo Inc.", "foo").WithMemberNames("alice"))
+	require.NoError(t, err)
+	assert.Empty(t, foo.Attributes(), "marker not returned as attribute")
+	org, _ := srv.Organization("foo")
+	assert.Equal(t, []string{"appuio-keycloak-adapter"}, org.Attributes["managedBy"])
+
+	groups, err := c.ListGroups(ctx)
+	require.NoError(t, err)
+	require.Len(t, groups, 1, "unmanaged organizations are not listed")
+	assert.Equal(t, "foo", groups[0].BaseName())
+	assert.Empty(t, groups[0].Attributes())
+
+	require.NoError(t, c.RemoveUserFromGroups(ctx, "alice"))
+	assert.Empty(t, srv.OrganizationMembers("foo"))
+	assert.Equal(t, []string{"alice"}, srv.OrganizationMembers("other"), "user not removed from unmanaged organization")
+
+	_, err = c.PutGroup(ctx, NewGroup("Other", "other").WithMemberNames("alice"))
+	require.NoError(t, err)
+	org, _ = srv.Organization("other")
+	assert.Equal(t, []string{"appuio-keycloak-adapter"}, org.Attributes["managedBy"], "putting an organization marks it")
+	groups, err = c.ListGroups(ctx)
+	require.NoError(t, err)
+	assert.Len(t, groups, 2)
+}
@@ -0,0 +1,44 @@
+package keycloak
+
+import "github.com/Nerzal/gocloak/v13"
+
+const defaultPageSize = 100
+
+func (c Client) pageSize() int {
+	if c.PageSize > 0 {
+		return c.PageSize
+	}
+	return defaultPageSize
+}
+
+// fetchPaged calls fetch with increasing offsets and returns the concatenated results.
+// It stops as soon as fetch returns less than a full page.
+func fetchPaged[T any](pageSize int, fetch func(first, max int) ([]T, error)) ([]T, error) {
+	var all []T
+	for first := 0; ; first += pageSize {
+		page, err := fetch(first, pageSize)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, page...)
+		if len(page) < pageSize {
+			return all, nil
+		}
+	}
+}
+
+func pageParams(first, max int) gocloak.GetGroupsParams {
+	return gocloak.GetGroupsParams{
+		First:               gocloak.IntP(first),
+		Max:                 gocloak.IntP(max),
+		BriefRepresentation: gocloak.BoolP(false), // required in order to get attributes when listing groups
+	}
+}
+
+func userPageParams(first, max int) gocloak.GetUsersParams {
+	return gocloak.GetUsersParams{
+		First:               gocloak.IntP(first),
+		Max:                 gocloak.IntP(max),
+		BriefRepresentation: gocloak.BoolP(false), // required in order to get attributes when listing users
+	}
+}
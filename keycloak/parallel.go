@@ -0,0 +1,42 @@
+package keycloak
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func (c Client) concurrency() int {
+	if c.Concurrency > 0 {
+		return c.Concurrency
+	}
+	return 1
+}
+
+// forEach calls fn for every index in [0, n) with at most c.Concurrency calls running at the same time.
+// The context passed to fn is cancelled as soon as a call fails, and no further calls are started.
+// Returns the first error encountered, or the context's error if it ended before all calls were started.
+// Callers should write results into a slot identified by the index to keep them in a deterministic order.
+func (c Client) forEach(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(c.concurrency())
+
+	started := 0
+	for ; started < n; started++ {
+		if egCtx.Err() != nil {
+			break
+		}
+		i := started
+		eg.Go(func() error {
+			return fn(egCtx, i)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	if started < n {
+		return ctx.Err()
+	}
+	return nil
+}
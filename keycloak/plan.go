@@ -0,0 +1,202 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// GroupPlan is the set of changes needed to bring a Keycloak group in line with a desired group.
+// A plan reflects the state of Keycloak at the time it was made and should be applied promptly.
+type GroupPlan struct {
+	// Group is the desired group.
+	Group Group
+
+	// Create is set if the group does not exist and will be created.
+	Create bool
+	// PreviousDisplayName is the display name of the existing group.
+	PreviousDisplayName string
+	// DisplayNameChanged is set if the display name of the existing group will be changed.
+	DisplayNameChanged bool
+	// AttributesChanged is set if attributes of the existing group will be changed.
+	AttributesChanged bool
+
+	// AddMembers are the users which will be added to the group.
+	AddMembers []User
+	// RemoveMembers are the users which will be removed from the group.
+	RemoveMembers []User
+	// UnresolvedMembers are the members of the desired group which could not be found in Keycloak.
+	// They are reported as errors when applying the plan.
+	UnresolvedMembers []MembershipSyncError
+
+	// parentID is the Keycloak ID of the parent of a group to create, if any.
+	parentID string
+	// existing is the Keycloak group with the planned attributes, if the group exists.
+	existing *gocloak.Group
+	// organization is set if the plan was made by an OrganizationsClient for an organization.
+	organization bool
+	// existingOrganization is the Keycloak organization with the planned attributes, if the organization exists.
+	existingOrganization *organizationRepresentation
+	// members are the current members which stay in the group.
+	members []User
+	// memberIDs are the Keycloak IDs of the users in AddMembers by username.
+	memberIDs map[string]string
+}
+
+// Empty returns true if applying the plan would not change anything.
+// Unresolved members can't be added and don't count as changes.
+func (p GroupPlan) Empty() bool {
+	return !p.Create && !p.DisplayNameChanged && !p.AttributesChanged && len(p.AddMembers) == 0 && len(p.RemoveMembers) == 0
+}
+
+// ID returns the Keycloak ID of the existing group, or an empty string if the group will be created.
+func (p GroupPlan) ID() string {
+	if p.existingOrganization != nil && p.existingOrganization.ID != nil {
+		return *p.existingOrganization.ID
+	}
+	if p.existing == nil || p.existing.ID == nil {
+		return ""
+	}
+	return *p.existing.ID
+}
+
+// PlanGroup returns the changes PutGroup would make to the provided Keycloak group, without changing anything.
+func (c Client) PlanGroup(ctx context.Context, group Group) (GroupPlan, error) {
+	ctx, span := c.startSpan(ctx, "PlanGroup", groupPathAttr(group))
+	res := GroupPlan{Group: group}
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.planGroup(ctx, token, c.newUserResolver(token), group)
+		return err
+	})
+	return res, endSpan(span, err)
+}
+
+// ApplyPlan applies the changes of the given plan and returns the resulting group, like PutGroup.
+// Plans of organizations made by an OrganizationsClient can't be applied.
+// Failures to add or remove single members, and unresolved members, are returned as MembershipSyncErrors.
+func (c Client) ApplyPlan(ctx context.Context, plan GroupPlan) (Group, error) {
+	ctx, span := c.startSpan(ctx, "ApplyPlan", groupPathAttr(plan.Group))
+	res := NewGroup(plan.Group.DisplayName(), plan.Group.PathMembers()...)
+	err := c.withToken(ctx, func(token *session) error {
+		var err error
+		res, err = c.applyPlan(ctx, token, plan)
+		return err
+	})
+	return res, endSpan(span, err)
+}
+
+func (c Client) planGroup(ctx context.Context, token *session, users *userResolver, group Group) (GroupPlan, error) {
+	plan := GroupPlan{Group: group}
+
+	found, foundMemb, err := c.getGroupAndMembers(ctx, token, c.prependRoot(group))
+	if err != nil {
+		return plan, fmt.Errorf("failed finding group: %w", err)
+	}
+	if found == nil {
+		plan.Create = true
+		plan.parentID, err = c.getParentID(ctx, token, c.prependRoot(group))
+		if err != nil {
+			return plan, err
+		}
+	} else {
+		plan.PreviousDisplayName = getDisplayNameOfGroup(found)
+		plan.DisplayNameChanged = plan.PreviousDisplayName != group.DisplayName()
+		found.Attributes, plan.AttributesChanged = setAttributes(found.Attributes, group.Attributes(), group.OwnedAttributePrefixes())
+		found.Attributes = setDisplayName(found.Attributes, group.DisplayName())
+		plan.existing = found
+	}
+
+	for _, fm := range foundMemb {
+		if containsUsername(group.Members, *fm.Username) {
+			plan.members = append(plan.members, UserFromKeycloakUser(*fm))
+		} else {
+			plan.RemoveMembers = append(plan.RemoveMembers, UserFromKeycloakUser(*fm))
+		}
+	}
+
+	plan.AddMembers, plan.memberIDs, plan.UnresolvedMembers = resolveUsers(ctx, users, diffByUsername(group.Members, plan.members))
+	return plan, nil
+}
+
+// resolveUsers looks up the Keycloak IDs of the given users with the given resolver.
+// It returns the found users, their IDs by username, and the errors of the users which could not be found.
+func resolveUsers(ctx context.Context, resolver *userResolver, users []User) ([]User, map[string]string, []MembershipSyncError) {
+	names := make([]string, len(users))
+	for i := range users {
+		names[i] = users[i].Username
+	}
+	resolved, failed, err := resolver.resolve(ctx, names)
+
+	var found []User
+	var unresolved []MembershipSyncError
+	ids := make(map[string]string, len(users))
+	for _, user := range users {
+		resolveErr := err
+		if resolveErr == nil {
+			resolveErr = failed[user.Username]
+		}
+		if resolveErr != nil {
+			unresolved = append(unresolved, MembershipSyncError{Err: resolveErr, Username: user.Username, Event: UserAddError})
+			continue
+		}
+		found = append(found, user)
+		ids[user.Username] = *resolved[user.Username].ID
+	}
+	return found, ids, unresolved
+}
+
+func (c Client) applyPlan(ctx context.Context, token *session, plan GroupPlan) (Group, error) {
+	group := plan.Group
+	res := NewGroup(group.DisplayName(), group.PathMembers()...)
+
+	var kcGroup gocloak.Group
+	switch {
+	case plan.organization:
+		return res, fmt.Errorf("plan for organization %q can't be applied to a Keycloak group", group.Path())
+	case plan.Create:
+		created, err := c.createGroup(ctx, token, c.prependRoot(group), plan.parentID)
+		if err != nil {
+			return res, err
+		}
+		kcGroup = created
+	case plan.existing == nil:
+		return res, fmt.Errorf("plan for group %q neither creates nor updates a group", group.Path())
+	default:
+		kcGroup = *plan.existing
+		if plan.DisplayNameChanged || plan.AttributesChanged {
+			err := c.updateGroup(ctx, token, kcGroup)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	res = res.WithID(*kcGroup.ID).WithAttributes(groupAttributes(&kcGroup))
+	res.Members = append(res.Members, plan.members...)
+
+	membErr := MembershipSyncErrors{}
+	for _, u := range plan.RemoveMembers {
+		err := c.api().DeleteUserFromGroup(ctx, token.AccessToken, c.Realm, u.ID, res.ID())
+		if err != nil {
+			membErr = append(membErr, MembershipSyncError{
+				Err:      err,
+				Username: u.Username,
+				Event:    UserRemoveError,
+			})
+		}
+	}
+
+	addedMemb, addMembErr := c.addUsersToGroup(ctx, token, res.ID(), plan.AddMembers, plan.memberIDs)
+	res.Members = append(res.Members, addedMemb...)
+	membErr = append(membErr, plan.UnresolvedMembers...)
+	if addMembErr != nil {
+		membErr = append(membErr, *addMembErr...)
+	}
+
+	if len(membErr) > 0 {
+		return res, &membErr
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package keycloak_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak/keycloaktest"
+)
+
+func TestPlanGroup_existing(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		srv.AddUser(gocloak.User{Username: gocloak.StringP(u)})
+	}
+	srv.AddGroup("Foo Inc.", "foo")
+	srv.AddMember("alice", "foo")
+	srv.AddMember("bob", "foo")
+	c := srv.NewClient("admin", "secret")
+	ctx := context.Background()
+
+	plan, err := c.PlanGroup(ctx, NewGroup("Foo AG", "foo").WithMemberNames("bob", "carol", "dave"))
+	require.NoError(t, err)
+
+	assert.False(t, plan.Create)
+	assert.True(t, plan.DisplayNameChanged)
+	assert.Equal(t, "Foo Inc.", plan.PreviousDisplayName)
+	assert.False(t, plan.AttributesChanged)
+	assert.Equal(t, []User{{Username: "carol"}}, plan.AddMembers)
+	require.Len(t, plan.RemoveMembers, 1)
+	assert.Equal(t, "alice", plan.RemoveMembers[0].Username)
+	require.Len(t, plan.UnresolvedMembers, 1)
+	assert.Equal(t, "dave", plan.UnresolvedMembers[0].Username)
+	assert.ErrorIs(t, plan.UnresolvedMembers[0], UserNotFoundError{})
+	assert.False(t, plan.Empty())
+
+	assert.Equal(t, []string{"alice", "bob"}, srv.Members("foo"), "planning does not change anything")
+
+	g, err := c.ApplyPlan(ctx, plan)
+	var membErrs *MembershipSyncErrors
+	require.True(t, errors.As(err, &membErrs))
+	require.Len(t, *membErrs, 1)
+	assert.Equal(t, "dave", (*membErrs)[0].Username)
+	assert.Equal(t, UserAddError, (*membErrs)[0].Event)
+	assert.Len(t, g.Members, 2)
+
+	assert.Equal(t, []string{"bob", "carol"}, srv.Members("foo"))
+	foo, _ := srv.Group("foo")
+	assert.Equal(t, []string{"Foo AG"}, (*foo.Attributes)["displayName"])
+
+	plan, err = c.PlanGroup(ctx, NewGroup("Foo AG", "foo").WithMemberNames("bob", "carol"))
+	require.NoError(t, err)
+	assert.True(t, plan.Empty())
+}
+
+func TestPlanGroup_new(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "22.0.0")
+	defer srv.Close()
+	srv.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	srv.AddGroup("Foo Inc.", "foo")
+	c := srv.NewClient("admin", "secret")
+	ctx := context.Background()
+
+	plan, err := c.PlanGroup(ctx, NewGroup("Team", "foo", "team").WithMemberNames("alice"))
+	require.NoError(t, err)
+	assert.True(t, plan.Create)
+	assert.Equal(t, []User{{Username: "alice"}}, plan.AddMembers)
+	assert.Equal(t, []string{"/foo"}, srv.GroupPaths())
+
+	g, err := c.ApplyPlan(ctx, plan)
+	require.NoError(t, err)
+	assert.NotEmpty(t, g.ID())
+	assert.Equal(t, []string{"/foo", "/foo/team"}, srv.GroupPaths())
+	assert.Equal(t, []string{"alice"}, srv.Members("foo", "team"))
+}
+
+func TestPlanGroup_missingParent(t *testing.T) {
+	srv := keycloaktest.NewServer("appuio", "23.0.0")
+	defer srv.Close()
+	c := srv.NewClient("admin", "secret")
+
+	_, err := c.PlanGroup(context.Background(), NewGroup("Team", "foo", "team"))
+	require.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package keycloak
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// userResolver resolves usernames to Keycloak users.
+// Resolved users are cached by username for the lifetime of the resolver.
+// A resolver is created for every call to PutGroup or PlanGroup, so all lookups during a call share the cache, and users are looked up again by the next call.
+type userResolver struct {
+	c     Client
+	token *session
+
+	mu    sync.Mutex
+	cache map[string]*gocloak.User
+}
+
+func (c Client) newUserResolver(token *session) *userResolver {
+	return &userResolver{
+		c:     c,
+		token: token,
+		cache: map[string]*gocloak.User{},
+	}
+}
+
+// resolve looks up the users with the given usernames.
+// Keycloak can't search for several usernames at once, so every username not yet in the cache is looked up with its own search.
+// The searches run in parallel, up to the configured concurrency, using exact matches if the server supports them.
+// It returns the found users and the lookup errors, both by username.
+// The returned error is only set if the lookups could not be started or were cancelled.
+func (r *userResolver) resolve(ctx context.Context, usernames []string) (map[string]*gocloak.User, map[string]error, error) {
+	found := make(map[string]*gocloak.User, len(usernames))
+	failed := map[string]error{}
+
+	r.mu.Lock()
+	seen := make(map[string]struct{}, len(usernames))
+	toFind := make([]string, 0, len(usernames))
+	for _, name := range usernames {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		if usr, ok := r.cache[name]; ok {
+			found[name] = usr
+			continue
+		}
+		toFind = append(toFind, name)
+	}
+	r.mu.Unlock()
+	if len(toFind) == 0 {
+		return found, failed, nil
+	}
+
+	caps, err := r.c.capabilities(ctx, r.token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]*gocloak.User, len(toFind))
+	errs := make([]error, len(toFind))
+	err = r.c.forEach(ctx, len(toFind), func(ctx context.Context, i int) error {
+		users[i], errs[i] = r.c.getUserByName(ctx, r.token, toFind[i], caps.ExactSearch)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, name := range toFind {
+		if errs[i] != nil {
+			failed[name] = errs[i]
+			continue
+		}
+		r.cache[name] = users[i]
+		found[name] = users[i]
+	}
+	return found, failed, nil
+}
@@ -0,0 +1,61 @@
+package keycloak
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userSearchCounter serves user searches from a fixed list of usernames and counts the searches per username.
+// All other methods panic.
+type userSearchCounter struct {
+	GoCloak
+
+	users []string
+
+	mu       sync.Mutex
+	searches map[string]int
+}
+
+func (g *userSearchCounter) GetUsers(_ context.Context, _, _ string, params gocloak.GetUsersParams) ([]*gocloak.User, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.searches[*params.Username]++
+
+	var res []*gocloak.User
+	for _, u := range g.users {
+		if u == *params.Username && *params.First == 0 {
+			res = append(res, &gocloak.User{ID: gocloak.StringP(u + "-id"), Username: gocloak.StringP(u)})
+		}
+	}
+	return res, nil
+}
+
+func TestUserResolver_cached(t *testing.T) {
+	api := &userSearchCounter{users: []string{"alice", "bob"}, searches: map[string]int{}}
+	c := Client{Client: api, Realm: "foo", Concurrency: 2}
+	token := &session{JWT: &gocloak.JWT{AccessToken: "token"}, caps: &Capabilities{ExactSearch: true}}
+	r := c.newUserResolver(token)
+	ctx := context.Background()
+
+	found, failed, err := r.resolve(ctx, []string{"alice", "missing", "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice-id", *found["alice"].ID)
+	assert.ErrorIs(t, failed["missing"], UserNotFoundError{})
+
+	found, failed, err = r.resolve(ctx, []string{"alice", "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice-id", *found["alice"].ID)
+	assert.Equal(t, "bob-id", *found["bob"].ID)
+	assert.Empty(t, failed)
+
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1, "missing": 1}, api.searches, "found users are searched once per resolver")
+
+	_, _, err = c.newUserResolver(token).resolve(ctx, []string{"alice"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, api.searches["alice"], "the cache is not shared between resolvers")
+}
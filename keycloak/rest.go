@@ -0,0 +1,72 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/go-resty/resty/v2"
+)
+
+// adminRequest sends a request to an endpoint of the admin API of the realm not covered by gocloak.
+// The path segments are escaped and appended to the realm URL.
+// The response is decoded into result, if not nil.
+// Errors are returned as *gocloak.APIError, as gocloak does, so they are classified the same way.
+func (c Client) adminRequest(ctx context.Context, token *session, method string, segments []string, query map[string]string, body, result interface{}) (*resty.Response, error) {
+	escaped := []string{c.Host, "admin", "realms", url.PathEscape(c.Realm)}
+	for _, s := range segments {
+		escaped = append(escaped, url.PathEscape(s))
+	}
+
+	req := c.Client.GetRequestWithBearerAuth(ctx, token.AccessToken)
+	if result != nil {
+		req.SetResult(result)
+	}
+	if body != nil {
+		req.SetBody(body)
+	}
+	if query != nil {
+		req.SetQueryParams(query)
+	}
+	resp, err := req.Execute(method, strings.Join(escaped, "/"))
+
+	if err != nil {
+		return resp, &gocloak.APIError{
+			Code:    0,
+			Message: fmt.Sprintf("could not send request to %s: %s", strings.Join(segments, "/"), err),
+			Type:    gocloak.ParseAPIErrType(err),
+		}
+	}
+
+	if resp == nil {
+		return resp, &gocloak.APIError{
+			Message: "empty response",
+			Type:    gocloak.ParseAPIErrType(err),
+		}
+	}
+
+	if resp.IsError() {
+		var msg string
+
+		if e, ok := resp.Error().(*gocloak.HTTPErrorResponse); ok && e.NotEmpty() {
+			msg = fmt.Sprintf("%s: %s", resp.Status(), e)
+		} else {
+			msg = resp.Status()
+		}
+
+		return resp, &gocloak.APIError{
+			Code:    resp.StatusCode(),
+			Message: msg,
+			Type:    gocloak.ParseAPIErrType(err),
+		}
+	}
+	return resp, nil
+}
+
+// createdID returns the ID of a resource created by the given response from its `Location` header.
+func createdID(resp *resty.Response) string {
+	return path.Base(resp.Header().Get("Location"))
+}
@@ -1,6 +1,8 @@
 package keycloak_test
 
 import (
+	"context"
+	"fmt"
 	"strings"
 
 	"github.com/go-resty/resty/v2"
@@ -27,9 +29,14 @@ func mockLogin(mgc *MockGoCloak, c Client) {
 }
 
 func mockListGroups(mgc *MockGoCloak, c Client, groups []*gocloak.Group) {
+	mockListGroupsPage(mgc, c, 0, 100, groups)
+}
+
+func mockListGroupsPage(mgc *MockGoCloak, c Client, first, max int, groups []*gocloak.Group) {
 	mgc.EXPECT().
 		GetGroups(gomock.Any(), "token", c.Realm, gocloak.GetGroupsParams{
-			Max:                 gocloak.IntP(-1),
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(max),
 			BriefRepresentation: gocloak.BoolP(false),
 		}).
 		Return(groups, nil).
@@ -39,20 +46,45 @@ func mockListGroups(mgc *MockGoCloak, c Client, groups []*gocloak.Group) {
 func mockKeycloakSubgroups(mgc *MockGoCloak, rst *resty.Client, times int) {
 	mgc.EXPECT().
 		GetRequestWithBearerAuth(gomock.Any(), "token").
-		Return(rst.NewRequest()).
+		DoAndReturn(func(context.Context, string) *resty.Request {
+			return rst.NewRequest()
+		}).
 		Times(times)
 }
 
 func mockGetGroups(mgc *MockGoCloak, c Client, groupName string, groups []*gocloak.Group) {
 	mgc.EXPECT().
 		GetGroups(gomock.Any(), "token", c.Realm, gocloak.GetGroupsParams{
-			Max:    gocloak.IntP(-1),
+			First:  gocloak.IntP(0),
+			Max:    gocloak.IntP(100),
 			Search: gocloak.StringP(groupName),
 		}).
 		Return(groups, nil).
 		Times(1)
 }
 
+func mockGetGroup(mgc *MockGoCloak, c Client, id string, group *gocloak.Group) {
+	var err error
+	if group == nil {
+		err = &gocloak.APIError{Code: 404, Message: "404 Not Found"}
+	}
+	mgc.EXPECT().
+		GetGroup(gomock.Any(), "token", c.Realm, id).
+		Return(group, err).
+		Times(1)
+}
+
+func mockGetGroupByPath(mgc *MockGoCloak, c Client, path string, group *gocloak.Group) {
+	var err error
+	if group == nil {
+		err = &gocloak.APIError{Code: 404, Message: "404 Not Found"}
+	}
+	mgc.EXPECT().
+		GetGroupByPath(gomock.Any(), "token", c.Realm, path).
+		Return(group, err).
+		Times(1)
+}
+
 func mockCreateGroup(mgc *MockGoCloak, c Client, groupName, groupDisplayName, groupPath, groupID string) {
 	var attributes *map[string][]string
 	if groupDisplayName != "" {
@@ -101,6 +133,17 @@ func mockGetGroupMembers(mgc *MockGoCloak, c Client, groupID string, users []*go
 		Times(1)
 }
 
+func mockGetGroupMembersPage(mgc *MockGoCloak, c Client, groupID string, first, max int, users []*gocloak.User) {
+	mgc.EXPECT().
+		GetGroupMembers(gomock.Any(), "token", c.Realm, groupID, gocloak.GetGroupsParams{
+			First:               gocloak.IntP(first),
+			Max:                 gocloak.IntP(max),
+			BriefRepresentation: gocloak.BoolP(false),
+		}).
+		Return(users, nil).
+		Times(1)
+}
+
 func mockGetUser(mgc *MockGoCloak, c Client, userName, userID string) {
 	mockGetUsers(mgc, c, userName, []*gocloak.User{
 		{
@@ -113,7 +156,28 @@ func mockGetUsers(mgc *MockGoCloak, c Client, userName string, users []*gocloak.
 	mgc.EXPECT().
 		GetUsers(gomock.Any(), "token", c.Realm, gocloak.GetUsersParams{
 			Username: gocloak.StringP(userName),
-			Max:      gocloak.IntP(-1),
+			First:    gocloak.IntP(0),
+			Max:      gocloak.IntP(100),
+		}).
+		Return(users, nil).
+		Times(1)
+}
+
+func mockGetUserExact(mgc *MockGoCloak, c Client, userName, userID string) {
+	mockGetUsersExact(mgc, c, userName, []*gocloak.User{
+		{
+			ID:       gocloak.StringP(userID),
+			Username: gocloak.StringP(userName),
+		},
+	})
+}
+func mockGetUsersExact(mgc *MockGoCloak, c Client, userName string, users []*gocloak.User) {
+	mgc.EXPECT().
+		GetUsers(gomock.Any(), "token", c.Realm, gocloak.GetUsersParams{
+			Username: gocloak.StringP(userName),
+			First:    gocloak.IntP(0),
+			Max:      gocloak.IntP(100),
+			Exact:    gocloak.BoolP(true),
 		}).
 		Return(users, nil).
 		Times(1)
@@ -183,6 +247,12 @@ func setupChildGroupResponse(c Client, groupID string, childGroups []gocloak.Gro
 	httpmock.RegisterResponder("GET", getChildGroupUrl(c.Host, c.Realm, groupID), httpmock.NewJsonResponderOrPanic(200, childGroups))
 }
 
+func setupChildGroupPageResponse(c Client, groupID string, first, max int, childGroups []gocloak.Group) {
+	httpmock.RegisterResponderWithQuery("GET", getChildGroupUrl(c.Host, c.Realm, groupID),
+		fmt.Sprintf("first=%d&max=%d&briefRepresentation=false", first, max),
+		httpmock.NewJsonResponderOrPanic(200, childGroups))
+}
+
 func getChildGroupUrl(host, realm, groupID string) string {
 	return strings.Join([]string{host, "admin", "realms", realm, "groups", groupID, "children"}, "/")
 }
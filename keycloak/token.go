@@ -0,0 +1,117 @@
+package keycloak
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// tokenRefreshMargin is the time before the actual expiry at which a cached token is considered expired.
+// This makes sure a token does not expire while a request is in flight.
+const tokenRefreshMargin = 10 * time.Second
+
+// session is an authenticated session with the Keycloak admin API.
+// It caches the capabilities of the server for its lifetime.
+type session struct {
+	*gocloak.JWT
+
+	capsMu sync.Mutex
+	caps   *Capabilities
+}
+
+// cachedCapabilities returns the capabilities cached by the session, or nil if they are not known yet.
+func (s *session) cachedCapabilities() *Capabilities {
+	s.capsMu.Lock()
+	defer s.capsMu.Unlock()
+	return s.caps
+}
+
+// tokenSource caches the session used to talk to the Keycloak admin API.
+// It is safe for concurrent use and shared by all copies of a Client.
+type tokenSource struct {
+	mu sync.Mutex
+
+	token         *session
+	expiry        time.Time
+	refreshExpiry time.Time
+	// invalidated is set if the cached token was rejected by Keycloak and must not be used or refreshed.
+	invalidated bool
+
+	now func() time.Time
+}
+
+func newTokenSource() *tokenSource {
+	return &tokenSource{now: time.Now}
+}
+
+// get returns a session with a valid token.
+// A cached token is returned as long as it is valid, an expired token is refreshed with its refresh token if possible.
+// If there is no cached token or refreshing fails, login is called to start a new session.
+// A replaced session is ended with logout unless its refresh token expired, in which case Keycloak already ended it.
+// Failures to end the replaced session are ignored.
+func (ts *tokenSource) get(ctx context.Context,
+	login func(context.Context) (*gocloak.JWT, error),
+	refresh func(context.Context, *gocloak.JWT) (*gocloak.JWT, error),
+	logout func(context.Context, *gocloak.JWT) error,
+) (*session, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := ts.now()
+	valid := ts.token != nil && !ts.invalidated
+	if valid && now.Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	if valid && ts.token.RefreshToken != "" && now.Before(ts.refreshExpiry) {
+		token, err := refresh(ctx, ts.token.JWT)
+		if err == nil {
+			ts.set(&session{JWT: token, caps: ts.token.cachedCapabilities()}, now)
+			return ts.token, nil
+		}
+	}
+
+	var replaced *session
+	if ts.token != nil && now.Before(ts.refreshExpiry) {
+		replaced = ts.token
+	}
+	ts.token = nil
+	token, err := login(ctx)
+	if replaced != nil {
+		_ = logout(ctx, replaced.JWT)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ts.set(&session{JWT: token}, now)
+	return ts.token, nil
+}
+
+func (ts *tokenSource) set(token *session, issued time.Time) {
+	ts.token = token
+	ts.invalidated = false
+	ts.expiry = issued.Add(time.Duration(token.ExpiresIn)*time.Second - tokenRefreshMargin)
+	ts.refreshExpiry = issued.Add(time.Duration(token.RefreshExpiresIn)*time.Second - tokenRefreshMargin)
+}
+
+// invalidate marks the given token as rejected if it is still cached.
+// The next call to get will log in again and end the session of the token.
+func (ts *tokenSource) invalidate(token *session) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.invalidated = true
+	}
+}
+
+// take removes the cached token from the source and returns it.
+// Returns nil if no token is cached.
+func (ts *tokenSource) take() *session {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	token := ts.token
+	ts.token = nil
+	return token
+}
@@ -0,0 +1,40 @@
+package keycloak
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the client.
+// It uses the global tracer provider, which does not record anything unless configured.
+var tracer = otel.Tracer("github.com/vshn/appuio-keycloak-adapter/keycloak")
+
+// startSpan starts a span for an operation of the client.
+func (c Client) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "keycloak.Client/"+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("keycloak.realm", c.Realm))...))
+}
+
+// endSpan records the given error, if any, and ends the span.
+// Returns the error unchanged.
+func endSpan(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
+
+func groupPathAttr(g Group) attribute.KeyValue {
+	return attribute.String("keycloak.group.path", g.Path())
+}
+
+func usernameAttr(username string) attribute.KeyValue {
+	return attribute.String("keycloak.user.name", username)
+}
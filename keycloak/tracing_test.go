@@ -0,0 +1,54 @@
+package keycloak_test
+
+import (
+	"context"
+	"testing"
+
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	. "github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func TestTracing_spans(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	mKeycloak := NewMockGoCloak(ctrl)
+	c := Client{
+		Client: mKeycloak,
+		Realm:  "foo",
+	}
+	mockLogin(mKeycloak, c)
+	mockGetUser(mKeycloak, c, "user", "user-id")
+	mKeycloak.EXPECT().
+		UpdateUser(gomock.Any(), "token", "foo", gomock.Any()).
+		Return(nil).
+		Times(1)
+
+	_, err := c.PutUser(context.Background(), User{Username: "user"})
+	require.NoError(t, err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, "keycloak.Client/PutUser")
+	op := spans["keycloak.Client/PutUser"]
+	assert.Contains(t, op.Attributes(), attribute.String("keycloak.user.name", "user"))
+	assert.Contains(t, op.Attributes(), attribute.String("keycloak.realm", "foo"))
+
+	for _, method := range []string{"LoginAdmin", "GetUsers", "UpdateUser"} {
+		require.Contains(t, spans, "keycloak.GoCloak/"+method)
+		assert.Equal(t, op.SpanContext().SpanID(), spans["keycloak.GoCloak/"+method].Parent().SpanID(), "request span is a child of the operation span")
+	}
+	assert.Contains(t, spans["keycloak.GoCloak/UpdateUser"].Attributes(), attribute.String("keycloak.status_class", "2xx"))
+}
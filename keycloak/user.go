@@ -1,25 +1,31 @@
 package keycloak
 
-import "github.com/Nerzal/gocloak/v13"
+import (
+	"reflect"
+
+	"github.com/Nerzal/gocloak/v13"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
 
 const (
 	// KeycloakDefaultOrganizationRef references the keycloak user attribute.
-	// TODO(bastjan) If we add more attributes I'd use struct tags struct{ DefaultOrganizationRef string `kcattr:"appuio.io/default-organization"` }
 	KeycloakDefaultOrganizationRef = "appuio.io/default-organization"
+	// KeycloakPreferredLanguage references the keycloak user attribute.
+	// It is the attribute Keycloak itself uses to store the locale of a user.
+	KeycloakPreferredLanguage = "locale"
+	// KeycloakBillingContact references the keycloak user attribute.
+	KeycloakBillingContact = "appuio.io/billing-contact"
+	// KeycloakNotificationPreferences references the keycloak user attribute.
+	KeycloakNotificationPreferences = "appuio.io/notifications"
 )
 
-// User is a representation of a user in keycloak
-type User struct {
-	ID string
-	// Username is the .metadata.name in kubernetes and the .Username field in Keycloak
-	Username string
-
-	Email     string
-	FirstName string
-	LastName  string
+// userAttributeFields are the fields of User mapped to Keycloak user attributes.
+var userAttributeFields = attributeFields(reflect.TypeOf(User{}))
 
-	DefaultOrganizationRef string
-}
+// User is a representation of a user in keycloak.
+// Fields tagged with `kcattr` are mapped to the Keycloak user attribute of the given name.
+type User = idp.User
 
 // UserFromKeycloakUser returns a user with attributes mapped from the given keycloak user
 func UserFromKeycloakUser(u gocloak.User) User {
@@ -41,28 +47,15 @@ func UserFromKeycloakUser(u gocloak.User) User {
 	}
 
 	if u.Attributes != nil {
-		if ref, ok := (*u.Attributes)[KeycloakDefaultOrganizationRef]; ok && len(ref) > 0 {
-			r.DefaultOrganizationRef = ref[0]
-		}
+		readAttributes(userAttributeFields, *u.Attributes, reflect.ValueOf(&r).Elem())
 	}
 
 	return r
 }
 
-// DisplayName returns the disply name of this user
-func (u User) DisplayName() string {
-	if u.FirstName == "" {
-		return u.LastName
-	}
-	if u.LastName == "" {
-		return u.FirstName
-	}
-
-	return u.FirstName + " " + u.LastName
-}
-
-// ApplyTo sets attributes from this user to the given gocloak.User
-func (u User) ApplyTo(tu *gocloak.User) {
+// ApplyUserTo sets attributes from the given user to the given gocloak.User.
+// Empty fields are not changed, except for the attributes the user clears, see the `kcattr` tags of User.
+func ApplyUserTo(u User, tu *gocloak.User) {
 	if u.ID != "" {
 		tu.ID = &u.ID
 	}
@@ -79,12 +72,20 @@ func (u User) ApplyTo(tu *gocloak.User) {
 		tu.LastName = &u.LastName
 	}
 
-	if u.DefaultOrganizationRef != "" {
+	attrs := make(map[string][]string)
+	removed := writeAttributes(userAttributeFields, reflect.ValueOf(u), attrs)
+	if tu.Attributes != nil {
+		for _, k := range removed {
+			delete(*tu.Attributes, k)
+		}
+	}
+	if len(attrs) > 0 {
 		if tu.Attributes == nil {
-			attr := make(map[string][]string)
-			tu.Attributes = &attr
+			tu.Attributes = &attrs
+			return
+		}
+		for k, v := range attrs {
+			(*tu.Attributes)[k] = v
 		}
-
-		(*tu.Attributes)[KeycloakDefaultOrganizationRef] = []string{u.DefaultOrganizationRef}
 	}
 }
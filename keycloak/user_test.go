@@ -1,6 +1,7 @@
 package keycloak
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -36,7 +37,7 @@ func TestUser_DisplayName(t *testing.T) {
 	require.Equal(t, "Bar", User{LastName: "Bar"}.DisplayName())
 }
 
-func TestUser_ApplyTo(t *testing.T) {
+func TestApplyUserTo(t *testing.T) {
 	user := User{
 		ID:                     "ID",
 		Username:               "Username",
@@ -56,18 +57,18 @@ func TestUser_ApplyTo(t *testing.T) {
 		KeycloakDefaultOrganizationRef: {"DefaultOrganizationRef"},
 	}
 	subject := baseKeycloakUser()
-	user.ApplyTo(&subject)
+	ApplyUserTo(user, &subject)
 	require.Equal(t, expected, subject, "overwrite all attributes")
 
 	subject = baseKeycloakUser()
 	subject.Attributes = nil
-	user.ApplyTo(&subject)
+	ApplyUserTo(user, &subject)
 	require.Equal(t, &map[string][]string{
 		KeycloakDefaultOrganizationRef: {"DefaultOrganizationRef"},
 	}, subject.Attributes, "create .Attributes if nil")
 
 	subject = baseKeycloakUser()
-	User{}.ApplyTo(&subject)
+	ApplyUserTo(User{}, &subject)
 	require.Equal(t, baseKeycloakUser(), subject, "no attributes overridden")
 }
 
@@ -89,3 +90,146 @@ func baseKeycloakUser() gocloak.User {
 func p[T any](a T) *T {
 	return &a
 }
+
+func TestUser_AttributesRoundTrip(t *testing.T) {
+	tcs := map[string]struct {
+		user  User
+		attrs map[string][]string
+	}{
+		"empty": {
+			user:  User{},
+			attrs: nil,
+		},
+		"default organization": {
+			user: User{DefaultOrganizationRef: "foo"},
+			attrs: map[string][]string{
+				KeycloakDefaultOrganizationRef: {"foo"},
+			},
+		},
+		"preferred language": {
+			user: User{PreferredLanguage: "de-CH"},
+			attrs: map[string][]string{
+				KeycloakPreferredLanguage: {"de-CH"},
+			},
+		},
+		"billing contact": {
+			user: User{BillingContact: p(true)},
+			attrs: map[string][]string{
+				KeycloakBillingContact: {"true"},
+			},
+		},
+		"not a billing contact": {
+			user: User{BillingContact: p(false)},
+			attrs: map[string][]string{
+				KeycloakBillingContact: {"false"},
+			},
+		},
+		"multi-valued notification preferences": {
+			user: User{NotificationPreferences: []string{"maintenance", "billing"}},
+			attrs: map[string][]string{
+				KeycloakNotificationPreferences: {"maintenance", "billing"},
+			},
+		},
+		"all attributes": {
+			user: User{
+				DefaultOrganizationRef:  "foo",
+				PreferredLanguage:       "en",
+				BillingContact:          p(true),
+				NotificationPreferences: []string{"maintenance"},
+			},
+			attrs: map[string][]string{
+				KeycloakDefaultOrganizationRef:  {"foo"},
+				KeycloakPreferredLanguage:       {"en"},
+				KeycloakBillingContact:          {"true"},
+				KeycloakNotificationPreferences: {"maintenance"},
+			},
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			kcUser := gocloak.User{}
+			ApplyUserTo(tc.user, &kcUser)
+			if tc.attrs == nil {
+				require.Nil(t, kcUser.Attributes)
+			} else {
+				require.Equal(t, &tc.attrs, kcUser.Attributes)
+			}
+			require.Equal(t, tc.user, UserFromKeycloakUser(kcUser))
+		})
+	}
+}
+
+func TestApplyUserTo_ClearAttributes(t *testing.T) {
+	attrs := func() map[string][]string {
+		return map[string][]string{
+			KeycloakDefaultOrganizationRef:  {"foo"},
+			KeycloakPreferredLanguage:       {"de-CH"},
+			KeycloakBillingContact:          {"true"},
+			KeycloakNotificationPreferences: {"maintenance", "billing"},
+			"example.com/dark-mode":         {"true"},
+		}
+	}
+
+	tcs := map[string]struct {
+		user    User
+		removed []string
+	}{
+		"empty default organization": {
+			user:    User{},
+			removed: []string{KeycloakDefaultOrganizationRef},
+		},
+		"empty notification preferences": {
+			user:    User{DefaultOrganizationRef: "foo", NotificationPreferences: []string{}},
+			removed: []string{KeycloakNotificationPreferences},
+		},
+		"unknown notification preferences": {
+			user: User{DefaultOrganizationRef: "foo", NotificationPreferences: nil},
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			expected := attrs()
+			for _, k := range tc.removed {
+				delete(expected, k)
+			}
+			kcUser := gocloak.User{Attributes: p(attrs())}
+			ApplyUserTo(tc.user, &kcUser)
+			require.Equal(t, &expected, kcUser.Attributes)
+		})
+	}
+}
+
+func TestUserFromKeycloakUser_Attributes(t *testing.T) {
+	u := UserFromKeycloakUser(gocloak.User{
+		Attributes: &map[string][]string{
+			KeycloakDefaultOrganizationRef: {"foo", "bar"},
+			KeycloakPreferredLanguage:      {},
+			KeycloakBillingContact:         {"not-a-bool"},
+			"example.com/dark-mode":        {"true"},
+		},
+	})
+	require.Equal(t, User{DefaultOrganizationRef: "foo"}, u, "use first value, ignore empty and invalid values")
+}
+
+func TestAttributeFields_UnsupportedType(t *testing.T) {
+	require.Panics(t, func() {
+		attributeFields(reflect.TypeOf(struct {
+			Count int `kcattr:"count"`
+		}{}))
+	})
+}
+
+func TestAttributeFields_UnsupportedOption(t *testing.T) {
+	require.Panics(t, func() {
+		attributeFields(reflect.TypeOf(struct {
+			Notifications []string `kcattr:"notifications,clear"`
+		}{}))
+	})
+	require.Panics(t, func() {
+		attributeFields(reflect.TypeOf(struct {
+			Name string `kcattr:"name,omitempty"`
+		}{}))
+	})
+}
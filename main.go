@@ -17,13 +17,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
-	"github.com/go-logr/logr"
 	"github.com/robfig/cron/v3"
 
 	orgv1 "github.com/appuio/control-api/apis/organization/v1"
 	controlv1 "github.com/appuio/control-api/apis/v1"
 
 	"github.com/vshn/appuio-keycloak-adapter/controllers"
+	"github.com/vshn/appuio-keycloak-adapter/idp"
 	"github.com/vshn/appuio-keycloak-adapter/keycloak"
 
 	//+kubebuilder:scaffold:imports
@@ -58,16 +58,50 @@ func main() {
 
 	host := flag.String("keycloak-url", "", "The address of the Keycloak server (E.g. `https://keycloak.example.com`).")
 	realm := flag.String("keycloak-realm", "", "The realm to sync the groups to.")
-	loginRealm := flag.String("keycloak-login-realm", "", "The realm to log in to the Keycloak server. `keycloak-realm` is used if not set.")
+	loginRealm := flag.String("keycloak-login-realm", "", "The realm to log in to the Keycloak server. keycloak-realm is used if not set.")
 	username := flag.String("keycloak-username", "", "The username to log in to the Keycloak server.")
 	password := flag.String("keycloak-password", "", "The password to log in to the Keycloak server.")
+	clientID := flag.String("keycloak-client-id", "", "The client ID to log in to the Keycloak server using the client credentials grant. keycloak-username and keycloak-password are ignored if set.")
+	clientSecret := flag.String("keycloak-client-secret", "", "The secret of the client set in keycloak-client-id.")
+	clientKeyFile := flag.String("keycloak-client-key-file", "", "A PEM encoded private key to sign a JWT client assertion with. Used instead of keycloak-client-secret if set.")
+
+	backend := flag.String("keycloak-backend", backendGroups, "The `backend` organizations, teams, and users are synced to. Either groups or organizations to use Keycloak groups or Keycloak Organizations, or scim to sync to the SCIM service provider set in scim-url instead of Keycloak. Keycloak Organizations require Keycloak 25 or later.")
+	orgDomainSuffix := flag.String("keycloak-organization-domain-suffix", "", "If set, new Keycloak Organizations get a domain of their name and this `suffix`, e.g. foo.example.com for suffix example.com. Keycloak 25 requires organizations to have a domain.")
+	scimURL := flag.String("scim-url", "", "The base URL of the SCIM 2.0 service provider used by the scim backend (E.g. `https://idp.example.com/scim/v2`).")
+	scimTokenFile := flag.String("scim-token-file", "", "A file containing the bearer token to authenticate to the SCIM service provider.")
+	targetsFile := flag.String("keycloak-targets-file", "", "A YAML file listing additional Keycloak realms to sync organizations, teams, and users to. The realm set by the other keycloak flags is the primary realm organizations are imported from.")
+
+	concurrency := flag.Int("keycloak-concurrency", 4, "The maximum number of parallel requests to the Keycloak server when listing groups and their members.")
+	pageSize := flag.Int("keycloak-page-size", 100, "The number of groups or group members to request at once from the Keycloak server.")
+	maxDepth := flag.Int("keycloak-max-depth", 0, "The maximum number of levels of nested Keycloak groups to list. Lists all levels if 0.")
+	maxRetries := flag.Int("keycloak-max-retries", 3, "The number of times a request to the Keycloak server is retried on network errors, server errors, or rate limiting. Requests creating groups or organizations are never retried. Disabled if negative.")
+	retryBackoff := flag.Duration("keycloak-retry-backoff", 200*time.Millisecond, "The initial upper bound of the random delay before retrying a request to the Keycloak server. Doubles with every retry.")
+	readinessMaxAge := flag.Duration("keycloak-readiness-max-age", time.Minute, "How long a successful login to the Keycloak server, or the SCIM service provider, is trusted by the readiness check before logging in again. The connection is not checked if 0.")
+	readinessTimeout := flag.Duration("keycloak-readiness-timeout", 5*time.Second, "The timeout for logging in to the Keycloak server, or the SCIM service provider, in the readiness check.")
 
 	organizationRoot := flag.String("organization-root", "", "The Keycloak top-level group under which the organizations are synced.")
+	attrBillingEntity := flag.String("organization-attribute-billing-entity", "", "The Keycloak group attribute to sync the billing entity reference of an organization to. Not synced if empty.")
+	attrCreationTimestamp := flag.String("organization-attribute-creation-timestamp", "", "The Keycloak group attribute to sync the creation timestamp of an organization to. Not synced if empty.")
+	attrLabels := flag.String("organization-attribute-labels", "", "A comma separated list of organization labels to sync to Keycloak group attributes of the same name.")
+	attrAnnotationPrefix := flag.String("organization-attribute-annotation-prefix", "", "Organization annotations with this prefix are synced to Keycloak group attributes of the same name. Not synced if empty.")
+
+	userDeletionPolicy := flag.String("user-deletion-policy", string(controllers.UserDeletionPolicyKeep), "What happens to the Keycloak user if a User is deleted. One of `keep`, `remove-from-groups`, `disable` or `delete`. remove-from-groups requires organization-root, and a root group for every realm in keycloak-targets-file, as it removes the user from all groups below the root group.")
 
 	crontab := flag.String("sync-schedule", "@every 5m", "A cron style schedule for the organization synchronization interval.")
 	timeout := flag.Duration("sync-timeout", 10*time.Second, "The timeout for a single synchronization run.")
+	userCrontab := flag.String("user-sync-schedule", "@every 15m", "A cron style schedule for refreshing the status of Users from Keycloak. Disabled if empty.")
+	syncJitter := flag.Duration("sync-jitter", 0, "The maximum of a random delay added to every scheduled synchronization run. No delay if 0.")
+	syncAttempts := flag.Int("sync-max-attempts", controllers.DefaultBackoff.Attempts, "The number of attempts of a failing synchronization run before giving up until the next scheduled run.")
+	syncBackoff := flag.Duration("sync-retry-backoff", controllers.DefaultBackoff.Initial, "The delay before retrying a failed synchronization run. Doubles with every retry.")
+	syncMaxBackoff := flag.Duration("sync-max-retry-backoff", 0, "The maximum delay before retrying a failed synchronization run. Not limited if 0.")
+	syncLivenessIntervals := flag.Int("sync-liveness-intervals", 3, "The number of schedule intervals without a finished synchronization run after which the liveness check fails. Disabled if 0.")
+	syncTriggerAddr := flag.String("sync-trigger-bind-address", "", "The address the endpoint to trigger synchronizations on demand binds to (E.g. `127.0.0.1:8082`). Disabled if empty.")
 	syncRoles := flag.String("sync-roles", "", "A comma separated list of cluster roles to bind to users when importing a new organization.")
-	syncRolesUserPrefix := flag.String("sync-roles-user-prefix", "appuio#", "A prefix given to the users when assigning cluster roles from `sync-roles`.")
+	syncRolesUserPrefix := flag.String("sync-roles-user-prefix", "appuio#", "A prefix given to the users when assigning cluster roles from sync-roles.")
+
+	dryRun := flag.Bool("dry-run", false, "Log and event all changes to Keycloak and Kubernetes without executing them.")
+
+	tracingFile := flag.String("tracing-file", "", "The file to write spans to if OTEL_TRACES_EXPORTER is set to console. Writes to stdout if empty.")
 
 	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
@@ -76,19 +110,118 @@ func main() {
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 	ctx := ctrl.SetupSignalHandler()
 
+	shutdownTracing, err := setupTracing(ctx, *tracingFile)
+	if err != nil {
+		setupLog.Error(err, "unable to setup tracing")
+		os.Exit(1)
+	}
+
 	var roles []string
 	if *syncRoles != "" {
 		roles = strings.Split(*syncRoles, ",")
 	}
+	deletionPolicy, err := controllers.ParseUserDeletionPolicy(*userDeletionPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid user deletion policy")
+		os.Exit(1)
+	}
+	orgAttrs := controllers.OrganizationAttributes{
+		BillingEntityRef:  *attrBillingEntity,
+		CreationTimestamp: *attrCreationTimestamp,
+		AnnotationPrefix:  *attrAnnotationPrefix,
+	}
+	if *attrLabels != "" {
+		orgAttrs.Labels = strings.Split(*attrLabels, ",")
+	}
 
-	kc := keycloak.NewClient(*host, *realm, *username, *password)
-	kc.RootGroup = *organizationRoot
-	kc.LoginRealm = *loginRealm
+	targets := []keycloakTarget{{
+		Name:          *realm,
+		URL:           *host,
+		Realm:         *realm,
+		LoginRealm:    *loginRealm,
+		RootGroup:     *organizationRoot,
+		Username:      *username,
+		Password:      *password,
+		ClientID:      *clientID,
+		ClientSecret:  *clientSecret,
+		ClientKeyFile: *clientKeyFile,
+
+		Backend:                  *backend,
+		OrganizationDomainSuffix: *orgDomainSuffix,
+	}}
+	if *backend == backendSCIM {
+		targets[0] = keycloakTarget{Name: backendSCIM, URL: *scimURL, Backend: backendSCIM, TokenFile: *scimTokenFile}
+	}
+	if *targetsFile != "" {
+		extra, err := readKeycloakTargets(*targetsFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read Keycloak targets")
+			os.Exit(1)
+		}
+		targets = append(targets, extra...)
+	}
+	if err := validateKeycloakTargets(targets); err != nil {
+		setupLog.Error(err, "invalid Keycloak targets")
+		os.Exit(1)
+	}
+	if err := validateUserDeletionPolicy(targets, deletionPolicy); err != nil {
+		setupLog.Error(err, "invalid user deletion policy")
+		os.Exit(1)
+	}
+	tuning := keycloakTuning{
+		PageSize:     *pageSize,
+		Concurrency:  *concurrency,
+		MaxDepth:     *maxDepth,
+		MaxRetries:   *maxRetries,
+		RetryBackoff: *retryBackoff,
+	}
+	// clients are the Keycloak clients of the targets, which need to be closed on shutdown.
+	clients := map[string]keycloak.Client{}
+	// pingers check the connection to the targets for the readiness check.
+	pingers := map[string]idp.Pinger{}
+	realmTargets := make([]controllers.RealmTarget, len(targets))
+	for i, t := range targets {
+		if t.Backend == backendSCIM {
+			sc, err := newSCIMClient(t, tuning)
+			if err != nil {
+				setupLog.Error(err, "unable to setup SCIM client", "target", t.Name)
+				os.Exit(1)
+			}
+			realmTargets[i] = controllers.RealmTarget{Name: t.Name, Client: sc}
+			pingers[t.Name] = sc
+			continue
+		}
+		kc, err := newKeycloakClient(t, tuning)
+		if err != nil {
+			setupLog.Error(err, "unable to setup Keycloak client", "target", t.Name)
+			os.Exit(1)
+		}
+		clients[t.Name] = kc
+		pingers[t.Name] = kc
+		realmTargets[i] = controllers.RealmTarget{Name: t.Name, Client: syncClient(t, kc)}
+	}
+	kc := realmTargets[0].Client
+	if len(realmTargets) > 1 {
+		kc = controllers.MultiRealmKeycloakClient{Targets: realmTargets}
+	}
 
+	syncs := &controllers.SyncRunnable{
+		Timeout: *timeout,
+		Jitter:  *syncJitter,
+		Backoff: controllers.Backoff{
+			Attempts: *syncAttempts,
+			Initial:  *syncBackoff,
+			Max:      *syncMaxBackoff,
+		},
+	}
 	mgr, or, err := setupManager(
 		kc,
+		syncs,
 		roles,
 		*syncRolesUserPrefix,
+		orgAttrs,
+		deletionPolicy,
+		*dryRun,
 		ctrl.Options{
 			Scheme:                 scheme,
 			MetricsBindAddress:     *metricsAddr,
@@ -102,12 +235,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	c, err := setupSync(ctx, or, *crontab, *timeout)
-	if err != nil {
+	if err := setupSync(mgr, syncs, or, *crontab, *userCrontab, *syncTriggerAddr); err != nil {
 		setupLog.Error(err, "unable to setup sync")
 		os.Exit(1)
 	}
-	c.Start()
+	if err := setupHealthChecks(mgr, pingers, *readinessMaxAge, *readinessTimeout, syncs, *syncLivenessIntervals); err != nil {
+		setupLog.Error(err, "unable to setup health checks")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctx); err != nil {
@@ -115,25 +250,43 @@ func main() {
 		os.Exit(1)
 	}
 	setupLog.Info("stopping..")
-	<-c.Stop().Done()
+	for name, c := range clients {
+		if err := c.Close(context.Background()); err != nil {
+			setupLog.Error(err, "failed to close Keycloak session", "target", name)
+		}
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "failed to flush traces")
+	}
 }
 
-func setupManager(kc controllers.KeycloakClient, syncRoles []string, syncRolesUserPrefix string, opt ctrl.Options) (ctrl.Manager, *controllers.PeriodicSyncer, error) {
+func setupManager(kc controllers.KeycloakClient, syncTrigger controllers.SyncTrigger, syncRoles []string, syncRolesUserPrefix string, orgAttrs controllers.OrganizationAttributes, userDeletionPolicy controllers.UserDeletionPolicy, dryRun bool, opt ctrl.Options) (ctrl.Manager, *controllers.PeriodicSyncer, error) {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), opt)
 	if err != nil {
 		return nil, nil, err
 	}
+	c := mgr.GetClient()
+	if dryRun {
+		setupLog.Info("running in dry-run mode, no changes are made to Keycloak or Kubernetes")
+		recorder := mgr.GetEventRecorderFor("keycloak-adapter")
+		c = controllers.DryRunClient{Client: c, Recorder: recorder}
+		kc = controllers.DryRunKeycloakClient{KeycloakClient: kc, Recorder: recorder}
+	}
 	or := &controllers.OrganizationReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("keycloak-adapter"),
-		Keycloak: kc,
+		Client:     c,
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("keycloak-adapter"),
+		Keycloak:   kc,
+		Attributes: orgAttrs,
+
+		SyncTrigger: syncTrigger,
+		DryRun:      dryRun,
 	}
 	if err = or.SetupWithManager(mgr); err != nil {
 		return nil, nil, err
 	}
 	tr := &controllers.TeamReconciler{
-		Client:   mgr.GetClient(),
+		Client:   c,
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("keycloak-adapter"),
 		Keycloak: kc,
@@ -142,10 +295,11 @@ func setupManager(kc controllers.KeycloakClient, syncRoles []string, syncRolesUs
 		return nil, nil, err
 	}
 	ur := &controllers.UserReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("keycloak-adapter"),
-		Keycloak: kc,
+		Client:         c,
+		Scheme:         mgr.GetScheme(),
+		Recorder:       mgr.GetEventRecorderFor("keycloak-adapter"),
+		Keycloak:       kc,
+		DeletionPolicy: userDeletionPolicy,
 	}
 	if err = ur.SetupWithManager(mgr); err != nil {
 		return nil, nil, err
@@ -153,11 +307,13 @@ func setupManager(kc controllers.KeycloakClient, syncRoles []string, syncRolesUs
 	//+kubebuilder:scaffold:builder
 
 	ps := &controllers.PeriodicSyncer{
-		Client:                     mgr.GetClient(),
+		Client:                     c,
 		Recorder:                   mgr.GetEventRecorderFor("keycloak-adapter"),
 		Keycloak:                   kc,
 		SyncClusterRoles:           syncRoles,
 		SyncClusterRolesUserPrefix: syncRolesUserPrefix,
+		Attributes:                 orgAttrs,
+		DryRun:                     dryRun,
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -169,48 +325,50 @@ func setupManager(kc controllers.KeycloakClient, syncRoles []string, syncRolesUs
 	return mgr, ps, err
 }
 
-func setupSync(ctx context.Context, r *controllers.PeriodicSyncer, crontab string, timeout time.Duration) (*cron.Cron, error) {
-	syncLog := ctrl.Log.WithName("sync")
-	c := cron.New()
-	_, err := c.AddFunc(crontab, func() {
-		err := runWithBackoff(ctx,
-			func() error {
-				rCtx, cancel := context.WithTimeout(ctx, timeout)
-				rCtx = logr.NewContext(rCtx, syncLog)
-				defer cancel()
-
-				return r.Sync(rCtx)
-			},
-			func(err error) {
-				syncLog.Error(err, "failed to import Keycloak groups")
-			})
+func setupSync(mgr ctrl.Manager, syncs *controllers.SyncRunnable, r *controllers.PeriodicSyncer, crontab, userCrontab, triggerAddr string) error {
+	schedule, err := cron.ParseStandard(crontab)
+	if err != nil {
+		return err
+	}
+	syncs.Jobs = []controllers.SyncJob{{
+		Name:         controllers.ImportSyncJob,
+		Schedule:     schedule,
+		Sync:         r.Sync,
+		ErrorMessage: "failed to import Keycloak groups",
+	}}
+	if userCrontab != "" {
+		userSchedule, err := cron.ParseStandard(userCrontab)
 		if err != nil {
-			syncLog.Info("failed to import Keycloak groups - giving up")
+			return err
 		}
-	})
-	if err != nil {
-		return nil, err
+		syncs.Jobs = append(syncs.Jobs, controllers.SyncJob{
+			Name:         controllers.UserStatusSyncJob,
+			Schedule:     userSchedule,
+			Sync:         r.SyncUserStatus,
+			ErrorMessage: "failed to refresh User status",
+		})
 	}
-	return c, nil
+	if err := mgr.Add(syncs); err != nil {
+		return err
+	}
+	if triggerAddr == "" {
+		return nil
+	}
+	return mgr.Add(&controllers.SyncTriggerServer{Addr: triggerAddr, Trigger: syncs})
 }
 
-func runWithBackoff(ctx context.Context, run func() error, errRecorder func(err error)) error {
-	var err error
-	backoff := 500 * time.Millisecond
-	for i := 0; i < 6; i++ {
-		err = run()
-		if err == nil {
-			return nil
-		}
-
-		errRecorder(err)
-
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-time.After(backoff):
+// setupHealthChecks adds a readiness check for the connection to every target, and a liveness check for stuck synchronizations.
+func setupHealthChecks(mgr ctrl.Manager, pingers map[string]idp.Pinger, maxAge, timeout time.Duration, syncs *controllers.SyncRunnable, intervals int) error {
+	if maxAge > 0 {
+		for name, p := range pingers {
+			check := &controllers.ConnectivityCheck{Pinger: p, MaxAge: maxAge, Timeout: timeout}
+			if err := mgr.AddReadyzCheck("idp-"+name, check.Check); err != nil {
+				return err
+			}
 		}
-		backoff *= 2
 	}
-	return err
+	if intervals > 0 {
+		return mgr.AddHealthzCheck("sync", syncs.LivenessCheck(intervals))
+	}
+	return nil
 }
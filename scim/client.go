@@ -0,0 +1,512 @@
+// Package scim implements idp.Client for identity providers speaking SCIM 2.0, see RFC 7643 and RFC 7644.
+//
+// SCIM groups are flat, so the path of a group is stored in its `externalId` and only groups with an external ID starting with `/` are managed by the client.
+// The display name of a group is its `displayName`, group attributes are not synced.
+// Users are never created, the client only updates existing users and their group memberships.
+// Attributes of idp.User without a counterpart in the SCIM core user schema are stored in the extension SchemaUserExtension.
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
+
+// Client syncs groups and users to a SCIM 2.0 service provider.
+type Client struct {
+	// URL is the base URL of the SCIM service provider, such as `https://idp.example.com/scim/v2`.
+	URL string
+	// Token is sent as bearer token with every request.
+	Token string
+
+	// HTTPClient sends the requests.
+	// Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// PageSize is the number of resources requested at once when listing them.
+	// Defaults to 100.
+	PageSize int
+}
+
+var _ idp.Client = Client{}
+
+// NewClient creates a new Client.
+func NewClient(baseURL, token string) Client {
+	return Client{
+		URL:   strings.TrimSuffix(baseURL, "/"),
+		Token: token,
+	}
+}
+
+// PutGroup creates or updates the provided group and sets its members.
+// Members which don't exist are reported as MembershipSyncErrors.
+// If any other member can't be looked up, the group is not changed, so its existing members are kept.
+// The method is idempotent.
+func (c Client) PutGroup(ctx context.Context, g idp.Group) (idp.Group, error) {
+	res := idp.NewGroup(g.DisplayName(), g.PathMembers()...)
+
+	found, err := c.findGroup(ctx, g)
+	if err != nil {
+		return res, fmt.Errorf("failed finding group %s: %w", g.Path(), err)
+	}
+
+	users, members, membErr, err := c.resolveMembers(ctx, g.Members)
+	if err != nil {
+		// Putting the group without the members which could not be looked up would remove them.
+		return res, fmt.Errorf("failed resolving members of group %s: %w", g.Path(), err)
+	}
+	desired := group{
+		Schemas:     []string{schemaGroup},
+		ExternalID:  g.Path(),
+		DisplayName: groupDisplayName(g),
+		Members:     members,
+	}
+	if found == nil {
+		var created group
+		if err := c.do(ctx, http.MethodPost, []string{"Groups"}, nil, desired, &created); err != nil {
+			return res, fmt.Errorf("failed creating group %s: %w", g.Path(), err)
+		}
+		found = &created
+	} else if found.DisplayName != desired.DisplayName || !sameMembers(found.Members, desired.Members) {
+		desired.ID = found.ID
+		if err := c.do(ctx, http.MethodPut, []string{"Groups", found.ID}, nil, desired, nil); err != nil {
+			return res, fmt.Errorf("failed updating group %s: %w", g.Path(), err)
+		}
+	}
+
+	res = res.WithID(found.ID)
+	res.Members = users
+	if len(membErr) > 0 {
+		return res, &membErr
+	}
+	return res, nil
+}
+
+// DeleteGroup deletes the provided group and all groups below it.
+// The method is idempotent.
+func (c Client) DeleteGroup(ctx context.Context, g idp.Group) error {
+	found, err := c.findGroup(ctx, g)
+	if err != nil {
+		return fmt.Errorf("failed finding group %s: %w", g.Path(), err)
+	}
+	groups, err := c.listManagedGroups(ctx)
+	if err != nil {
+		return err
+	}
+
+	var toDelete []string
+	for _, sg := range groups {
+		if strings.HasPrefix(sg.ExternalID, g.Path()+"/") {
+			toDelete = append(toDelete, sg.ID)
+		}
+	}
+	if found != nil {
+		toDelete = append(toDelete, found.ID)
+	}
+	for _, id := range toDelete {
+		err := c.do(ctx, http.MethodDelete, []string{"Groups", id}, nil, nil, nil)
+		if err != nil && idp.KindOf(err) != idp.ErrNotFound {
+			return fmt.Errorf("failed deleting group %s: %w", g.Path(), err)
+		}
+	}
+	return nil
+}
+
+// ListGroups returns all groups managed by the client with their members, parents before their children.
+func (c Client) ListGroups(ctx context.Context) ([]idp.Group, error) {
+	groups, err := c.listManagedGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users, err := listAll[user](ctx, c, "Users", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing users: %w", err)
+	}
+	usersByID := make(map[string]idp.User, len(users))
+	for _, u := range users {
+		usersByID[u.ID] = u.toUser()
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		di, dj := strings.Count(groups[i].ExternalID, "/"), strings.Count(groups[j].ExternalID, "/")
+		if di != dj {
+			return di < dj
+		}
+		return groups[i].ExternalID < groups[j].ExternalID
+	})
+	ids := make(map[string]string, len(groups))
+	res := make([]idp.Group, 0, len(groups))
+	for _, sg := range groups {
+		ids[sg.ExternalID] = sg.ID
+		g := idp.NewGroupFromPath(sg.DisplayName, sg.ExternalID).WithID(sg.ID)
+		if parent, ok := g.Parent(); ok {
+			g = g.WithParentID(ids[parent.Path()])
+		}
+		for _, m := range sg.Members {
+			if u, ok := usersByID[m.Value]; ok {
+				g.Members = append(g.Members, u)
+			}
+		}
+		res = append(res, g)
+	}
+	return res, nil
+}
+
+// PutUser updates the given user referenced by its `Username` property.
+// Empty fields are not changed.
+// An error is returned if a user can't be found.
+func (c Client) PutUser(ctx context.Context, u idp.User) (idp.User, error) {
+	raw, id, err := c.getRawUser(ctx, u.Username)
+	if err != nil {
+		return u, err
+	}
+	if err := applyUser(raw, u); err != nil {
+		return u, err
+	}
+	var updated user
+	if err := c.do(ctx, http.MethodPut, []string{"Users", id}, nil, raw, &updated); err != nil {
+		return u, fmt.Errorf("failed updating user %q: %w", u.Username, err)
+	}
+	return updated.toUser(), nil
+}
+
+// ListUsers returns all users.
+func (c Client) ListUsers(ctx context.Context) ([]idp.User, error) {
+	users, err := listAll[user](ctx, c, "Users", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing users: %w", err)
+	}
+	res := make([]idp.User, len(users))
+	for i, u := range users {
+		res[i] = u.toUser()
+	}
+	return res, nil
+}
+
+// RemoveUserFromGroups removes the user with the given username from all groups managed by the client.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) RemoveUserFromGroups(ctx context.Context, username string) error {
+	_, id, err := c.getRawUser(ctx, username)
+	if errors.Is(err, idp.UserNotFoundError{}) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	groups, err := c.listManagedGroups(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	for _, g := range groups {
+		members := make([]member, 0, len(g.Members))
+		for _, m := range g.Members {
+			if m.Value != id {
+				members = append(members, m)
+			}
+		}
+		if len(members) == len(g.Members) {
+			continue
+		}
+		g.Schemas = []string{schemaGroup}
+		g.Members = members
+		err := c.do(ctx, http.MethodPut, []string{"Groups", g.ID}, nil, g, nil)
+		if err != nil && idp.KindOf(err) != idp.ErrNotFound {
+			errs = multierr.Append(errs, fmt.Errorf("failed removing user %q from group %q: %w", username, g.ExternalID, err))
+		}
+	}
+	return errs
+}
+
+// DisableUser deactivates the user with the given username.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) DisableUser(ctx context.Context, username string) error {
+	raw, id, err := c.getRawUser(ctx, username)
+	if errors.Is(err, idp.UserNotFoundError{}) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := setField(raw, "active", false); err != nil {
+		return err
+	}
+	if err := c.do(ctx, http.MethodPut, []string{"Users", id}, nil, raw, nil); err != nil {
+		return fmt.Errorf("failed disabling user %q: %w", username, err)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with the given username.
+// The method is idempotent and will not do anything if the user does not exist.
+func (c Client) DeleteUser(ctx context.Context, username string) error {
+	_, id, err := c.getRawUser(ctx, username)
+	if errors.Is(err, idp.UserNotFoundError{}) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = c.do(ctx, http.MethodDelete, []string{"Users", id}, nil, nil, nil)
+	if err != nil && idp.KindOf(err) != idp.ErrNotFound {
+		return fmt.Errorf("failed deleting user %q: %w", username, err)
+	}
+	return nil
+}
+
+// Ping checks that the service provider is reachable and accepts the token, by listing users without returning any.
+func (c Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, []string{"Users"}, url.Values{"count": {"0"}}, nil, nil)
+}
+
+// findGroup returns the group with the path of the given group, or nil if there is no such group.
+// If the ID of the given group is known, the group is fetched directly.
+func (c Client) findGroup(ctx context.Context, g idp.Group) (*group, error) {
+	if g.ID() != "" {
+		var found group
+		err := c.do(ctx, http.MethodGet, []string{"Groups", g.ID()}, nil, nil, &found)
+		if err == nil && found.ExternalID == g.Path() {
+			return &found, nil
+		}
+		if err != nil && idp.KindOf(err) != idp.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	groups, err := listAll[group](ctx, c, "Groups", url.Values{"filter": {"externalId eq " + quoteFilterValue(g.Path())}})
+	if err != nil {
+		return nil, err
+	}
+	for _, found := range groups {
+		if found.ExternalID == g.Path() {
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+// listManagedGroups returns all groups with a path as external ID.
+func (c Client) listManagedGroups(ctx context.Context) ([]group, error) {
+	groups, err := listAll[group](ctx, c, "Groups", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing groups: %w", err)
+	}
+	managed := make([]group, 0, len(groups))
+	for _, g := range groups {
+		if strings.HasPrefix(g.ExternalID, "/") {
+			managed = append(managed, g)
+		}
+	}
+	return managed, nil
+}
+
+// resolveMembers looks up the given users by their usernames.
+// Users which don't exist are returned as MembershipSyncErrors.
+// Any other failure to look up a user is returned as error, as the members of the group can't be determined.
+func (c Client) resolveMembers(ctx context.Context, users []idp.User) ([]idp.User, []member, idp.MembershipSyncErrors, error) {
+	resolved := make([]idp.User, 0, len(users))
+	members := make([]member, 0, len(users))
+	var membErr idp.MembershipSyncErrors
+	for _, u := range users {
+		found, err := c.findUser(ctx, u.Username)
+		if errors.Is(err, idp.UserNotFoundError{}) {
+			membErr = append(membErr, idp.MembershipSyncError{Err: err, Username: u.Username, Event: idp.UserAddError})
+			continue
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		resolved = append(resolved, found.toUser())
+		members = append(members, member{Value: found.ID, Type: "User"})
+	}
+	return resolved, members, membErr, nil
+}
+
+// findUser returns the user with the given username, or a UserNotFoundError.
+func (c Client) findUser(ctx context.Context, username string) (user, error) {
+	users, err := listAll[user](ctx, c, "Users", userNameFilter(username))
+	if err != nil {
+		return user{}, fmt.Errorf("failed querying user %q: %w", username, err)
+	}
+	for _, u := range users {
+		if strings.EqualFold(u.UserName, username) {
+			return u, nil
+		}
+	}
+	return user{}, idp.UserNotFoundError{Username: username}
+}
+
+// getRawUser returns the full resource and the ID of the user with the given username, or a UserNotFoundError.
+func (c Client) getRawUser(ctx context.Context, username string) (map[string]json.RawMessage, string, error) {
+	users, err := listAll[map[string]json.RawMessage](ctx, c, "Users", userNameFilter(username))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed querying user %q: %w", username, err)
+	}
+	for _, raw := range users {
+		var u user
+		if err := remarshal(raw, &u); err != nil {
+			return nil, "", err
+		}
+		if strings.EqualFold(u.UserName, username) {
+			return raw, u.ID, nil
+		}
+	}
+	return nil, "", idp.UserNotFoundError{Username: username}
+}
+
+func (c Client) pageSize() int {
+	if c.PageSize > 0 {
+		return c.PageSize
+	}
+	return 100
+}
+
+// listAll requests all pages of the given resource type.
+// SCIM pages start at index 1.
+func listAll[T any](ctx context.Context, c Client, resource string, query url.Values) ([]T, error) {
+	var res []T
+	for {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("startIndex", strconv.Itoa(len(res)+1))
+		q.Set("count", strconv.Itoa(c.pageSize()))
+
+		var page listResponse[T]
+		if err := c.do(ctx, http.MethodGet, []string{resource}, q, nil, &page); err != nil {
+			return nil, err
+		}
+		res = append(res, page.Resources...)
+		if len(page.Resources) == 0 || len(res) >= page.TotalResults {
+			return res, nil
+		}
+	}
+}
+
+// do sends a request to the given path below the base URL.
+// The response is decoded into result, if not nil.
+// Errors are returned as *idp.Error if they can be classified.
+func (c Client) do(ctx context.Context, method string, segments []string, query url.Values, body, result interface{}) error {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	path := strings.Join(escaped, "/")
+	u := c.URL + "/" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if ctx.Err() == nil && errors.As(err, &netErr) {
+			return &idp.Error{Kind: idp.ErrTransient, Err: fmt.Errorf("could not send request to %s: %w", path, err)}
+		}
+		return fmt.Errorf("could not send request to %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg := resp.Status
+		var e errorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Detail != "" {
+			msg = fmt.Sprintf("%s: %s", resp.Status, e.Detail)
+		}
+		err := fmt.Errorf("%s %s: %s", method, path, msg)
+		if kind := classify(resp.StatusCode); kind != "" {
+			return &idp.Error{Kind: kind, Err: err}
+		}
+		return err
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed decoding response of %s %s: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// classify returns the kind of an error response with the given status code.
+func classify(code int) idp.ErrorKind {
+	switch {
+	case code == http.StatusNotFound:
+		return idp.ErrNotFound
+	case code == http.StatusConflict:
+		return idp.ErrConflict
+	case code == http.StatusForbidden:
+		return idp.ErrForbidden
+	case code == http.StatusUnauthorized:
+		return idp.ErrUnauthorized
+	case code == http.StatusTooManyRequests:
+		return idp.ErrRateLimited
+	case code == http.StatusInternalServerError, code == http.StatusBadGateway,
+		code == http.StatusServiceUnavailable, code == http.StatusGatewayTimeout:
+		return idp.ErrTransient
+	}
+	return ""
+}
+
+func userNameFilter(username string) url.Values {
+	return url.Values{"filter": {"userName eq " + quoteFilterValue(username)}}
+}
+
+// groupDisplayName returns the display name of the group, or its name if it has no display name.
+// SCIM requires groups to have a display name.
+func groupDisplayName(g idp.Group) string {
+	if g.DisplayName() != "" {
+		return g.DisplayName()
+	}
+	return g.BaseName()
+}
+
+// sameMembers returns true if both lists contain the same users, ignoring the order.
+func sameMembers(a, b []member) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := make(map[string]struct{}, len(a))
+	for _, m := range a {
+		ids[m.Value] = struct{}{}
+	}
+	for _, m := range b {
+		if _, ok := ids[m.Value]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package scim_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+	. "github.com/vshn/appuio-keycloak-adapter/scim"
+	"github.com/vshn/appuio-keycloak-adapter/scim/scimtest"
+)
+
+func TestClient_PutListDeleteGroups(t *testing.T) {
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+	srv.AddUser("alice")
+	srv.AddUser("bob")
+	srv.AddGroup("Not managed", "")
+	c := NewClient(srv.URL, "token")
+	c.PageSize = 1
+	ctx := context.Background()
+
+	foo, err := c.PutGroup(ctx, idp.NewGroup("Foo Inc.", "foo").WithMemberNames("alice"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, foo.ID())
+	_, err = c.PutGroup(ctx, idp.NewGroup("Foo Team", "foo", "team").WithMemberNames("alice", "bob"))
+	require.NoError(t, err)
+	_, err = c.PutGroup(ctx, idp.NewGroup("", "bar").WithMemberNames("bob"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"", "/bar", "/foo", "/foo/team"}, srv.GroupPaths())
+	assert.Equal(t, []string{"alice", "bob"}, srv.Members("/foo/team"))
+	bar, _ := srv.Group("/bar")
+	assert.Equal(t, "bar", bar["displayName"], "name is used as display name if empty")
+
+	groups, err := c.ListGroups(ctx)
+	require.NoError(t, err)
+	paths := make([]string, len(groups))
+	members := map[string][]string{}
+	for i, g := range groups {
+		paths[i] = g.Path()
+		for _, m := range g.Members {
+			members[g.Path()] = append(members[g.Path()], m.Username)
+		}
+	}
+	assert.Equal(t, []string{"/bar", "/foo", "/foo/team"}, paths, "parents before children")
+	assert.Equal(t, map[string][]string{
+		"/bar":      {"bob"},
+		"/foo":      {"alice"},
+		"/foo/team": {"alice", "bob"},
+	}, members)
+	parent, _ := groups[2].Parent()
+	assert.Equal(t, foo.ID(), parent.ID())
+
+	_, err = c.PutGroup(ctx, idp.NewGroup("Foo AG", "foo").WithID(foo.ID()).WithMemberNames("bob"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, srv.Members("/foo"))
+	g, _ := srv.Group("/foo")
+	assert.Equal(t, "Foo AG", g["displayName"])
+
+	require.NoError(t, c.DeleteGroup(ctx, idp.NewGroup("", "foo")))
+	require.NoError(t, c.DeleteGroup(ctx, idp.NewGroup("", "foo")), "deleting a missing group is idempotent")
+	assert.Equal(t, []string{"", "/bar"}, srv.GroupPaths())
+}
+
+func TestClient_PutGroup_missing_user(t *testing.T) {
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+	srv.AddUser("alice")
+	c := NewClient(srv.URL, "token")
+
+	g, err := c.PutGroup(context.Background(), idp.NewGroup("Foo Inc.", "foo").WithMemberNames("alice", "bob"))
+	var membErr *idp.MembershipSyncErrors
+	require.ErrorAs(t, err, &membErr)
+	require.Len(t, *membErr, 1)
+	assert.Equal(t, "bob", (*membErr)[0].Username)
+	assert.Equal(t, idp.UserAddError, (*membErr)[0].Event)
+	assert.ErrorIs(t, (*membErr)[0], idp.UserNotFoundError{})
+	require.Len(t, g.Members, 1)
+	assert.Equal(t, "alice", g.Members[0].Username)
+	assert.Equal(t, []string{"alice"}, srv.Members("/foo"))
+}
+
+func TestClient_PutGroup_failed_user_lookup(t *testing.T) {
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+	srv.AddUser("alice")
+	srv.AddUser("bob")
+	srv.AddGroup("Foo Inc.", "/foo")
+	srv.AddMember("alice", "/foo")
+	srv.AddMember("bob", "/foo")
+	c := NewClient(srv.URL, "token")
+	ctx := context.Background()
+
+	srv.FailUserLookups("bob", http.StatusServiceUnavailable)
+	_, err := c.PutGroup(ctx, idp.NewGroup("Foo AG", "foo").WithMemberNames("alice", "bob"))
+	require.Error(t, err)
+	assert.True(t, idp.IsRetryable(err), "lookup failure is retried")
+	var membErr *idp.MembershipSyncErrors
+	assert.False(t, errors.As(err, &membErr), "lookup failure is not a membership error")
+	assert.Equal(t, []string{"alice", "bob"}, srv.Members("/foo"), "members kept")
+	foo, _ := srv.Group("/foo")
+	assert.Equal(t, "Foo Inc.", foo["displayName"], "group not changed")
+
+	srv.FailUserLookups("bob", 0)
+	_, err = c.PutGroup(ctx, idp.NewGroup("Foo AG", "foo").WithMemberNames("alice", "bob"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, srv.Members("/foo"))
+}
+
+func TestClient_Users(t *testing.T) {
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+	srv.AddUser("alice")
+	srv.AddUser("alice2")
+	srv.AddGroup("Foo Inc.", "/foo")
+	srv.AddGroup("Other", "other")
+	srv.AddMember("alice", "/foo")
+	srv.AddMember("alice2", "/foo")
+	srv.AddMember("alice", "other")
+	c := NewClient(srv.URL, "token")
+	ctx := context.Background()
+
+	billing := true
+	u, err := c.PutUser(ctx, idp.User{
+		Username:                "alice",
+		Email:                   "alice@example.com",
+		FirstName:               "Alice",
+		PreferredLanguage:       "de",
+		DefaultOrganizationRef:  "foo",
+		BillingContact:          &billing,
+		NotificationPreferences: []string{"maintenance"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", u.Email)
+	assert.Equal(t, "Alice", u.FirstName)
+	assert.Equal(t, "de", u.PreferredLanguage)
+	assert.Equal(t, "foo", u.DefaultOrganizationRef)
+	assert.Equal(t, &billing, u.BillingContact)
+	assert.Equal(t, []string{"maintenance"}, u.NotificationPreferences)
+
+	_, err = c.PutUser(ctx, idp.User{Username: "alice", LastName: "Smith"})
+	require.NoError(t, err)
+	res, _ := srv.User("alice")
+	assert.Equal(t, map[string]interface{}{"givenName": "Alice", "familyName": "Smith"}, res["name"], "empty fields are kept")
+	assert.Contains(t, res["schemas"], SchemaUserExtension)
+
+	_, err = c.PutUser(ctx, idp.User{Username: "bob"})
+	require.ErrorIs(t, err, idp.UserNotFoundError{})
+
+	users, err := c.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "Alice Smith", users[0].DisplayName())
+
+	require.NoError(t, c.RemoveUserFromGroups(ctx, "alice"))
+	assert.Equal(t, []string{"alice2"}, srv.Members("/foo"))
+	assert.Equal(t, []string{"alice"}, srv.Members("other"), "unmanaged groups are not changed")
+
+	require.NoError(t, c.DisableUser(ctx, "alice"))
+	res, _ = srv.User("alice")
+	assert.Equal(t, false, res["active"])
+
+	require.NoError(t, c.DeleteUser(ctx, "alice2"))
+	require.NoError(t, c.DeleteUser(ctx, "alice2"), "deleting a missing user is idempotent")
+	_, ok := srv.User("alice2")
+	assert.False(t, ok)
+	assert.Empty(t, srv.Members("/foo"))
+}
+
+func TestClient_Errors(t *testing.T) {
+	srv := scimtest.NewServer("token")
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "wrong").ListGroups(context.Background())
+	require.ErrorIs(t, err, idp.ErrUnauthorized)
+	assert.False(t, idp.IsPermanent(err), "credentials might be fixed")
+	require.ErrorIs(t, NewClient(srv.URL, "wrong").Ping(context.Background()), idp.ErrUnauthorized)
+	require.NoError(t, NewClient(srv.URL, "token").Ping(context.Background()))
+
+	srv.AddGroup("Foo", "/foo")
+	_, err = NewClient(srv.URL, "token").PutGroup(context.Background(), idp.NewGroup("Foo", "foo").WithID("group-unknown"))
+	require.NoError(t, err, "stale IDs fall back to the path")
+}
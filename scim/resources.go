@@ -0,0 +1,213 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/vshn/appuio-keycloak-adapter/idp"
+)
+
+const (
+	schemaGroup = "urn:ietf:params:scim:schemas:core:2.0:Group"
+
+	// SchemaUserExtension is the schema of the extension storing the APPUiO specific attributes of users.
+	SchemaUserExtension = "urn:appuio:params:scim:schemas:extension:2.0:User"
+)
+
+// user is the subset of the SCIM user resource read by the client.
+type user struct {
+	ID                string         `json:"id"`
+	UserName          string         `json:"userName"`
+	Name              *name          `json:"name,omitempty"`
+	Emails            []email        `json:"emails,omitempty"`
+	PreferredLanguage string         `json:"preferredLanguage,omitempty"`
+	Active            *bool          `json:"active,omitempty"`
+	Extension         *userExtension `json:"urn:appuio:params:scim:schemas:extension:2.0:User,omitempty"`
+}
+
+type name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// userExtension holds the attributes of idp.User without a counterpart in the SCIM core user schema.
+type userExtension struct {
+	DefaultOrganization     string   `json:"defaultOrganization,omitempty"`
+	BillingContact          *bool    `json:"billingContact,omitempty"`
+	NotificationPreferences []string `json:"notificationPreferences,omitempty"`
+}
+
+// group is a SCIM group resource.
+// The path of the group is stored in the external ID.
+type group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId"`
+	DisplayName string   `json:"displayName"`
+	Members     []member `json:"members"`
+}
+
+type member struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type listResponse[T any] struct {
+	TotalResults int `json:"totalResults"`
+	Resources    []T `json:"Resources"`
+}
+
+type errorResponse struct {
+	Detail   string `json:"detail"`
+	ScimType string `json:"scimType"`
+}
+
+// toUser maps the SCIM user to an idp.User.
+func (u user) toUser() idp.User {
+	res := idp.User{
+		ID:                u.ID,
+		Username:          u.UserName,
+		PreferredLanguage: u.PreferredLanguage,
+	}
+	if u.Name != nil {
+		res.FirstName = u.Name.GivenName
+		res.LastName = u.Name.FamilyName
+	}
+	for i, e := range u.Emails {
+		if i == 0 || e.Primary {
+			res.Email = e.Value
+		}
+		if e.Primary {
+			break
+		}
+	}
+	if u.Extension != nil {
+		res.DefaultOrganizationRef = u.Extension.DefaultOrganization
+		res.BillingContact = u.Extension.BillingContact
+		res.NotificationPreferences = u.Extension.NotificationPreferences
+	}
+	return res
+}
+
+// applyUser sets the non-empty fields of the given user on the raw SCIM user resource.
+// Attributes unknown to the client are kept, so the resource can be replaced without losing them.
+func applyUser(raw map[string]json.RawMessage, u idp.User) error {
+	var current user
+	if err := remarshal(raw, &current); err != nil {
+		return err
+	}
+
+	if u.FirstName != "" || u.LastName != "" {
+		n := map[string]json.RawMessage{}
+		if err := unmarshalField(raw, "name", &n); err != nil {
+			return err
+		}
+		if err := setField(n, "givenName", u.FirstName); err != nil {
+			return err
+		}
+		if err := setField(n, "familyName", u.LastName); err != nil {
+			return err
+		}
+		if err := setField(raw, "name", n); err != nil {
+			return err
+		}
+	}
+	if u.Email != "" {
+		emails := current.Emails
+		primary := -1
+		for i, e := range emails {
+			if e.Primary || primary < 0 && i == 0 {
+				primary = i
+			}
+		}
+		if primary < 0 {
+			emails = append(emails, email{Value: u.Email, Type: "work", Primary: true})
+		} else {
+			emails[primary].Value = u.Email
+		}
+		if err := setField(raw, "emails", emails); err != nil {
+			return err
+		}
+	}
+	if err := setField(raw, "preferredLanguage", u.PreferredLanguage); err != nil {
+		return err
+	}
+
+	ext := current.Extension
+	if ext == nil {
+		ext = &userExtension{}
+	}
+	if u.DefaultOrganizationRef != "" {
+		ext.DefaultOrganization = u.DefaultOrganizationRef
+	}
+	if u.BillingContact != nil {
+		ext.BillingContact = u.BillingContact
+	}
+	if u.NotificationPreferences != nil {
+		ext.NotificationPreferences = u.NotificationPreferences
+	}
+	if ext.DefaultOrganization != "" || ext.BillingContact != nil || ext.NotificationPreferences != nil {
+		if err := setField(raw, SchemaUserExtension, ext); err != nil {
+			return err
+		}
+		return addSchema(raw, SchemaUserExtension)
+	}
+	return nil
+}
+
+// addSchema adds the given schema to the `schemas` attribute of the raw resource, if missing.
+func addSchema(raw map[string]json.RawMessage, schema string) error {
+	var schemas []string
+	if err := unmarshalField(raw, "schemas", &schemas); err != nil {
+		return err
+	}
+	for _, s := range schemas {
+		if s == schema {
+			return nil
+		}
+	}
+	return setField(raw, "schemas", append(schemas, schema))
+}
+
+// setField sets the given attribute of the raw resource, unless the value is an empty string.
+func setField(raw map[string]json.RawMessage, key string, v interface{}) error {
+	if s, ok := v.(string); ok && s == "" {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	raw[key] = b
+	return nil
+}
+
+// unmarshalField decodes the given attribute of the raw resource into v, if set.
+func unmarshalField(raw map[string]json.RawMessage, key string, v interface{}) error {
+	b, ok := raw[key]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// remarshal decodes the JSON encoding of in into out.
+func remarshal(in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+// quoteFilterValue quotes the given value for use in a SCIM filter expression.
+func quoteFilterValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
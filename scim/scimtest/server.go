@@ -0,0 +1,431 @@
+// Package scimtest provides an in-memory stand-in for a SCIM 2.0 service provider for end to end tests of the SCIM client.
+package scimtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is an in-memory stand-in for the subset of a SCIM 2.0 service provider used by the adapter.
+// It serves the `/Users` and `/Groups` endpoints, supporting paging and filters of the form `attribute eq "value"`.
+// Resources are stored as plain JSON objects, so attributes unknown to the server are kept.
+type Server struct {
+	*httptest.Server
+
+	// Token is the bearer token required for all requests.
+	// Requests are not authenticated if empty.
+	Token string
+
+	mu sync.Mutex
+
+	nextID int
+	users  map[string]map[string]interface{}
+	groups map[string]map[string]interface{}
+	// lookupFailures are the statuses of failing lookups of users by username.
+	lookupFailures map[string]int
+}
+
+const (
+	schemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// NewServer starts a new SCIM server requiring the given bearer token.
+// The server must be closed by calling Close.
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:          token,
+		users:          map[string]map[string]interface{}{},
+		groups:         map[string]map[string]interface{}{},
+		lookupFailures: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddUser creates an active user with the given username and returns its ID.
+func (s *Server) AddUser(username string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID("user")
+	s.users[id] = map[string]interface{}{
+		"schemas":  []interface{}{schemaUser},
+		"id":       id,
+		"userName": username,
+		"active":   true,
+	}
+	return id
+}
+
+// FailUserLookups makes all requests filtering users by the given username fail with the given status.
+// A status of 0 makes the lookups succeed again.
+func (s *Server) FailUserLookups(username string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if status == 0 {
+		delete(s.lookupFailures, strings.ToLower(username))
+		return
+	}
+	s.lookupFailures[strings.ToLower(username)] = status
+}
+
+// User returns the resource of the user with the given username.
+func (s *Server) User(username string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByName(username)
+	if u == nil {
+		return nil, false
+	}
+	return copyResource(u), true
+}
+
+// AddGroup creates a group with the given display name and external ID and returns its ID.
+// Groups managed by the adapter have their path as external ID.
+func (s *Server) AddGroup(displayName, externalID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID("group")
+	s.groups[id] = map[string]interface{}{
+		"schemas":     []interface{}{schemaGroup},
+		"id":          id,
+		"externalId":  externalID,
+		"displayName": displayName,
+		"members":     []interface{}{},
+	}
+	return id
+}
+
+// AddMember adds the user with the given username to the group with the given external ID.
+// The user and the group must exist.
+func (s *Server) AddMember(username, externalID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.userByName(username)
+	g := s.groupByExternalID(externalID)
+	if u == nil || g == nil {
+		panic(fmt.Sprintf("scimtest: group %q or user %q not found", externalID, username))
+	}
+	g["members"] = append(g["members"].([]interface{}), map[string]interface{}{"value": u["id"]})
+}
+
+// Group returns the resource of the group with the given external ID.
+func (s *Server) Group(externalID string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupByExternalID(externalID)
+	if g == nil {
+		return nil, false
+	}
+	return copyResource(g), true
+}
+
+// GroupPaths returns the external IDs of all groups, sorted.
+func (s *Server) GroupPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.groups))
+	for _, g := range s.groups {
+		if id, ok := g["externalId"].(string); ok {
+			paths = append(paths, id)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Members returns the usernames of the members of the group with the given external ID, sorted.
+// Returns nil if the group does not exist.
+func (s *Server) Members(externalID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g := s.groupByExternalID(externalID)
+	if g == nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, m := range g["members"].([]interface{}) {
+		if u, ok := s.users[fmt.Sprint(m.(map[string]interface{})["value"])]; ok {
+			names = append(names, u["userName"].(string))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "", "Authorization failure")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var resources map[string]map[string]interface{}
+	switch segments[0] {
+	case "Users":
+		resources = s.users
+	case "Groups":
+		resources = s.groups
+	default:
+		writeError(w, http.StatusNotFound, "", "Not found")
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		if status := s.lookupFailure(segments[0], r.URL.Query()); status != 0 {
+			writeError(w, status, "", "Lookup failed")
+			return
+		}
+		s.list(w, r.URL.Query(), resources)
+	case len(segments) == 1 && r.Method == http.MethodPost && segments[0] == "Groups":
+		s.postGroup(w, r)
+	case len(segments) == 2:
+		res, ok := resources[segments[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "", fmt.Sprintf("Resource %s not found", segments[1]))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, res)
+		case http.MethodPut:
+			s.put(w, r, segments[0], res)
+		case http.MethodDelete:
+			s.delete(segments[0], segments[1])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "", "Method not allowed")
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "Method not allowed")
+	}
+}
+
+var filterPattern = regexp.MustCompile(`^(\w+) eq "((?:[^"\\]|\\.)*)"$`)
+
+// list lists the resources matching the `filter` parameter, sorted by ID, paged by the `startIndex` and `count` parameters.
+func (s *Server) list(w http.ResponseWriter, q url.Values, resources map[string]map[string]interface{}) {
+	var attr, value string
+	if f := q.Get("filter"); f != "" {
+		m := filterPattern.FindStringSubmatch(f)
+		if m == nil {
+			writeError(w, http.StatusBadRequest, "invalidFilter", fmt.Sprintf("Unsupported filter %q", f))
+			return
+		}
+		attr, value = m[1], strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[2])
+	}
+
+	matches := make([]map[string]interface{}, 0)
+	for _, id := range sortedIDs(resources) {
+		res := resources[id]
+		if attr != "" {
+			v, _ := res[attr].(string)
+			// userName and displayName are case insensitive, see RFC 7643.
+			if attr == "externalId" || attr == "id" {
+				if v != value {
+					continue
+				}
+			} else if !strings.EqualFold(v, value) {
+				continue
+			}
+		}
+		matches = append(matches, res)
+	}
+
+	total := len(matches)
+	start, _ := strconv.Atoi(q.Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	if start-1 > len(matches) {
+		start = len(matches) + 1
+	}
+	matches = matches[start-1:]
+	if count, err := strconv.Atoi(q.Get("count")); err == nil && count >= 0 && count < len(matches) {
+		matches = matches[:count]
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{schemaListResponse},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": len(matches),
+		"Resources":    matches,
+	})
+}
+
+// lookupFailure returns the status set by FailUserLookups for a request filtering users by username, or 0.
+func (s *Server) lookupFailure(kind string, q url.Values) int {
+	if kind != "Users" {
+		return 0
+	}
+	m := filterPattern.FindStringSubmatch(q.Get("filter"))
+	if m == nil || m[1] != "userName" {
+		return 0
+	}
+	username := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[2])
+	return s.lookupFailures[strings.ToLower(username)]
+}
+
+func (s *Server) postGroup(w http.ResponseWriter, r *http.Request) {
+	var res map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if !s.validGroup(w, "", res) {
+		return
+	}
+	res["id"] = s.newID("group")
+	s.groups[res["id"].(string)] = res
+	w.Header().Set("Location", fmt.Sprintf("%s/Groups/%s", s.URL, res["id"]))
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// put replaces the given resource, keeping its ID.
+func (s *Server) put(w http.ResponseWriter, r *http.Request, kind string, current map[string]interface{}) {
+	var res map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	id := current["id"].(string)
+	res["id"] = id
+	if kind == "Groups" {
+		if !s.validGroup(w, id, res) {
+			return
+		}
+		s.groups[id] = res
+	} else {
+		if name, _ := res["userName"].(string); name == "" {
+			writeError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+			return
+		}
+		s.users[id] = res
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) delete(kind, id string) {
+	if kind == "Groups" {
+		delete(s.groups, id)
+		return
+	}
+	delete(s.users, id)
+	for _, g := range s.groups {
+		members := make([]interface{}, 0)
+		for _, m := range g["members"].([]interface{}) {
+			if m.(map[string]interface{})["value"] != id {
+				members = append(members, m)
+			}
+		}
+		g["members"] = members
+	}
+}
+
+// validGroup checks the display name, the uniqueness of the external ID, and the members of the given group.
+func (s *Server) validGroup(w http.ResponseWriter, id string, g map[string]interface{}) bool {
+	if name, _ := g["displayName"].(string); name == "" {
+		writeError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return false
+	}
+	if extID, ok := g["externalId"].(string); ok {
+		if other := s.groupByExternalID(extID); other != nil && other["id"] != id {
+			writeError(w, http.StatusConflict, "uniqueness", fmt.Sprintf("Group with externalId %q already exists", extID))
+			return false
+		}
+	}
+	members, _ := g["members"].([]interface{})
+	for _, m := range members {
+		mm, _ := m.(map[string]interface{})
+		if _, ok := s.users[fmt.Sprint(mm["value"])]; !ok {
+			writeError(w, http.StatusBadRequest, "invalidValue", fmt.Sprintf("Member %v does not exist", mm["value"]))
+			return false
+		}
+	}
+	if members == nil {
+		g["members"] = []interface{}{}
+	}
+	return true
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", prefix, s.nextID)
+}
+
+func (s *Server) userByName(username string) map[string]interface{} {
+	for _, u := range s.users {
+		if strings.EqualFold(u["userName"].(string), username) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) groupByExternalID(externalID string) map[string]interface{} {
+	for _, g := range s.groups {
+		if g["externalId"] == externalID {
+			return g
+		}
+	}
+	return nil
+}
+
+func sortedIDs(resources map[string]map[string]interface{}) []string {
+	ids := make([]string, 0, len(resources))
+	for id := range resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// copyResource returns a deep copy of the given resource.
+func copyResource(res map[string]interface{}) map[string]interface{} {
+	b, err := json.Marshal(res)
+	if err != nil {
+		panic(err)
+	}
+	var c map[string]interface{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeJSON(w, status, map[string]interface{}{
+		"schemas":  []string{schemaError},
+		"status":   strconv.Itoa(status),
+		"scimType": scimType,
+		"detail":   detail,
+	})
+}
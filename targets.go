@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/vshn/appuio-keycloak-adapter/controllers"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+	"github.com/vshn/appuio-keycloak-adapter/scim"
+)
+
+// keycloakTarget configures a Keycloak realm to sync to.
+type keycloakTarget struct {
+	// Name identifies the target in events and logs.
+	Name string `json:"name"`
+
+	URL        string `json:"url"`
+	Realm      string `json:"realm"`
+	LoginRealm string `json:"loginRealm,omitempty"`
+	RootGroup  string `json:"rootGroup,omitempty"`
+
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ClientID      string `json:"clientID,omitempty"`
+	ClientSecret  string `json:"clientSecret,omitempty"`
+	ClientKeyFile string `json:"clientKeyFile,omitempty"`
+
+	// Backend selects how organizations are represented in the realm, one of `groups` or `organizations`, or `scim` to sync to a SCIM service provider at `url` instead of Keycloak.
+	// Defaults to `groups`.
+	Backend                  string `json:"backend,omitempty"`
+	OrganizationDomainSuffix string `json:"organizationDomainSuffix,omitempty"`
+
+	// TokenFile is a file containing the bearer token for the SCIM backend.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+const (
+	backendGroups        = "groups"
+	backendOrganizations = "organizations"
+	backendSCIM          = "scim"
+)
+
+// keycloakTuning are the settings shared by the clients of all targets.
+type keycloakTuning struct {
+	PageSize     int
+	Concurrency  int
+	MaxDepth     int
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+var targetNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// readKeycloakTargets reads a YAML list of targets from the given file.
+func readKeycloakTargets(file string) ([]keycloakTarget, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var targets []keycloakTarget
+	if err := yaml.UnmarshalStrict(raw, &targets); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return targets, nil
+}
+
+// validateKeycloakTargets checks that all targets have a known backend, a unique, valid name, and a realm unless they are SCIM targets.
+func validateKeycloakTargets(targets []keycloakTarget) error {
+	names := map[string]struct{}{}
+	for _, t := range targets {
+		if t.URL == "" {
+			return fmt.Errorf("target %q: url is required", t.Name)
+		}
+		if t.Realm == "" && t.Backend != backendSCIM {
+			return fmt.Errorf("target %q: realm is required unless the backend is `%s`", t.Name, backendSCIM)
+		}
+		switch t.Backend {
+		case "", backendGroups, backendOrganizations, backendSCIM:
+		default:
+			return fmt.Errorf("target %q: unknown backend %q, must be one of `%s`, `%s`, or `%s`", t.Name, t.Backend, backendGroups, backendOrganizations, backendSCIM)
+		}
+		if !targetNamePattern.MatchString(t.Name) {
+			return fmt.Errorf("invalid target name %q: must consist of alphanumeric characters, '.', '_' or '-'", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = struct{}{}
+	}
+	return nil
+}
+
+// validateUserDeletionPolicy checks that the given policy only changes groups managed by the adapter.
+// Without a root group, removing a user from its groups would remove the user from every group in the realm, so the `remove-from-groups` policy requires a root group for all Keycloak targets.
+// SCIM targets only change groups created by the adapter.
+func validateUserDeletionPolicy(targets []keycloakTarget, policy controllers.UserDeletionPolicy) error {
+	if policy != controllers.UserDeletionPolicyRemoveFromGroups {
+		return nil
+	}
+	for _, t := range targets {
+		if t.Backend != backendSCIM && t.RootGroup == "" {
+			return fmt.Errorf("target %q: the user deletion policy %q requires a root group", t.Name, policy)
+		}
+	}
+	return nil
+}
+
+// newKeycloakClient creates a client for the given target.
+func newKeycloakClient(t keycloakTarget, tuning keycloakTuning) (keycloak.Client, error) {
+	kc := keycloak.NewClient(t.URL, t.Realm, t.Username, t.Password)
+	kc.RootGroup = t.RootGroup
+	kc.LoginRealm = t.LoginRealm
+	kc.PageSize = tuning.PageSize
+	kc.Concurrency = tuning.Concurrency
+	kc.MaxDepth = tuning.MaxDepth
+	kc.MaxRetries = tuning.MaxRetries
+	kc.RetryBackoff = tuning.RetryBackoff
+	kc.ClientID = t.ClientID
+	kc.ClientSecret = t.ClientSecret
+	if t.ClientKeyFile != "" {
+		key, err := os.ReadFile(t.ClientKeyFile)
+		if err != nil {
+			return kc, fmt.Errorf("unable to read Keycloak client key: %w", err)
+		}
+		kc.ClientKey, kc.ClientKeySigningMethod, err = keycloak.ParseClientKey(key)
+		if err != nil {
+			return kc, fmt.Errorf("unable to parse Keycloak client key: %w", err)
+		}
+	}
+	return kc, nil
+}
+
+// newSCIMClient creates a SCIM client for the given target.
+func newSCIMClient(t keycloakTarget, tuning keycloakTuning) (scim.Client, error) {
+	c := scim.NewClient(t.URL, "")
+	c.PageSize = tuning.PageSize
+	if t.TokenFile != "" {
+		token, err := os.ReadFile(t.TokenFile)
+		if err != nil {
+			return c, fmt.Errorf("unable to read SCIM token: %w", err)
+		}
+		c.Token = strings.TrimSpace(string(token))
+	}
+	return c, nil
+}
+
+// syncClient returns the client syncing organizations, teams, and users to the given Keycloak target using the backend of the target.
+func syncClient(t keycloakTarget, kc keycloak.Client) controllers.KeycloakClient {
+	if t.Backend == backendOrganizations {
+		return keycloak.OrganizationsClient{Client: kc, DomainSuffix: t.OrganizationDomainSuffix}
+	}
+	return kc
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vshn/appuio-keycloak-adapter/controllers"
+	"github.com/vshn/appuio-keycloak-adapter/keycloak"
+)
+
+func Test_readKeycloakTargets(t *testing.T) {
+	tcs := map[string]struct {
+		content string
+		targets []keycloakTarget
+		err     string
+	}{
+		"valid": {
+			content: `
+- name: ch-gva-2
+  url: https://id.ch-gva-2.example.com
+  realm: appuio
+  rootGroup: organizations
+  clientID: adapter
+  clientSecret: secret
+- name: scim
+  url: https://scim.example.com
+  backend: scim
+  tokenFile: /token
+`,
+			targets: []keycloakTarget{
+				{
+					Name:         "ch-gva-2",
+					URL:          "https://id.ch-gva-2.example.com",
+					Realm:        "appuio",
+					RootGroup:    "organizations",
+					ClientID:     "adapter",
+					ClientSecret: "secret",
+				},
+				{
+					Name:      "scim",
+					URL:       "https://scim.example.com",
+					Backend:   backendSCIM,
+					TokenFile: "/token",
+				},
+			},
+		},
+		"empty": {
+			content: "",
+		},
+		"unknown field": {
+			content: `
+- name: ch-gva-2
+  url: https://id.ch-gva-2.example.com
+  realm: appuio
+  root-group: organizations
+`,
+			err: `unknown field "root-group"`,
+		},
+		"not a list": {
+			content: `
+name: ch-gva-2
+url: https://id.ch-gva-2.example.com
+`,
+			err: "cannot unmarshal object",
+		},
+		"invalid YAML": {
+			content: "- name: [",
+			err:     "failed to parse",
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "targets.yaml")
+			require.NoError(t, os.WriteFile(file, []byte(tc.content), 0o600))
+
+			targets, err := readKeycloakTargets(file)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.targets, targets)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := readKeycloakTargets(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func Test_validateKeycloakTargets(t *testing.T) {
+	tcs := map[string]struct {
+		targets []keycloakTarget
+		err     string
+	}{
+		"none": {},
+		"valid": {
+			targets: []keycloakTarget{
+				{Name: "ch-gva-2", URL: "https://id.example.com", Realm: "appuio"},
+				{Name: "de_fra.1", URL: "https://id.example.com", Realm: "appuio", Backend: backendOrganizations},
+				{Name: "groups", URL: "https://id.example.com", Realm: "appuio", Backend: backendGroups},
+				{Name: "scim", URL: "https://scim.example.com", Backend: backendSCIM},
+			},
+		},
+		"missing url": {
+			targets: []keycloakTarget{{Name: "a", Realm: "appuio"}},
+			err:     `target "a": url is required`,
+		},
+		"missing url of SCIM target": {
+			targets: []keycloakTarget{{Name: "a", Backend: backendSCIM}},
+			err:     `target "a": url is required`,
+		},
+		"missing realm": {
+			targets: []keycloakTarget{{Name: "a", URL: "https://id.example.com"}},
+			err:     `target "a": realm is required`,
+		},
+		"missing realm of organizations target": {
+			targets: []keycloakTarget{{Name: "a", URL: "https://id.example.com", Backend: backendOrganizations}},
+			err:     `target "a": realm is required`,
+		},
+		"unknown backend": {
+			targets: []keycloakTarget{{Name: "a", URL: "https://id.example.com", Realm: "appuio", Backend: "ldap"}},
+			err:     `target "a": unknown backend "ldap"`,
+		},
+		"missing name": {
+			targets: []keycloakTarget{{URL: "https://id.example.com", Realm: "appuio"}},
+			err:     `invalid target name ""`,
+		},
+		"invalid name": {
+			targets: []keycloakTarget{{Name: "ch gva", URL: "https://id.example.com", Realm: "appuio"}},
+			err:     `invalid target name "ch gva"`,
+		},
+		"duplicate name": {
+			targets: []keycloakTarget{
+				{Name: "a", URL: "https://id.example.com", Realm: "appuio"},
+				{Name: "a", URL: "https://scim.example.com", Backend: backendSCIM},
+			},
+			err: `duplicate target name "a"`,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			err := validateKeycloakTargets(tc.targets)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_validateUserDeletionPolicy(t *testing.T) {
+	tcs := map[string]struct {
+		targets []keycloakTarget
+		policy  controllers.UserDeletionPolicy
+		err     string
+	}{
+		"keep without root group": {
+			targets: []keycloakTarget{{Name: "a"}},
+			policy:  controllers.UserDeletionPolicyKeep,
+		},
+		"delete without root group": {
+			targets: []keycloakTarget{{Name: "a"}},
+			policy:  controllers.UserDeletionPolicyDelete,
+		},
+		"remove from groups with root group": {
+			targets: []keycloakTarget{
+				{Name: "a", RootGroup: "organizations"},
+				{Name: "b", RootGroup: "organizations", Backend: backendOrganizations},
+			},
+			policy: controllers.UserDeletionPolicyRemoveFromGroups,
+		},
+		"remove from groups of SCIM target": {
+			targets: []keycloakTarget{{Name: "scim", Backend: backendSCIM}},
+			policy:  controllers.UserDeletionPolicyRemoveFromGroups,
+		},
+		"remove from groups without root group": {
+			targets: []keycloakTarget{
+				{Name: "a", RootGroup: "organizations"},
+				{Name: "b"},
+			},
+			policy: controllers.UserDeletionPolicyRemoveFromGroups,
+			err:    `target "b": the user deletion policy "remove-from-groups" requires a root group`,
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			err := validateUserDeletionPolicy(tc.targets, tc.policy)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_syncClient(t *testing.T) {
+	kc := keycloak.NewClient("https://id.example.com", "appuio", "admin", "secret")
+
+	tcs := map[string]struct {
+		target keycloakTarget
+		client controllers.KeycloakClient
+	}{
+		"default": {
+			target: keycloakTarget{},
+			client: kc,
+		},
+		"groups": {
+			target: keycloakTarget{Backend: backendGroups},
+			client: kc,
+		},
+		"organizations": {
+			target: keycloakTarget{Backend: backendOrganizations, OrganizationDomainSuffix: "example.com"},
+			client: keycloak.OrganizationsClient{Client: kc, DomainSuffix: "example.com"},
+		},
+	}
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.client, syncClient(tc.target, kc))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// setupTracing configures the global OpenTelemetry tracer provider from the standard `OTEL_*` environment variables.
+// `OTEL_TRACES_EXPORTER` selects the exporter: `otlp` exports over OTLP/HTTP and `console` writes the spans to the given file, or stdout if empty.
+// Tracing is disabled if the variable is not set or set to `none`.
+// The returned function flushes and stops the tracer provider.
+func setupTracing(ctx context.Context, file string) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var out io.Closer
+	switch e := os.Getenv("OTEL_TRACES_EXPORTER"); e {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		var err error
+		exporter, err = otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+	case "console":
+		w := io.Writer(os.Stdout)
+		if file != "" {
+			f, err := os.Create(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open trace file: %w", err)
+			}
+			w, out = f, f
+		}
+		var err error
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create console exporter: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q", e)
+	}
+
+	// Attributes from `OTEL_SERVICE_NAME` and `OTEL_RESOURCE_ATTRIBUTES` take precedence.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(appName), semconv.ServiceVersionKey.String(version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if out != nil {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}